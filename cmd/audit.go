@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os/user"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const redacted = "***"
+
+// MaxLoggedOutput is the size beyond which the outputs in logs and audit
+// records are truncated, 0 for no limit.
+var MaxLoggedOutput = 4 << 10
+
+var (
+	redactMu    sync.RWMutex
+	redactRules = []*regexp.Regexp{
+		// virt-install --graphics vnc,password=x, curl -d token=x...
+		regexp.MustCompile(`(?i)\b(?:password|passwd|secret|token)=('[^']*'|[^\s,&]+)`),
+		regexp.MustCompile(`(?i)--(?:password|passwd|secret|token)[= ]('[^']*'|\S+)`),
+		// iscsiadm -m node -o update -n node.session.auth.password -v x
+		regexp.MustCompile(`node\.session\.auth\.password(?:_in)? -v ('[^']*'|\S+)`),
+	}
+)
+
+// AddRedactRule adds a regexp matching secrets to hide in logged or audited
+// command lines and outputs. The text of its groups is replaced by "***",
+// or the whole match if it has none.
+func AddRedactRule(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	redactRules = append(redactRules, re)
+
+	return nil
+}
+
+// Redact returns s with the secrets matching the redaction rules replaced by
+// "***".
+func Redact(s string) string {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+
+	for _, re := range redactRules {
+		s = redactRule(re, s)
+	}
+	return s
+}
+
+func redactRule(re *regexp.Regexp, s string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		spans := m[2:]
+		if len(spans) == 0 {
+			spans = m[:2]
+		}
+		for i := 0; i < len(spans); i += 2 {
+			if spans[i] < last {
+				continue
+			}
+			out = append(out, s[last:spans[i]]...)
+			out = append(out, redacted...)
+			last = spans[i+1]
+		}
+	}
+	return string(append(out, s[last:]...))
+}
+
+// Truncate returns s cut to MaxLoggedOutput bytes, without splitting a UTF-8
+// encoded rune.
+func Truncate(s string) string {
+	if MaxLoggedOutput <= 0 || len(s) <= MaxLoggedOutput {
+		return s
+	}
+	n := MaxLoggedOutput
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + fmt.Sprintf("... (%d bytes truncated)", len(s)-n)
+}
+
+// logError is zap.Error for the error of a command, its output being
+// redacted and truncated.
+func logError(err error) zap.Field {
+	if err == nil {
+		return zap.Skip()
+	}
+	return zap.String("error", Truncate(Redact(err.Error())))
+}
+
+// AuditRecord is a command that was run, for an audit trail. Cmd, Err and
+// Output are redacted and truncated.
+type AuditRecord struct {
+	// Actor is who asked for the command, see WithActor.
+	Actor string
+	// User is the system user running the command.
+	User string
+	// Host is the host the command ran on, empty for the local host.
+	Host     string
+	Cmd      string
+	Start    time.Time
+	Duration time.Duration
+	ExitCode int
+	Err      string
+	Output   string
+}
+
+// AuditSink receives the audit records. It is called synchronously, after
+// each command, and must be safe for concurrent use.
+type AuditSink interface {
+	Audit(ctx context.Context, r *AuditRecord)
+}
+
+// AuditFunc is an AuditSink function.
+type AuditFunc func(ctx context.Context, r *AuditRecord)
+
+func (f AuditFunc) Audit(ctx context.Context, r *AuditRecord) {
+	f(ctx, r)
+}
+
+var (
+	auditMu   sync.RWMutex
+	auditSink AuditSink
+)
+
+// SetAuditSink sets the sink receiving a record of each command run by the
+// Cmd* helpers, streams and remote executors, nil to disable auditing.
+func SetAuditSink(s AuditSink) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	auditSink = s
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose commands are audited as asked by actor,
+// e.g. the account of an API request.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+var (
+	localUserOnce sync.Once
+	localUser     string
+)
+
+func currentUser() string {
+	localUserOnce.Do(func() {
+		if u, err := user.Current(); err == nil {
+			localUser = u.Username
+		}
+	})
+	return localUser
+}
+
+// NewAuditRecord returns the audit record of c run locally with opt.
+func NewAuditRecord(c *Command, opt *Option, res *Result, err error) *AuditRecord {
+	r := &AuditRecord{
+		User:     currentUser(),
+		Cmd:      c.String(),
+		Start:    time.Now().Add(-res.Duration),
+		Duration: res.Duration,
+		ExitCode: res.ExitCode,
+		Output:   string(res.Combined),
+	}
+	if opt != nil && opt.Credential != nil {
+		r.User = strconv.FormatUint(uint64(opt.Credential.Uid), 10)
+		if u, err := user.LookupId(r.User); err == nil {
+			r.User = u.Username
+		}
+	}
+	if err != nil {
+		r.Err = err.Error()
+	}
+	return r
+}
+
+// Audit sends r to the audit sink, if any, after redacting it.
+func Audit(ctx context.Context, r *AuditRecord) {
+	auditMu.RLock()
+	sink := auditSink
+	auditMu.RUnlock()
+	if sink == nil {
+		return
+	}
+
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && r.Actor == "" {
+		r.Actor = actor
+	}
+	r.Cmd = Redact(r.Cmd)
+	r.Err = Truncate(Redact(r.Err))
+	r.Output = Truncate(Redact(r.Output))
+
+	sink.Audit(ctx, r)
+}
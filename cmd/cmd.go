@@ -3,12 +3,9 @@ package cmd
 import (
 	"bytes"
 	"context"
-	"errors"
-	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"strings"
 	"syscall"
 	"time"
 
@@ -22,47 +19,23 @@ type Option struct {
 	Timeout     time.Duration
 	Stdin       io.Reader
 	SysProcAttr *syscall.SysProcAttr
+
+	// Setpgid runs the commands in a new process group, killed as a whole on
+	// timeout or cancel, so that the children of `bash -c` don't survive.
+	Setpgid bool
+	// Credential runs the commands as another user, which requires root.
+	Credential *syscall.Credential
+	// Limits restricts the resources of the commands.
+	Limits *Limits
+
+	// Combined captures stdout and stderr through a single pipe, like
+	// exec.Cmd.CombinedOutput, into Result.Combined, keeping their order.
+	// The Cmd* helpers set it.
+	Combined bool
 }
 
 func CmdCombinedWithCtx(ctx context.Context, opt *Option, name string, args ...string) ([]byte, error) {
-	if opt == nil {
-		opt = &Option{}
-	}
-
-	now := time.Now()
-
-	if opt.Timeout.Seconds() > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, opt.Timeout)
-		defer cancel()
-	}
-
-	cmd := exec.CommandContext(ctx, name, args...)
-	cmd.Env = append(os.Environ(), "LANG=POSIX")
-	if opt.SysProcAttr != nil {
-		cmd.SysProcAttr = opt.SysProcAttr
-	}
-	if opt.Stdin != nil {
-		cmd.Stdin = opt.Stdin
-	}
-
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		var exerr *exec.ExitError
-		if errors.As(err, &exerr) && len(out) > 0 {
-			err = errors.New(string(out))
-		}
-
-		if !opt.IgnoreErr {
-			log.Glog.Error("exec", zap.String("cmd", fmt.Sprintf("%s %s", name, strings.Join(args, " "))), zap.Error(err), zap.Int64("duration", time.Since(now).Milliseconds()))
-		} else {
-			log.Glog.Debug("exec", zap.String("cmd", fmt.Sprintf("%s %s", name, strings.Join(args, " "))), zap.Error(err), zap.Int64("duration", time.Since(now).Milliseconds()))
-		}
-	} else {
-		log.Glog.Debug("exec", zap.String("cmd", fmt.Sprintf("%s %s", name, strings.Join(args, " "))), zap.Int64("duration", time.Since(now).Milliseconds()))
-	}
-
-	return bytes.TrimSpace(out), err
+	return cmdCombined(ctx, opt, commandString(name, args), New(name, args...))
 }
 
 func CmdCombined(opt *Option, name string, args ...string) ([]byte, error) {
@@ -74,70 +47,76 @@ func CmdCombined(opt *Option, name string, args ...string) ([]byte, error) {
 }
 
 func CmdCombinedBashWithCtx(ctx context.Context, opt *Option, in string) ([]byte, error) {
+	return cmdCombined(ctx, opt, in, New("bash", "-c", in))
+}
+
+func CmdCombinedBash(opt *Option, in string) ([]byte, error) {
 	if opt == nil {
 		opt = &Option{}
 	}
 
-	now := time.Now()
-
-	if opt.Timeout.Seconds() > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, opt.Timeout)
-		defer cancel()
-	}
+	return CmdCombinedBashWithCtx(context.TODO(), opt, in)
+}
 
-	cmd := exec.CommandContext(ctx, "bash", "-c", in)
-	cmd.Env = append(os.Environ(), "LANG=POSIX")
-	if opt.SysProcAttr != nil {
-		cmd.SysProcAttr = opt.SysProcAttr
-	}
-	if opt.Stdin != nil {
-		cmd.Stdin = opt.Stdin
+func CmdCombinedWithStdin(opt *Option, reader io.Reader, name string, args ...string) ([]byte, error) {
+	o := Option{}
+	if opt != nil {
+		o = *opt
 	}
+	o.Stdin = reader
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		var exerr *exec.ExitError
-		if errors.As(err, &exerr) && len(out) > 0 {
-			err = errors.New(string(out))
-		}
+	return CmdCombinedWithCtx(context.TODO(), &o, name, args...)
+}
 
-		if !opt.IgnoreErr {
-			log.Glog.Error("exec", zap.String("cmd", in), zap.Error(err), zap.Int64("duration", time.Since(now).Milliseconds()))
-		} else {
-			log.Glog.Debug("exec", zap.String("cmd", in), zap.Error(err), zap.Int64("duration", time.Since(now).Milliseconds()))
-		}
-	} else {
-		log.Glog.Debug("exec", zap.String("cmd", in), zap.Int64("duration", time.Since(now).Milliseconds()))
-	}
+// CmdCombinedCommandWithCtx runs c, without a shell, and returns its combined
+// output.
+func CmdCombinedCommandWithCtx(ctx context.Context, opt *Option, c *Command) ([]byte, error) {
+	return cmdCombined(ctx, opt, c.String(), c)
+}
 
-	return bytes.TrimSpace(out), err
+func CmdCombinedCommand(opt *Option, c *Command) ([]byte, error) {
+	return CmdCombinedCommandWithCtx(context.TODO(), opt, c)
 }
 
-func CmdCombinedBash(opt *Option, in string) ([]byte, error) {
-	if opt == nil {
-		opt = &Option{}
+// CmdCombinedOn is CmdCombinedCommandWithCtx running c with e, e.g. on a
+// remote host, DefaultExecutor if e is nil.
+func CmdCombinedOn(ctx context.Context, e Executor, opt *Option, c *Command) ([]byte, error) {
+	if e == nil {
+		e = DefaultExecutor
 	}
 
-	return CmdCombinedBashWithCtx(context.TODO(), opt, in)
+	return cmdCombinedOn(ctx, e, opt, c.String(), c)
 }
 
-func CmdCombinedWithStdin(opt *Option, reader io.Reader, name string, args ...string) ([]byte, error) {
-	now := time.Now()
-
-	cmd := exec.Command(name, args...)
-	cmd.Env = append(os.Environ(), "LANG=POSIX")
+// cmdCombined runs c with DefaultExecutor, logged as display, and returns its
+// combined output. A failed command returns an *ExitError.
+func cmdCombined(ctx context.Context, opt *Option, display string, c *Command) ([]byte, error) {
+	return cmdCombinedOn(ctx, DefaultExecutor, opt, display, c)
+}
 
-	cmd.Stdin = reader
-	out, err := cmd.CombinedOutput()
+func cmdCombinedOn(ctx context.Context, e Executor, opt *Option, display string, c *Command) ([]byte, error) {
+	if opt == nil {
+		opt = &Option{}
+	}
 
+	o := *opt
+	o.Combined = true
+	res, err := e.RunCommand(ctx, &o, c)
+	if res == nil {
+		res = &Result{ExitCode: -1}
+	}
+	display = Redact(display)
 	if err != nil {
-		log.Glog.Error("exec", zap.String("cmd", fmt.Sprintf("%s %s", name, strings.Join(args, " "))), zap.Error(err), zap.Int64("duration", time.Since(now).Milliseconds()))
+		if !opt.IgnoreErr {
+			log.Glog.Error("exec", zap.String("cmd", display), logError(err), zap.Int("code", res.ExitCode), zap.Int64("duration", res.Duration.Milliseconds()))
+		} else {
+			log.Glog.Debug("exec", zap.String("cmd", display), logError(err), zap.Int("code", res.ExitCode), zap.Int64("duration", res.Duration.Milliseconds()))
+		}
 	} else {
-		log.Glog.Debug("exec", zap.String("cmd", fmt.Sprintf("%s %s", name, strings.Join(args, " "))), zap.Int64("duration", time.Since(now).Milliseconds()))
+		log.Glog.Debug("exec", zap.String("cmd", display), zap.Int64("duration", res.Duration.Milliseconds()))
 	}
 
-	return bytes.TrimSpace(out), err
+	return bytes.TrimSpace(res.Combined), err
 }
 
 type CmdStreamControl struct {
@@ -147,30 +126,34 @@ type CmdStreamControl struct {
 	StdoutReader io.ReadCloser
 }
 
+// CmdStdoutStreamWithBash starts cmdIn with bash and returns its stdout. Close
+// kills it.
+//
+// Deprecated: use StartStream, which delivers lines, keeps a bounded tail of
+// stderr and stops the command gracefully.
 func CmdStdoutStreamWithBash(cmdIn string) (*CmdStreamControl, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cmd := exec.CommandContext(ctx, "bash", "-c", cmdIn)
 	cmd.Env = append(os.Environ(), "LANG=POSIX")
 
-	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
-	cmd.Stderr = stderr
+	cmd.Stderr = newRingBuffer(defaultStderrLimit)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		cancel()
-		log.Glog.Error("exec stream", zap.String("cmd", cmdIn), zap.Bool("byBash", true), zap.Error(err))
+		log.Glog.Error("exec stream", zap.String("cmd", Redact(cmdIn)), zap.Bool("byBash", true), zap.Error(err))
 
 		return nil, err
 	}
 
 	if err = cmd.Start(); err != nil {
 		cancel()
-		log.Glog.Error("exec stream start failed", zap.String("cmd", cmdIn), zap.Bool("byBash", true), zap.Error(err))
+		log.Glog.Error("exec stream start failed", zap.String("cmd", Redact(cmdIn)), zap.Bool("byBash", true), zap.Error(err))
 
 		return nil, err
 	}
 
-	log.Glog.Debug("exec stream start success", zap.String("cmd", cmdIn), zap.Bool("byBash", true))
+	log.Glog.Debug("exec stream start success", zap.String("cmd", Redact(cmdIn)), zap.Bool("byBash", true))
 
 	return &CmdStreamControl{
 		cmd:          cmd,
@@ -186,11 +169,10 @@ func (c *CmdStreamControl) Close() error {
 	// distinguish between ExitError (which is actually a non-problem for us)
 	// vs failed wait syscall (for which we give upper layers the chance to retyr)
 	{
-		var buf *bytes.Buffer
-		var ok bool
-
-		if buf, ok = c.cmd.Stderr.(*bytes.Buffer); ok && buf.Len() > 0 {
-			log.Glog.Debug("CmdStreamControl stderr", zap.String("err", buf.String()))
+		if buf, ok := c.cmd.Stderr.(*ringBuffer); ok {
+			if stderr := buf.Bytes(); len(stderr) > 0 {
+				log.Glog.Debug("CmdStreamControl stderr", zap.String("err", Truncate(Redact(string(stderr)))))
+			}
 		}
 	}
 
@@ -0,0 +1,19 @@
+// Package cmdtest provides helpers to test code running commands with the
+// cmd package.
+package cmdtest
+
+import (
+	"testing"
+
+	"github.com/meilihao/golib/v2/cmd"
+)
+
+// AssertLint fails the test for every issue cmd.Lint finds in c, e.g. a flag
+// and its value in the same arg after a command string was migrated off bash.
+func AssertLint(t testing.TB, c *cmd.Command) {
+	t.Helper()
+
+	for _, issue := range cmd.Lint(c) {
+		t.Errorf("cmd lint: %s", issue)
+	}
+}
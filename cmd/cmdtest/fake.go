@@ -0,0 +1,168 @@
+package cmdtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/meilihao/golib/v2/cmd"
+)
+
+// Fake is a cmd.Executor returning canned results for the commands matching
+// its rules, checked in the order they were added. Unmatched commands fail
+// the test, unless golden files are used, see Golden.
+type Fake struct {
+	t testing.TB
+
+	mu     sync.Mutex
+	rules  []*Reply
+	golden string
+	calls  []string
+}
+
+// NewFake returns a Fake reporting unexpected commands to t.
+func NewFake(t testing.TB) *Fake {
+	return &Fake{t: t}
+}
+
+// Install makes f the cmd.DefaultExecutor until the end of the test.
+func Install(t testing.TB, f cmd.Executor) {
+	old := cmd.DefaultExecutor
+	cmd.DefaultExecutor = f
+	t.Cleanup(func() { cmd.DefaultExecutor = old })
+}
+
+// Reply is the canned result of the commands matching a rule. It exits 0
+// with no output unless set otherwise.
+type Reply struct {
+	match func(c *cmd.Command) bool
+	desc  string
+
+	stdout, stderr string
+	exitCode       int
+	err            error
+	golden         string
+}
+
+// On matches commands whose String() is cmdline, e.g.
+// "udevadm info /dev/sg1 | grep 'E: ID_PATH='".
+func (f *Fake) On(cmdline string) *Reply {
+	return f.add(cmdline, func(c *cmd.Command) bool { return c.String() == cmdline })
+}
+
+// OnArgv matches a single command with exactly this argv.
+func (f *Fake) OnArgv(name string, args ...string) *Reply {
+	return f.add(cmd.New(name, args...).String(), func(c *cmd.Command) bool {
+		stages := c.Stages()
+		if len(stages) != 1 || stages[0].Name != name || len(stages[0].Args) != len(args) {
+			return false
+		}
+		for i := range args {
+			if stages[0].Args[i] != args[i] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// OnRegexp matches commands whose String() matches expr.
+func (f *Fake) OnRegexp(expr string) *Reply {
+	re := regexp.MustCompile(expr)
+	return f.add(expr, func(c *cmd.Command) bool { return re.MatchString(c.String()) })
+}
+
+func (f *Fake) add(desc string, match func(c *cmd.Command) bool) *Reply {
+	r := &Reply{match: match, desc: desc}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, r)
+
+	return r
+}
+
+// Stdout sets the output of the command.
+func (r *Reply) Stdout(s string) *Reply {
+	r.stdout = s
+	return r
+}
+
+// Stderr sets the error output of the command.
+func (r *Reply) Stderr(s string) *Reply {
+	r.stderr = s
+	return r
+}
+
+// Exit sets the exit status of the command.
+func (r *Reply) Exit(code int) *Reply {
+	r.exitCode = code
+	return r
+}
+
+// Err makes the command fail to start with err, e.g. exec.ErrNotFound.
+func (r *Reply) Err(err error) *Reply {
+	r.err = err
+	return r
+}
+
+// Golden replies with the content of a golden file, see WriteGolden.
+func (r *Reply) Golden(path string) *Reply {
+	r.golden = path
+	return r
+}
+
+// Calls returns the commands run so far, as their String().
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.calls...)
+}
+
+// RunCommand implements cmd.Executor.
+func (f *Fake) RunCommand(ctx context.Context, opt *cmd.Option, c *cmd.Command) (*cmd.Result, error) {
+	cmdline := c.String()
+
+	f.mu.Lock()
+	f.calls = append(f.calls, cmdline)
+	var reply *Reply
+	for _, r := range f.rules {
+		if r.match(c) {
+			reply = r
+			break
+		}
+	}
+	golden := f.golden
+	f.mu.Unlock()
+
+	if reply == nil {
+		if golden != "" {
+			return f.runGolden(ctx, opt, c, golden)
+		}
+
+		f.t.Errorf("cmdtest: unexpected command: %s", cmdline)
+		return &cmd.Result{ExitCode: -1}, fmt.Errorf("cmdtest: unexpected command: %s", cmdline)
+	}
+
+	if reply.err != nil {
+		return &cmd.Result{ExitCode: -1}, reply.err
+	}
+	if reply.golden != "" {
+		g, err := ReadGolden(reply.golden)
+		if err != nil {
+			f.t.Errorf("cmdtest: %s: %v", cmdline, err)
+			return &cmd.Result{ExitCode: -1}, err
+		}
+		return g.result(cmdline)
+	}
+
+	g := &Golden{Cmd: cmdline, Stdout: reply.stdout, Stderr: reply.stderr, ExitCode: reply.exitCode}
+	return g.result(cmdline)
+}
+
+// errGoldenMissing is returned for a command without golden file.
+var errGoldenMissing = errors.New("cmdtest: no golden file, run the test with -cmdtest.record to create it")
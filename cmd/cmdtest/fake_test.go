@@ -0,0 +1,122 @@
+package cmdtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/meilihao/golib/v2/cmd"
+)
+
+// errorRecorder records the errors reported to it instead of failing.
+type errorRecorder struct {
+	testing.TB
+	errs []string
+}
+
+func (r *errorRecorder) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestFake(t *testing.T) {
+	f := NewFake(t)
+	f.OnArgv("lsscsi", "-g").Stdout("[0:0:0:0] mediumx\n")
+	f.On("udevadm info /dev/sg1 | grep 'E: ID_PATH='").Exit(1)
+	f.OnRegexp(`^virsh define `).Stderr("error: failed").Exit(2)
+	f.OnArgv("osinfo-query").Err(exec.ErrNotFound)
+	Install(t, f)
+
+	out, err := cmd.CmdCombined(nil, "lsscsi", "-g")
+	if err != nil || string(out) != "[0:0:0:0] mediumx" {
+		t.Errorf("unexpected %q %v", out, err)
+	}
+
+	_, err = cmd.CmdCombinedCommand(&cmd.Option{IgnoreErr: true},
+		cmd.New("udevadm", "info", "/dev/sg1").Pipe(cmd.New("grep", "E: ID_PATH=")))
+	if cmd.ExitCode(err) != 1 {
+		t.Errorf("expected exit code 1, got %v", err)
+	}
+
+	_, err = cmd.CmdCombinedCommand(nil, cmd.New("virsh", "define", "/etc/libvirt/qemu/vm.xml"))
+	if cmd.ExitCode(err) != 2 || err.Error() != "error: failed" {
+		t.Errorf("expected exit code 2 and the output as error, got %v", err)
+	}
+
+	if _, err = cmd.CmdCombined(nil, "osinfo-query"); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("expected exec.ErrNotFound, got %v", err)
+	}
+
+	expected := []string{"lsscsi -g", "udevadm info /dev/sg1 | grep 'E: ID_PATH='", "virsh define /etc/libvirt/qemu/vm.xml", "osinfo-query"}
+	if fmt.Sprint(f.Calls()) != fmt.Sprint(expected) {
+		t.Errorf("expected calls %v, got %v", expected, f.Calls())
+	}
+}
+
+func TestFakeUnexpected(t *testing.T) {
+	r := &errorRecorder{TB: t}
+	f := NewFake(r)
+
+	if _, err := f.RunCommand(context.Background(), nil, cmd.New("reboot")); err == nil {
+		t.Error("expected an error")
+	}
+	if len(r.errs) != 1 {
+		t.Errorf("expected the unexpected command to be reported, got %v", r.errs)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	c := cmd.New("udevadm", "info", "/dev/sg1")
+	g := &Golden{Cmd: c.String(), Stdout: "E: ID_PATH=a\n\n", Stderr: "warn", ExitCode: 3}
+	if err := WriteGolden(filepath.Join(dir, GoldenName(c)), g); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &errorRecorder{TB: t}
+	f := NewFake(r).Golden(dir)
+	res, err := f.RunCommand(context.Background(), nil, c)
+	if cmd.ExitCode(err) != 3 {
+		t.Errorf("expected exit code 3, got %v", err)
+	}
+	if string(res.Stdout) != g.Stdout || string(res.Stderr) != g.Stderr {
+		t.Errorf("expected the golden output, got %q %q", res.Stdout, res.Stderr)
+	}
+
+	if _, err = f.RunCommand(context.Background(), nil, cmd.New("lsscsi")); !errors.Is(err, errGoldenMissing) {
+		t.Errorf("expected errGoldenMissing, got %v", err)
+	}
+	if len(r.errs) != 1 {
+		t.Errorf("expected the missing golden file to be reported, got %v", r.errs)
+	}
+}
+
+func TestGoldenRecord(t *testing.T) {
+	*record = true
+	defer func() { *record = false }()
+
+	dir := t.TempDir()
+	c := cmd.New("sh", "-c", "echo out; echo err >&2")
+	f := NewFake(t).Golden(dir)
+
+	out, err := cmd.CmdCombinedOn(context.Background(), f, nil, c)
+	if err != nil || string(out) != "out\nerr" {
+		t.Errorf("unexpected %q %v", out, err)
+	}
+
+	g, err := ReadGolden(filepath.Join(dir, GoldenName(c)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Stdout != "out\n" || g.Stderr != "err\n" || g.ExitCode != 0 {
+		t.Errorf("expected the output to be recorded, got %+v", g)
+	}
+
+	*record = false
+	out, err = cmd.CmdCombinedOn(context.Background(), f, nil, c)
+	if err != nil || string(out) != "out\nerr" {
+		t.Errorf("expected the recorded output, got %q %v", out, err)
+	}
+}
@@ -0,0 +1,162 @@
+package cmdtest
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/meilihao/golib/v2/cmd"
+)
+
+var record = flag.Bool("cmdtest.record", false, "run commands without golden file for real and record them")
+
+// Golden is a recorded command result. Golden files are plain text so that
+// they can be written or edited by hand:
+//
+//	$ lsscsi -g
+//	exit: 0
+//	--- stdout
+//	[0:0:0:0]    disk    ATA      ST1000DM003  CC45  /dev/sda   /dev/sg0
+//	--- stderr
+type Golden struct {
+	Cmd      string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+const (
+	goldenStdout = "--- stdout\n"
+	goldenStderr = "\n--- stderr\n"
+)
+
+// ReadGolden reads a golden file.
+func ReadGolden(path string) (*Golden, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+
+	g := &Golden{}
+	i := strings.Index(s, goldenStdout)
+	if i == -1 {
+		return nil, fmt.Errorf("%s: missing %q", path, strings.TrimSpace(goldenStdout))
+	}
+	for _, line := range strings.Split(s[:i], "\n") {
+		switch {
+		case strings.HasPrefix(line, "$ "):
+			g.Cmd = strings.TrimPrefix(line, "$ ")
+		case strings.HasPrefix(line, "exit: "):
+			if g.ExitCode, err = strconv.Atoi(strings.TrimPrefix(line, "exit: ")); err != nil {
+				return nil, fmt.Errorf("%s: bad exit code: %v", path, err)
+			}
+		}
+	}
+
+	s = s[i+len(goldenStdout):]
+	if j := strings.LastIndex(s, goldenStderr); j != -1 {
+		g.Stdout, g.Stderr = s[:j], s[j+len(goldenStderr):]
+	} else {
+		g.Stdout = s
+	}
+
+	return g, nil
+}
+
+// WriteGolden writes g to path, creating its directory.
+func WriteGolden(path string, g *Golden) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "$ %s\nexit: %d\n", g.Cmd, g.ExitCode)
+	sb.WriteString(goldenStdout)
+	sb.WriteString(g.Stdout)
+	sb.WriteString(goldenStderr)
+	sb.WriteString(g.Stderr)
+
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+// GoldenName returns the file name of the golden file of c.
+func GoldenName(c *cmd.Command) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, c.String())
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name + ".golden"
+}
+
+// result returns the cmd.Result of g, with an *cmd.ExitError for a non-zero
+// exit status.
+func (g *Golden) result(cmdline string) (*cmd.Result, error) {
+	res := &cmd.Result{
+		Stdout:   []byte(g.Stdout),
+		Stderr:   []byte(g.Stderr),
+		Combined: []byte(g.Stdout + g.Stderr),
+		ExitCode: g.ExitCode,
+	}
+	if g.ExitCode != 0 {
+		return res, cmd.NewExitError(cmdline, res)
+	}
+	return res, nil
+}
+
+// Golden replies to the commands matching no rule with the golden file named
+// after them in dir, see GoldenName. With -cmdtest.record, missing golden
+// files are recorded by running the commands for real.
+func (f *Fake) Golden(dir string) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.golden = dir
+	return f
+}
+
+func (f *Fake) runGolden(ctx context.Context, opt *cmd.Option, c *cmd.Command, dir string) (*cmd.Result, error) {
+	cmdline := c.String()
+	path := filepath.Join(dir, GoldenName(c))
+
+	g, err := ReadGolden(path)
+	if os.IsNotExist(err) && *record {
+		// Record stdout and stderr apart, as the Cmd* helpers set
+		// Option.Combined, and reply as a replay of the golden file would.
+		o := cmd.Option{}
+		if opt != nil {
+			o = *opt
+		}
+		o.Combined = false
+
+		res, err := cmd.DefaultRunner.RunCommand(ctx, &o, c)
+		if res.ExitCode == -1 {
+			return res, err
+		}
+
+		g = &Golden{Cmd: cmdline, Stdout: string(res.Stdout), Stderr: string(res.Stderr), ExitCode: res.ExitCode}
+		if werr := WriteGolden(path, g); werr != nil {
+			f.t.Errorf("cmdtest: %s: %v", cmdline, werr)
+		}
+		return g.result(cmdline)
+	}
+	if os.IsNotExist(err) {
+		err = errGoldenMissing
+	}
+	if err != nil {
+		f.t.Errorf("cmdtest: %s: %v", cmdline, err)
+		return &cmd.Result{ExitCode: -1}, err
+	}
+
+	return g.result(cmdline)
+}
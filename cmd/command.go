@@ -0,0 +1,322 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Command is an argv run without a shell, so its args need no quoting,
+// optionally piped into further commands.
+//
+//	cmd.New("udevadm", "info", dev).Pipe(cmd.New("grep", "E: ID_PATH="))
+type Command struct {
+	Name string
+	Args []string
+	// Env is added to the environment, overriding inherited variables.
+	Env []string
+	// ClearEnv starts from an empty environment instead of os.Environ().
+	ClearEnv bool
+	Dir      string
+
+	secret map[int]bool // indexes of the secret Args
+	next   *Command
+}
+
+// New returns a Command running name with args.
+func New(name string, args ...string) *Command {
+	return &Command{
+		Name: name,
+		Args: append([]string(nil), args...),
+	}
+}
+
+// Arg appends args.
+func (c *Command) Arg(args ...string) *Command {
+	c.Args = append(c.Args, args...)
+	return c
+}
+
+// SecretArg appends args rendered as "***" by String, e.g. a password, so
+// that they are not logged.
+func (c *Command) SecretArg(args ...string) *Command {
+	if c.secret == nil {
+		c.secret = make(map[int]bool)
+	}
+	for _, a := range args {
+		c.secret[len(c.Args)] = true
+		c.Args = append(c.Args, a)
+	}
+	return c
+}
+
+// ArgIf appends args if cond is true.
+func (c *Command) ArgIf(cond bool, args ...string) *Command {
+	if cond {
+		c.Args = append(c.Args, args...)
+	}
+	return c
+}
+
+// SetEnv sets the environment variable key to value.
+func (c *Command) SetEnv(key, value string) *Command {
+	c.Env = append(c.Env, key+"="+value)
+	return c
+}
+
+// Pipe connects the stdout of the last command of c to the stdin of next,
+// like a shell pipeline, and returns c.
+func (c *Command) Pipe(next *Command) *Command {
+	last := c
+	for last.next != nil {
+		last = last.next
+	}
+	last.next = next
+	return c
+}
+
+// Stages returns the commands of the pipeline, c first.
+func (c *Command) Stages() []*Command {
+	var ls []*Command
+	for s := c; s != nil; s = s.next {
+		ls = append(ls, s)
+	}
+	return ls
+}
+
+// String renders the pipeline with its args quoted for a POSIX shell, for
+// logs or to reproduce it by hand. Secret args are rendered as "***".
+func (c *Command) String() string {
+	var parts []string
+	for _, s := range c.Stages() {
+		words := make([]string, 0, len(s.Args)+len(s.Env)+1)
+		for _, kv := range s.Env {
+			if i := strings.Index(kv, "="); i > 0 {
+				words = append(words, kv[:i+1]+Quote(kv[i+1:]))
+			}
+		}
+		words = append(words, Quote(s.Name))
+		for i, a := range s.Args {
+			if s.secret[i] {
+				words = append(words, redacted)
+				continue
+			}
+			words = append(words, Quote(a))
+		}
+		parts = append(parts, strings.Join(words, " "))
+	}
+	return strings.Join(parts, " | ")
+}
+
+func (c *Command) environ() []string {
+	var env []string
+	if !c.ClearEnv {
+		env = append(os.Environ(), "LANG=POSIX")
+	}
+	// exec keeps the last value of duplicated keys
+	return append(env, c.Env...)
+}
+
+// Quote quotes s for a POSIX shell if needed.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if strings.IndexFunc(s, needQuote) == -1 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func needQuote(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("-_./:=,+@%", r)
+}
+
+// shellOperators are passed literally in an argv, they are likely left over
+// from a shell string.
+var shellOperators = map[string]bool{
+	"|": true, "||": true, "&&": true, "&": true, ";": true,
+	">": true, ">>": true, "<": true, "2>&1": true, "2>": true,
+}
+
+// Lint reports args that only make sense to a shell, e.g. from a command
+// string that was split by hand: operators, substitutions, quotes, or a flag
+// and its value in the same arg.
+func Lint(c *Command) []string {
+	var issues []string
+	for _, s := range c.Stages() {
+		if s.Name == "" {
+			issues = append(issues, "empty command name")
+		} else if strings.ContainsAny(s.Name, " \t\n") {
+			issues = append(issues, fmt.Sprintf("%q: name contains spaces, split it into args", s.Name))
+		}
+
+		for _, a := range s.Args {
+			switch {
+			case shellOperators[a]:
+				issues = append(issues, fmt.Sprintf("%s: shell operator %q is passed literally, use Pipe", s.Name, a))
+			case strings.Contains(a, "$(") || strings.Contains(a, "`"):
+				issues = append(issues, fmt.Sprintf("%s: %q: command substitution is not expanded", s.Name, a))
+			case len(a) > 1 && (a[0] == '\'' || a[0] == '"') && a[len(a)-1] == a[0]:
+				issues = append(issues, fmt.Sprintf("%s: %q: quotes are passed literally", s.Name, a))
+			case strings.HasPrefix(a, "-") && strings.ContainsAny(a, " \t"):
+				issues = append(issues, fmt.Sprintf("%s: %q: flag and value in one arg", s.Name, a))
+			}
+		}
+	}
+	return issues
+}
+
+// RunCommand runs c, a pipeline being run like bash with pipefail: the
+// status reported is the one of the last command that failed. It is
+// audited, see SetAuditSink.
+func (r *Runner) RunCommand(ctx context.Context, opt *Option, c *Command) (*Result, error) {
+	if opt == nil {
+		opt = &Option{}
+	}
+
+	if opt.Timeout.Seconds() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.Timeout)
+		defer cancel()
+	}
+
+	out, err := newOutput(opt.Combined)
+	if err != nil {
+		return &Result{ExitCode: -1}, err
+	}
+
+	cmds := newCmds(c, opt)
+	for _, cmd := range cmds {
+		cmd.Stderr = out.stderrWriter()
+	}
+	cmds[len(cmds)-1].Stdout = out.stdoutWriter()
+
+	now := time.Now()
+	err = startPipeline(cmds, opt)
+	if err == nil {
+		stop := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				signalPipeline(cmds, opt.Setpgid, syscall.SIGKILL)
+			case <-stop:
+			}
+		}()
+		err = waitPipeline(cmds)
+		close(stop)
+	}
+
+	res := out.result(time.Since(now))
+	exitStatus(res, cmds)
+
+	if exerr, ok := err.(*exec.ExitError); ok {
+		err = &ExitError{
+			Cmd:    c.String(),
+			Result: res,
+			Err:    exerr,
+			ctxErr: ctx.Err(),
+		}
+	}
+	Audit(ctx, NewAuditRecord(c, opt, res, err))
+
+	return res, err
+}
+
+// exitStatus sets the exit code and signal of res from the rightmost command
+// of cmds that failed, like `set -o pipefail`, -1 if it did not run.
+func exitStatus(res *Result, cmds []*exec.Cmd) {
+	res.ExitCode = -1
+	failed := cmds[len(cmds)-1]
+	for i := len(cmds) - 1; i >= 0; i-- {
+		if st := cmds[i].ProcessState; st != nil && !st.Success() {
+			failed = cmds[i]
+			break
+		}
+	}
+	if st := failed.ProcessState; st != nil {
+		res.ExitCode = st.ExitCode()
+		if ws, ok := st.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			res.Signal = ws.Signal()
+		}
+	}
+}
+
+// startPipeline connects and starts cmds, in the process group of the first
+// one with opt.Setpgid, and applies opt.Limits. On failure, the started
+// commands are killed and waited for.
+func startPipeline(cmds []*exec.Cmd, opt *Option) error {
+	if opt.Limits != nil {
+		// only the thread that started a traced process can detach it
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	var files []*os.File
+	defer func() {
+		// the children have their own copies
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for i := 0; i < len(cmds)-1; i++ {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		files = append(files, pr, pw)
+		cmds[i].Stdout = pw
+		cmds[i+1].Stdin = pr
+	}
+
+	for i, cmd := range cmds {
+		if opt.Setpgid && i > 0 {
+			cmd.SysProcAttr.Pgid = cmds[0].Process.Pid
+		}
+
+		if err := cmd.Start(); err != nil {
+			killPipeline(cmds[:i], opt.Setpgid)
+			return err
+		}
+		if opt.Limits != nil {
+			if err := opt.Limits.applyStopped(cmd.Process.Pid); err != nil {
+				killPipeline(cmds[:i+1], opt.Setpgid)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// killPipeline kills and waits for the started cmds.
+func killPipeline(cmds []*exec.Cmd, group bool) {
+	signalPipeline(cmds, group, syscall.SIGKILL)
+	for _, cmd := range cmds {
+		cmd.Wait()
+	}
+}
+
+// waitPipeline waits for all cmds and returns the error of the last one that
+// failed.
+func waitPipeline(cmds []*exec.Cmd) error {
+	errs := make([]error, len(cmds))
+	for i, cmd := range cmds {
+		errs[i] = cmd.Wait()
+	}
+	for i := len(errs) - 1; i >= 0; i-- {
+		if errs[i] != nil {
+			return errs[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"", "''"},
+		{"/dev/sg1", "/dev/sg1"},
+		{"path=/a,bus=virtio", "path=/a,bus=virtio"},
+		{"a b", "'a b'"},
+		{"a;rm -rf /", "'a;rm -rf /'"},
+		{"it's", `'it'\''s'`},
+	}
+	for _, test := range tests {
+		if actual := Quote(test.in); actual != test.out {
+			t.Errorf("Quote(%q): expected %s, got %s", test.in, test.out, actual)
+		}
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	c := New("udevadm", "info", "/dev/my disk").Pipe(New("grep", "E: ID_PATH=").SetEnv("LC_ALL", "C"))
+	expected := `udevadm info '/dev/my disk' | LC_ALL=C grep 'E: ID_PATH='`
+	if c.String() != expected {
+		t.Errorf("expected %s, got %s", expected, c.String())
+	}
+}
+
+func TestLint(t *testing.T) {
+	if issues := Lint(New("virt-xml", "vm 1", "--edit", "--vcpus", "4")); len(issues) != 0 {
+		t.Errorf("expected no issue, got %v", issues)
+	}
+
+	tests := []struct {
+		c     *Command
+		issue string
+	}{
+		{New("virsh define"), "name contains spaces"},
+		{New("lsscsi", "-g", "|", "grep", "tape"), "shell operator"},
+		{New("echo", "$(id)"), "command substitution"},
+		{New("grep", "'E: ID_PATH='"), "quotes"},
+		{New("virt-xml", "vm", "--vcpus 4"), "flag and value"},
+	}
+	for _, test := range tests {
+		issues := Lint(test.c)
+		if len(issues) != 1 || !strings.Contains(issues[0], test.issue) {
+			t.Errorf("%s: expected %q, got %v", test.c, test.issue, issues)
+		}
+	}
+}
+
+func TestRunCommandNoShell(t *testing.T) {
+	res, err := DefaultRunner.RunCommand(context.Background(), nil, New("printf", "%s", "a; echo injected"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Stdout) != "a; echo injected" {
+		t.Errorf("expected the arg passed literally, got %q", res.Stdout)
+	}
+}
+
+func TestRunCommandPipeline(t *testing.T) {
+	res, err := DefaultRunner.RunCommand(context.Background(), nil,
+		New("printf", "E: ID_PATH=a\nE: ID_BUS=b\n").Pipe(New("grep", "ID_PATH")).Pipe(New("tr", "a-z", "A-Z")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Stdout) != "E: ID_PATH=A\n" {
+		t.Errorf("unexpected output %q", res.Stdout)
+	}
+
+	// pipefail: the last failing command sets the status
+	res, err = DefaultRunner.RunCommand(context.Background(), nil, New("sh", "-c", "exit 3").Pipe(New("cat")))
+	if ExitCode(err) != 3 || res.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d %v", res.ExitCode, err)
+	}
+	_, err = DefaultRunner.RunCommand(context.Background(), nil, New("sh", "-c", "exit 3").Pipe(New("grep", "x")))
+	if ExitCode(err) != 1 {
+		t.Errorf("expected exit code 1 of grep, got %v", err)
+	}
+
+	_, err = DefaultRunner.RunCommand(context.Background(), nil, New("echo").Pipe(New("golib-no-such-command")))
+	if err == nil || ExitCode(err) != -1 {
+		t.Errorf("expected a start error, got %v", err)
+	}
+}
+
+func TestRunCommandEnv(t *testing.T) {
+	c := New("env").SetEnv("GOLIB_TEST", "1")
+	c.ClearEnv = true
+	res, err := DefaultRunner.RunCommand(context.Background(), nil, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Stdout) != "GOLIB_TEST=1\n" {
+		t.Errorf("expected only the set variable, got %q", res.Stdout)
+	}
+
+	res, err = DefaultRunner.RunCommand(context.Background(), nil, New("sh", "-c", "echo $LANG").SetEnv("LANG", "C.UTF-8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Stdout) != "C.UTF-8\n" {
+		t.Errorf("expected LANG to be overridden, got %q", res.Stdout)
+	}
+}
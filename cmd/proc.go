@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Limits restricts the resources of commands. Each command is traced with
+// ptrace(2) so that it stops right after its exec, before it runs anything,
+// and the limits are applied to it then. They are inherited by its children.
+// Commands with Limits fail to start where ptrace is denied, e.g. by a
+// seccomp profile.
+type Limits struct {
+	// CPUTime is RLIMIT_CPU: the command gets SIGXCPU, then SIGKILL, once it
+	// used this much CPU time. Rounded up to the second.
+	CPUTime time.Duration
+	// Memory is RLIMIT_AS, the size of the address space in bytes.
+	Memory uint64
+	// Nice is the niceness, from -20 to 19. Negative values require root.
+	Nice int
+	// IOClass and IOLevel set the I/O scheduling class and priority, from 0
+	// (highest) to 7, like ionice(1).
+	IOClass IOClass
+	IOLevel int
+	// Cgroup is the directory of an existing cgroup v2 the commands are
+	// moved to, e.g. "/sys/fs/cgroup/backup.slice".
+	Cgroup string
+}
+
+// IOClass is an I/O scheduling class, see ioprio_set(2).
+type IOClass int
+
+const (
+	IOClassNone IOClass = iota
+	IOClassRealtime
+	IOClassBestEffort
+	IOClassIdle
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// apply applies l to the process pid.
+func (l *Limits) apply(pid int) error {
+	if l.Cgroup != "" {
+		procs := filepath.Join(l.Cgroup, "cgroup.procs")
+		if err := os.WriteFile(procs, []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("cmd: cgroup: %w", err)
+		}
+	}
+	if l.CPUTime > 0 {
+		secs := uint64((l.CPUTime + time.Second - 1) / time.Second)
+		if err := unix.Prlimit(pid, unix.RLIMIT_CPU, &unix.Rlimit{Cur: secs, Max: secs}, nil); err != nil {
+			return fmt.Errorf("cmd: cpu limit: %w", err)
+		}
+	}
+	if l.Memory > 0 {
+		if err := unix.Prlimit(pid, unix.RLIMIT_AS, &unix.Rlimit{Cur: l.Memory, Max: l.Memory}, nil); err != nil {
+			return fmt.Errorf("cmd: memory limit: %w", err)
+		}
+	}
+	if l.Nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, pid, l.Nice); err != nil {
+			return fmt.Errorf("cmd: nice: %w", err)
+		}
+	}
+	if l.IOClass != IOClassNone {
+		prio := int(l.IOClass)<<ioprioClassShift | l.IOLevel
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio)); errno != 0 {
+			return fmt.Errorf("cmd: ionice: %w", errno)
+		}
+	}
+	return nil
+}
+
+// applyStopped applies l to the process pid, stopped at its exec as it is
+// traced, and resumes it. It must be called from the thread that started pid.
+func (l *Limits) applyStopped(pid int) error {
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, syscall.WALL, nil); err != nil {
+		return fmt.Errorf("cmd: limits: %w", err)
+	}
+	if !ws.Stopped() {
+		return fmt.Errorf("cmd: limits: process %d not stopped at exec", pid)
+	}
+
+	err := l.apply(pid)
+	if derr := syscall.PtraceDetach(pid); err == nil && derr != nil {
+		err = fmt.Errorf("cmd: limits: %w", derr)
+	}
+	return err
+}
+
+// sysProcAttr returns the SysProcAttr of a command run with opt, a copy as
+// the commands of a pipeline need their own.
+func (opt *Option) sysProcAttr() *syscall.SysProcAttr {
+	if opt.SysProcAttr == nil && !opt.Setpgid && opt.Credential == nil && opt.Limits == nil {
+		return nil
+	}
+
+	attr := &syscall.SysProcAttr{}
+	if opt.SysProcAttr != nil {
+		*attr = *opt.SysProcAttr
+	}
+	if opt.Setpgid {
+		attr.Setpgid = true
+	}
+	if opt.Credential != nil {
+		attr.Credential = opt.Credential
+	}
+	if opt.Limits != nil {
+		attr.Ptrace = true
+	}
+	return attr
+}
+
+// newCmds returns the commands of the stages of c configured with opt.
+func newCmds(c *Command, opt *Option) []*exec.Cmd {
+	stages := c.Stages()
+	cmds := make([]*exec.Cmd, len(stages))
+	for i, s := range stages {
+		cmd := exec.Command(s.Name, s.Args...)
+		cmd.Env = s.environ()
+		cmd.Dir = s.Dir
+		cmd.SysProcAttr = opt.sysProcAttr()
+		cmds[i] = cmd
+	}
+	if opt.Stdin != nil {
+		cmds[0].Stdin = opt.Stdin
+	}
+	return cmds
+}
+
+// signalPipeline sends sig to the started cmds, to their whole process group
+// if they were started with Setpgid.
+func signalPipeline(cmds []*exec.Cmd, group bool, sig syscall.Signal) {
+	if len(cmds) == 0 {
+		return
+	}
+	if group && cmds[0].Process != nil {
+		syscall.Kill(-cmds[0].Process.Pid, sig)
+		return
+	}
+	for _, cmd := range cmds {
+		if cmd.Process != nil {
+			cmd.Process.Signal(sig)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSetpgidKillsChildren(t *testing.T) {
+	// without a group kill, the orphaned sleep keeps stdout open until it
+	// exits
+	start := time.Now()
+	_, err := DefaultRunner.Run(context.Background(), &Option{Setpgid: true, Timeout: 100 * time.Millisecond}, "sh", "-c", "sleep 5 & wait")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("expected the children to be killed, took %v", d)
+	}
+}
+
+func TestSetpgidPipeline(t *testing.T) {
+	pgrp := `cut -d' ' -f5 /proc/$$/stat`
+	res, err := DefaultRunner.RunCommand(context.Background(), &Option{Setpgid: true},
+		New("sh", "-c", pgrp).Pipe(New("sh", "-c", "cat; "+pgrp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := strings.Fields(string(res.Stdout))
+	if len(groups) != 2 || groups[0] != groups[1] || groups[0] == strconv.Itoa(syscall.Getpgrp()) {
+		t.Errorf("expected the pipeline in its own process group, got %q", groups)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cgroup := t.TempDir()
+	if err := os.WriteFile(filepath.Join(cgroup, "cgroup.procs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := &Option{Limits: &Limits{
+		CPUTime: 1500 * time.Millisecond,
+		Memory:  1 << 30,
+		Nice:    5,
+		IOClass: IOClassIdle,
+		Cgroup:  cgroup,
+	}}
+	// the limits are applied before the shell runs, so its children have them
+	res, err := DefaultRunner.RunCommand(context.Background(), opt,
+		New("sh", "-c", `ulimit -t; ulimit -v; nice`).Pipe(New("sh", "-c", `cat; cut -d' ' -f19 /proc/$$/stat`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Fields(string(res.Stdout)); strings.Join(s, " ") != "2 1048576 5 5" {
+		t.Errorf("unexpected limits %q", s)
+	}
+
+	procs, err := os.ReadFile(filepath.Join(cgroup, "cgroup.procs"))
+	if err != nil || len(procs) == 0 {
+		t.Errorf("expected the pid in the cgroup, got %q %v", procs, err)
+	}
+}
+
+func TestLimitsError(t *testing.T) {
+	opt := &Option{Limits: &Limits{Cgroup: filepath.Join(t.TempDir(), "missing")}}
+	if _, err := DefaultRunner.Run(context.Background(), opt, "true"); err == nil {
+		t.Error("expected an error for a missing cgroup")
+	}
+}
+
+func TestCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	res, err := DefaultRunner.Run(context.Background(), &Option{Credential: &syscall.Credential{Uid: 65534, Gid: 65534}}, "id", "-u")
+	if err != nil || strings.TrimSpace(string(res.Stdout)) != "65534" {
+		t.Errorf("expected to run as 65534, got %q %v", res.Stdout, err)
+	}
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Result is the outcome of a command.
+type Result struct {
+	// Stdout and Stderr are captured separately, unless Option.Combined is
+	// set.
+	Stdout []byte
+	Stderr []byte
+	// Combined is stdout and stderr through a single pipe, in the order they
+	// were written, if Option.Combined is set.
+	Combined []byte
+	// ExitCode is the exit status of the process, -1 if it was killed by a
+	// signal or could not be started.
+	ExitCode int
+	Duration time.Duration
+	// Signal is the signal that terminated the process, 0 if it exited.
+	Signal syscall.Signal
+}
+
+// ExitError is returned when a command exits with a non-zero status or is
+// killed by a signal. It wraps the *exec.ExitError of local commands, and
+// matches context.DeadlineExceeded or context.Canceled with errors.Is if the
+// command was killed because its context was done.
+type ExitError struct {
+	Cmd    string
+	Result *Result
+	Err    *exec.ExitError // nil if the command did not run locally
+
+	ctxErr error
+}
+
+// NewExitError returns the error of cmdline failing with res, for Executors
+// that don't run local processes.
+func NewExitError(cmdline string, res *Result) *ExitError {
+	return &ExitError{
+		Cmd:    cmdline,
+		Result: res,
+	}
+}
+
+// Error returns the output of the command, like the error returned by
+// CmdCombined: the combined output, or stderr else stdout if they were
+// captured separately. It is the exit status if there is no output. The
+// output is not redacted, the logs of this package are, see Redact.
+func (e *ExitError) Error() string {
+	for _, out := range [][]byte{e.Result.Combined, e.Result.Stderr, e.Result.Stdout} {
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			return string(out)
+		}
+	}
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	if e.Result.Signal != 0 {
+		return "signal: " + e.Result.Signal.String()
+	}
+	return fmt.Sprintf("exit status %d", e.Result.ExitCode)
+}
+
+func (e *ExitError) Unwrap() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e.Err
+}
+
+func (e *ExitError) Is(target error) bool {
+	return e.ctxErr != nil && target == e.ctxErr
+}
+
+// ExitCode returns the exit status of the command that failed with err, 0 if
+// err is nil and -1 if err is not an *ExitError.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exerr *ExitError
+	if errors.As(err, &exerr) {
+		return exerr.Result.ExitCode
+	}
+	return -1
+}
+
+// Executor runs commands. Runner runs them locally, cmdtest.Fake replays
+// canned results in tests.
+type Executor interface {
+	RunCommand(ctx context.Context, opt *Option, c *Command) (*Result, error)
+}
+
+// Runner runs local commands, capturing stdout and stderr separately, or
+// combined with Option.Combined.
+type Runner struct{}
+
+// DefaultRunner runs local commands.
+var DefaultRunner = &Runner{}
+
+// DefaultExecutor is used by the Cmd* helpers. Tests replace it, see
+// cmdtest.Install.
+var DefaultExecutor Executor = DefaultRunner
+
+// Run runs name with args. A non-zero exit is reported as an *ExitError, the
+// Result is returned in all cases.
+func (r *Runner) Run(ctx context.Context, opt *Option, name string, args ...string) (*Result, error) {
+	return r.RunCommand(ctx, opt, New(name, args...))
+}
+
+// output captures stdout and stderr, separately, or combined through a
+// single pipe so that their order is kept.
+type output struct {
+	mu             sync.Mutex
+	stdout, stderr bytes.Buffer
+
+	combined bytes.Buffer
+	// pw is the write end of the combined pipe, nil if separate
+	pw   *os.File
+	done chan struct{}
+}
+
+func newOutput(combined bool) (*output, error) {
+	o := &output{}
+	if !combined {
+		return o, nil
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	o.pw, o.done = pw, make(chan struct{})
+	go func() {
+		io.Copy(&o.combined, pr)
+		pr.Close()
+		close(o.done)
+	}()
+	return o, nil
+}
+
+func (o *output) stdoutWriter() io.Writer {
+	if o.pw != nil {
+		return o.pw
+	}
+	return &o.stdout
+}
+
+// stderrWriter returns the stderr of a command, shared by the commands of a
+// pipeline.
+func (o *output) stderrWriter() io.Writer {
+	if o.pw != nil {
+		return o.pw
+	}
+	return &lockedWriter{mu: &o.mu, w: &o.stderr}
+}
+
+// result returns the output once the commands exited.
+func (o *output) result(duration time.Duration) *Result {
+	if o.pw != nil {
+		// the children have their own copies
+		o.pw.Close()
+		<-o.done
+	}
+	return &Result{
+		Stdout:   o.stdout.Bytes(),
+		Stderr:   o.stderr.Bytes(),
+		Combined: o.combined.Bytes(),
+		Duration: duration,
+	}
+}
+
+// lockedWriter serializes the writes of the commands of a pipeline to the
+// same buffer.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func commandString(name string, args []string) string {
+	return fmt.Sprintf("%s %s", name, strings.Join(args, " "))
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunnerResult(t *testing.T) {
+	res, err := DefaultRunner.Run(context.Background(), nil, "sh", "-c", "echo out; echo err >&2; exit 3")
+
+	var exerr *ExitError
+	if !errors.As(err, &exerr) {
+		t.Fatalf("expected an *ExitError, got %T %v", err, err)
+	}
+	var execErr *exec.ExitError
+	if !errors.As(err, &execErr) {
+		t.Error("expected the *exec.ExitError to be wrapped")
+	}
+	if exerr.Result != res || ExitCode(err) != 3 {
+		t.Errorf("expected exit code 3, got %d", ExitCode(err))
+	}
+	if string(res.Stdout) != "out\n" || string(res.Stderr) != "err\n" || res.Combined != nil {
+		t.Errorf("unexpected output %q %q %q", res.Stdout, res.Stderr, res.Combined)
+	}
+	if exerr.Error() != "err" {
+		t.Errorf("expected stderr as error message, got %q", exerr.Error())
+	}
+}
+
+func TestRunnerCombined(t *testing.T) {
+	script := "for i in 1 2 3; do echo out$i; echo err$i >&2; done; exit 3"
+	res, err := DefaultRunner.Run(context.Background(), &Option{Combined: true}, "sh", "-c", script)
+
+	want := "out1\nerr1\nout2\nerr2\nout3\nerr3\n"
+	if string(res.Combined) != want || res.Stdout != nil || res.Stderr != nil {
+		t.Errorf("expected the output in order, got %q %q %q", res.Combined, res.Stdout, res.Stderr)
+	}
+	if err == nil || err.Error() != strings.TrimSpace(want) {
+		t.Errorf("expected the combined output as error message, got %v", err)
+	}
+}
+
+func TestRunnerSignal(t *testing.T) {
+	res, err := DefaultRunner.Run(context.Background(), nil, "sh", "-c", "kill -TERM $$")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if res.Signal != syscall.SIGTERM || res.ExitCode != -1 {
+		t.Errorf("expected SIGTERM, got %v and exit code %d", res.Signal, res.ExitCode)
+	}
+}
+
+func TestRunnerTimeout(t *testing.T) {
+	_, err := DefaultRunner.Run(context.Background(), &Option{Timeout: 50 * time.Millisecond}, "sleep", "5")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestRunnerNotFound(t *testing.T) {
+	res, err := DefaultRunner.Run(context.Background(), nil, "golib-no-such-command")
+	if err == nil || ExitCode(err) != -1 || res.ExitCode != -1 {
+		t.Errorf("expected a start error, got %v", err)
+	}
+}
+
+func TestCmdCombinedExitError(t *testing.T) {
+	out, err := CmdCombinedBash(&Option{IgnoreErr: true}, "echo failed; echo reason >&2; echo end; exit 2")
+	if string(out) != "failed\nreason\nend" || err == nil || err.Error() != "failed\nreason\nend" {
+		t.Errorf("expected the output as error, got %q %v", out, err)
+	}
+	if ExitCode(err) != 2 {
+		t.Errorf("expected exit code 2, got %d", ExitCode(err))
+	}
+}
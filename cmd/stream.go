@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/meilihao/golib/v2/log"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStderrLimit = 64 << 10
+	defaultGrace       = 10 * time.Second
+	maxLineSize        = 1 << 20
+)
+
+// Line is a line of output of a Stream.
+type Line struct {
+	Stderr bool
+	Text   string
+}
+
+// ProgressParser extracts a percentage from a line of output, ok is false if
+// the line has none.
+type ProgressParser func(line string) (percent float64, ok bool)
+
+var qemuImgProgressRe = regexp.MustCompile(`\((\d+(?:\.\d+)?)/100%\)`)
+
+// QemuImgProgress parses the output of `qemu-img convert -p`: "(42.01/100%)".
+func QemuImgProgress(line string) (float64, bool) {
+	m := qemuImgProgressRe.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(m[1], 64)
+	return p, err == nil
+}
+
+var ddProgressRe = regexp.MustCompile(`^(\d+) bytes`)
+
+// DDProgress parses the output of `dd status=progress` copying total bytes:
+// "1048576000 bytes (1.0 GB, 1000 MiB) copied, 5 s, 210 MB/s".
+func DDProgress(total int64) ProgressParser {
+	return func(line string) (float64, bool) {
+		m := ddProgressRe.FindStringSubmatch(line)
+		if m == nil || total <= 0 {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return float64(n) * 100 / float64(total), true
+	}
+}
+
+// StreamOption configures a Stream.
+type StreamOption struct {
+	Option
+
+	// OnStdout and OnStderr receive each line of output, without the line
+	// terminator. Lines are split on "\n" or "\r", for progress bars. They are
+	// called from two different goroutines.
+	OnStdout func(line string)
+	OnStderr func(line string)
+	// Lines, if not nil, receives every line too. It is not closed. Lines
+	// are sent by the goroutines reading the output, so a full channel
+	// blocks them, and then the command once the pipe buffer is full:
+	// receive from it until the command exits, or use a buffered channel.
+	Lines chan<- Line
+
+	// StderrLimit is the size of the tail of stderr kept for the Result and
+	// the error, 64KiB by default.
+	StderrLimit int
+	// Grace is the delay between SIGTERM and SIGKILL when the stream is
+	// stopped, or its context done or timed out. 10 seconds by default.
+	Grace time.Duration
+
+	// Progress parses the lines of stdout and stderr, and OnProgress is
+	// called each time the integer percentage changes, e.g. with
+	// taskmanager.TaskSteper.SetProgress.
+	Progress   ProgressParser
+	OnProgress func(percent int)
+}
+
+// Stream is a running command whose output is delivered line by line.
+type Stream struct {
+	c      *Command
+	opt    *StreamOption
+	cmds   []*exec.Cmd
+	stderr *ringBuffer
+
+	progressMu sync.Mutex
+	progress   int
+
+	stopOnce sync.Once
+	done     chan struct{}
+	res      *Result
+	err      error
+}
+
+// StartStream starts c, a pipeline streams the stdout of its last command
+// and the stderr of all. Stdout is not kept in the Result, Stderr and
+// Combined hold the tail of stderr.
+func StartStream(ctx context.Context, c *Command, opt *StreamOption) (*Stream, error) {
+	if opt == nil {
+		opt = &StreamOption{}
+	}
+	if opt.StderrLimit <= 0 {
+		opt.StderrLimit = defaultStderrLimit
+	}
+	if opt.Grace <= 0 {
+		opt.Grace = defaultGrace
+	}
+
+	var cancel context.CancelFunc = func() {}
+	if opt.Timeout.Seconds() > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opt.Timeout)
+	}
+
+	s := &Stream{
+		c:        c,
+		opt:      opt,
+		stderr:   newRingBuffer(opt.StderrLimit),
+		progress: -1,
+		done:     make(chan struct{}),
+	}
+
+	s.cmds = newCmds(c, &opt.Option)
+
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		cancel()
+		outR.Close()
+		outW.Close()
+		return nil, err
+	}
+	s.cmds[len(s.cmds)-1].Stdout = outW
+	for _, cmd := range s.cmds {
+		cmd.Stderr = errW
+	}
+
+	start := time.Now()
+	err = startPipeline(s.cmds, &opt.Option)
+	// the children have their own copies
+	outW.Close()
+	errW.Close()
+	if err != nil {
+		cancel()
+		outR.Close()
+		errR.Close()
+		log.Glog.Error("exec stream start failed", zap.String("cmd", Redact(c.String())), logError(err))
+
+		return nil, err
+	}
+	log.Glog.Debug("exec stream start success", zap.String("cmd", Redact(c.String())))
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		s.readLines(outR, false)
+	}()
+	go func() {
+		defer readers.Done()
+		s.readLines(errR, true)
+	}()
+
+	go func() {
+		defer cancel()
+
+		err := waitPipeline(s.cmds)
+		readers.Wait()
+
+		res := &Result{
+			Stderr:   s.stderr.Bytes(),
+			Combined: s.stderr.Bytes(),
+			Duration: time.Since(start),
+		}
+		exitStatus(res, s.cmds)
+		if exerr, ok := err.(*exec.ExitError); ok {
+			err = &ExitError{
+				Cmd:    c.String(),
+				Result: res,
+				Err:    exerr,
+				ctxErr: ctx.Err(),
+			}
+		}
+
+		if err != nil && !opt.IgnoreErr {
+			log.Glog.Error("exec stream", zap.String("cmd", Redact(c.String())), logError(err), zap.Int("code", res.ExitCode), zap.Int64("duration", res.Duration.Milliseconds()))
+		} else {
+			log.Glog.Debug("exec stream", zap.String("cmd", Redact(c.String())), logError(err), zap.Int64("duration", res.Duration.Milliseconds()))
+		}
+
+		Audit(ctx, NewAuditRecord(c, &opt.Option, res, err))
+
+		s.res, s.err = res, err
+		close(s.done)
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-s.done:
+		}
+	}()
+
+	return s, nil
+}
+
+// readLines delivers the lines of r until EOF.
+func (s *Stream) readLines(r *os.File, stderr bool) {
+	defer r.Close()
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), maxLineSize)
+	sc.Split(scanLines)
+	for sc.Scan() {
+		line := sc.Text()
+		if stderr {
+			s.stderr.Write(sc.Bytes())
+			s.stderr.Write([]byte{'\n'})
+			if s.opt.OnStderr != nil {
+				s.opt.OnStderr(line)
+			}
+		} else if s.opt.OnStdout != nil {
+			s.opt.OnStdout(line)
+		}
+		if s.opt.Lines != nil {
+			s.opt.Lines <- Line{Stderr: stderr, Text: line}
+		}
+		s.parseProgress(line)
+	}
+	// don't block the command on a line too long
+	io.Copy(io.Discard, r)
+}
+
+func (s *Stream) parseProgress(line string) {
+	if s.opt.Progress == nil || s.opt.OnProgress == nil {
+		return
+	}
+	p, ok := s.opt.Progress(line)
+	if !ok {
+		return
+	}
+	if p > 100 {
+		p = 100
+	}
+
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	if int(p) != s.progress {
+		s.progress = int(p)
+		s.opt.OnProgress(s.progress)
+	}
+}
+
+// scanLines is bufio.ScanLines also splitting on "\r".
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\r' {
+			if i+1 == len(data) && !atEOF {
+				// wait to know if it is "\r\n"
+				return 0, nil, nil
+			}
+			if i+1 < len(data) && data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Wait waits for the command to exit and its output to be delivered.
+func (s *Stream) Wait() (*Result, error) {
+	<-s.done
+	return s.res, s.err
+}
+
+// Done is closed when the command has exited and its output was delivered.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Stderr returns the tail of stderr so far.
+func (s *Stream) Stderr() []byte {
+	return s.stderr.Bytes()
+}
+
+// Stop sends SIGTERM to the command, then SIGKILL if it has not exited after
+// the grace period, and waits for it.
+func (s *Stream) Stop() (*Result, error) {
+	s.stopOnce.Do(func() {
+		s.signal(syscall.SIGTERM)
+		select {
+		case <-s.done:
+		case <-time.After(s.opt.Grace):
+			log.Glog.Warn("exec stream killed after grace", zap.String("cmd", Redact(s.c.String())), zap.Duration("grace", s.opt.Grace))
+			s.signal(syscall.SIGKILL)
+		}
+	})
+	return s.Wait()
+}
+
+func (s *Stream) signal(sig syscall.Signal) {
+	signalPipeline(s.cmds, s.opt.Setpgid, sig)
+}
+
+// ringBuffer keeps the last size bytes written to it.
+type ringBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	size int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size}
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	if len(p) >= b.size {
+		b.buf = append(b.buf[:0], p[len(p)-b.size:]...)
+		return n, nil
+	}
+	if over := len(b.buf) + len(p) - b.size; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+func (b *ringBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf...)
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStreamLines(t *testing.T) {
+	var mu sync.Mutex
+	var stdout, stderr []string
+	lines := make(chan Line, 10)
+
+	s, err := StartStream(context.Background(), New("sh", "-c", "echo a; echo b >&2; printf 'c\\r\\nd'"), &StreamOption{
+		OnStdout: func(line string) {
+			mu.Lock()
+			defer mu.Unlock()
+			stdout = append(stdout, line)
+		},
+		OnStderr: func(line string) {
+			mu.Lock()
+			defer mu.Unlock()
+			stderr = append(stderr, line)
+		},
+		Lines: lines,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Wait()
+	if err != nil || res.ExitCode != 0 {
+		t.Fatalf("unexpected %v %v", res, err)
+	}
+	close(lines)
+
+	if fmt.Sprint(stdout) != "[a c d]" || fmt.Sprint(stderr) != "[b]" {
+		t.Errorf("unexpected lines %q %q", stdout, stderr)
+	}
+	if len(lines) != 4 {
+		t.Errorf("expected 4 lines on the channel, got %d", len(lines))
+	}
+	if res.Stdout != nil || string(res.Stderr) != "b\n" {
+		t.Errorf("unexpected result %q %q", res.Stdout, res.Stderr)
+	}
+}
+
+func TestStreamStderrLimit(t *testing.T) {
+	s, err := StartStream(context.Background(), New("sh", "-c", "for i in $(seq 1000); do echo line$i >&2; done; exit 1"), &StreamOption{
+		Option:      Option{IgnoreErr: true},
+		StderrLimit: 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Wait()
+	if ExitCode(err) != 1 {
+		t.Errorf("expected exit code 1, got %v", err)
+	}
+	if len(res.Stderr) != 20 || !strings.HasSuffix(string(res.Stderr), "line999\nline1000\n") {
+		t.Errorf("expected the tail of stderr, got %q", res.Stderr)
+	}
+	if !strings.HasSuffix(err.Error(), "line1000") {
+		t.Errorf("expected the tail of stderr as error, got %q", err.Error())
+	}
+}
+
+func TestStreamStop(t *testing.T) {
+	started := make(chan struct{})
+	s, err := StartStream(context.Background(), New("sh", "-c", "trap 'echo term; exit 0' TERM; echo ready; while :; do sleep 0.01; done"), &StreamOption{
+		OnStdout: func(line string) {
+			if line == "ready" {
+				close(started)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	res, err := s.Stop()
+	if err != nil || res.ExitCode != 0 {
+		t.Errorf("expected a clean exit on SIGTERM, got %v", err)
+	}
+}
+
+func TestStreamKillAfterGrace(t *testing.T) {
+	started := make(chan struct{})
+	s, err := StartStream(context.Background(), New("sh", "-c", "trap '' TERM; echo ready; while :; do sleep 0.01; done"), &StreamOption{
+		Option:   Option{IgnoreErr: true},
+		Grace:    100 * time.Millisecond,
+		OnStdout: func(string) { close(started) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	res, _ := s.Stop()
+	if res.Signal != syscall.SIGKILL {
+		t.Errorf("expected SIGKILL, got %v", res.Signal)
+	}
+}
+
+func TestStreamTimeout(t *testing.T) {
+	s, err := StartStream(context.Background(), New("sleep", "5"), &StreamOption{
+		Option: Option{IgnoreErr: true, Timeout: 50 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Wait(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestStreamProgress(t *testing.T) {
+	var progress []int
+	s, err := StartStream(context.Background(), New("printf", `    (0.00/100%%)\r    (1.01/100%%)\r    (1.50/100%%)\r    (100.00/100%%)\r\n`), &StreamOption{
+		Progress:   QemuImgProgress,
+		OnProgress: func(p int) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(progress) != "[0 1 100]" {
+		t.Errorf("unexpected progress %v", progress)
+	}
+}
+
+func TestDDProgress(t *testing.T) {
+	p, ok := DDProgress(2000)("500 bytes copied, 1 s, 500 B/s")
+	if !ok || p != 25 {
+		t.Errorf("expected 25%%, got %v %v", p, ok)
+	}
+	if _, ok = DDProgress(2000)("1+0 records in"); ok {
+		t.Error("expected no progress")
+	}
+}
+
+func TestStreamPipeline(t *testing.T) {
+	var stdout []string
+	s, err := StartStream(context.Background(), New("printf", `a\nb\n`).Pipe(New("grep", "b")), &StreamOption{
+		OnStdout: func(line string) { stdout = append(stdout, line) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(stdout) != "[b]" {
+		t.Errorf("unexpected lines %q", stdout)
+	}
+}
@@ -1,6 +1,7 @@
 package cron
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"sync"
@@ -34,24 +35,38 @@ func (c Chain) Then(j Job) Job {
 	return j
 }
 
+// wrappedJob overrides Run of the embedded Job, keeping its ID, Next and
+// EndTime so that wrappers further down the chain still see them. The context
+// and error of the run are passed through the chain.
+type wrappedJob struct {
+	Job
+	run func(ctx context.Context) error
+}
+
+func (w wrappedJob) Run() { w.run(context.Background()) }
+
+func (w wrappedJob) RunContext(ctx context.Context) error { return w.run(ctx) }
+
 // Recover panics in wrapped jobs and log them with the provided logger.
+// The panic is reported as the error of the run.
 func Recover(logger Logger) JobWrapper {
 	return func(j Job) Job {
-		return FuncJob(func() {
+		return wrappedJob{Job: j, run: func(ctx context.Context) (err error) {
 			defer func() {
 				if r := recover(); r != nil {
 					const size = 64 << 10
 					buf := make([]byte, size)
 					buf = buf[:runtime.Stack(buf, false)]
-					err, ok := r.(error)
+					var ok bool
+					err, ok = r.(error)
 					if !ok {
 						err = fmt.Errorf("%v", r)
 					}
 					logger.Error(err, "panic", "stack", "...\n"+string(buf))
 				}
 			}()
-			j.Run()
-		})
+			return runJob(ctx, j)
+		}}
 	}
 }
 
@@ -61,15 +76,15 @@ func Recover(logger Logger) JobWrapper {
 func DelayIfStillRunning(logger Logger) JobWrapper {
 	return func(j Job) Job {
 		var mu sync.Mutex
-		return FuncJob(func() {
+		return wrappedJob{Job: j, run: func(ctx context.Context) error {
 			start := time.Now()
 			mu.Lock()
 			defer mu.Unlock()
 			if dur := time.Since(start); dur > time.Minute {
 				logger.Info("delay", "duration", dur)
 			}
-			j.Run()
-		})
+			return runJob(ctx, j)
+		}}
 	}
 }
 
@@ -79,14 +94,33 @@ func SkipIfStillRunning(logger Logger) JobWrapper {
 	return func(j Job) Job {
 		var ch = make(chan struct{}, 1)
 		ch <- struct{}{}
-		return FuncJob(func() {
+		return wrappedJob{Job: j, run: func(ctx context.Context) error {
 			select {
 			case v := <-ch:
 				defer func() { ch <- v }()
-				j.Run()
+				return runJob(ctx, j)
 			default:
 				logger.Info("skip")
+				return nil
+			}
+		}}
+	}
+}
+
+// WithTimeout cancels the context of each run after d. Only jobs observing
+// the context, i.e. ContextJobs, are actually interrupted; a run that
+// overruns d reports context.DeadlineExceeded.
+func WithTimeout(d time.Duration) JobWrapper {
+	return func(j Job) Job {
+		return wrappedJob{Job: j, run: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			err := runJob(ctx, j)
+			if err == nil && ctx.Err() == context.DeadlineExceeded {
+				err = ctx.Err()
 			}
-		})
+			return err
+		}}
 	}
 }
@@ -1,6 +1,8 @@
 package cron
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"log"
 	"reflect"
@@ -245,3 +247,46 @@ func TestChainSkipIfStillRunning(t *testing.T) {
 	})
 
 }
+
+func TestChainRecoverReturnsError(t *testing.T) {
+	job := NewChain(Recover(DiscardLogger)).Then(FuncJob(func() {
+		panic("panickingJob panics")
+	}))
+
+	err := runJob(context.Background(), job)
+	if err == nil || err.Error() != "panickingJob panics" {
+		t.Errorf("expected the panic as error, got %v", err)
+	}
+}
+
+func TestChainWithTimeout(t *testing.T) {
+	t.Run("cancels context jobs", func(t *testing.T) {
+		job := NewChain(WithTimeout(10 * time.Millisecond)).Then(contextJob{FuncContextJob(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})})
+
+		if err := runJob(context.Background(), job); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("reports overrunning plain jobs", func(t *testing.T) {
+		job := NewChain(WithTimeout(time.Millisecond)).Then(&countJob{delay: 10 * time.Millisecond})
+
+		if err := runJob(context.Background(), job); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("keeps errors", func(t *testing.T) {
+		want := errors.New("failed")
+		job := NewChain(Recover(DiscardLogger), WithTimeout(time.Second)).Then(contextJob{FuncContextJob(func(ctx context.Context) error {
+			return want
+		})})
+
+		if err := runJob(context.Background(), job); err != want {
+			t.Errorf("expected %v, got %v", want, err)
+		}
+	})
+}
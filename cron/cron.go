@@ -2,7 +2,10 @@ package cron
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -19,6 +22,7 @@ type Cron struct {
 	stop      chan struct{}
 	add       chan *Entry
 	remove    chan EntryID
+	update    chan entryUpdate
 	snapshot  chan chan []Entry
 	running   bool
 	logger    Logger
@@ -27,6 +31,15 @@ type Cron struct {
 	parser    ScheduleParser
 	nextID    EntryID
 	jobWaiter sync.WaitGroup
+	jobCtx    context.Context
+	jobCancel context.CancelFunc
+	resultMu  sync.Mutex // guards the Last* fields of entries
+
+	jitter       time.Duration
+	blackouts    []BlackoutWindow
+	blackoutMode BlackoutMode
+	slots        chan struct{} // limits concurrently running jobs, nil for no limit
+	observers    observers
 }
 
 // ScheduleParser is an interface for schedule spec parsers that return a Schedule
@@ -42,6 +55,49 @@ type Job interface {
 	EndTime() time.Time
 }
 
+// ContextJob is a job that receives a context and reports failure. The
+// context is cancelled when Cron is stopped or the run times out (see
+// WithTimeout). It is submitted with AddContextJob or ScheduleContext.
+type ContextJob interface {
+	Run(ctx context.Context) error
+	ID() string
+	Next(time.Time) time.Time
+	EndTime() time.Time
+}
+
+// contextRunner is implemented by Jobs that can run with a context, i.e. the
+// adapted ContextJobs and the jobs returned by the wrappers of this package.
+type contextRunner interface {
+	RunContext(ctx context.Context) error
+}
+
+// contextJob adapts a ContextJob to Job.
+type contextJob struct {
+	ContextJob
+}
+
+func (j contextJob) Run() { j.ContextJob.Run(context.Background()) }
+
+func (j contextJob) RunContext(ctx context.Context) error { return j.ContextJob.Run(ctx) }
+
+// activationKey is the context key of the activation of a run started by Cron.
+type activationKey struct{}
+
+// activation is the scheduled time of a run and the one following it, zero if
+// the schedule is over.
+type activation struct {
+	at, next time.Time
+}
+
+// runJob runs j with ctx if it supports it, otherwise it just calls Run.
+func runJob(ctx context.Context, j Job) error {
+	if r, ok := j.(contextRunner); ok {
+		return r.RunContext(ctx)
+	}
+	j.Run()
+	return nil
+}
+
 // Schedule describes a job's duty cycle.
 type Schedule interface {
 	// Next returns the next activation time, later than the given time.
@@ -77,6 +133,21 @@ type Entry struct {
 	// e.g. via Entries() can do so.
 	Job    Job
 	Status int16 // -1: over end time
+
+	// LastErr is the error returned by the last finished run, nil if it
+	// succeeded or the job is not a ContextJob.
+	LastErr error
+	// LastDuration is how long the last finished run took.
+	LastDuration time.Duration
+}
+
+// ContextJob returns the submitted ContextJob, or nil if the entry was added
+// as a plain Job.
+func (e Entry) ContextJob() ContextJob {
+	if j, ok := e.Job.(contextJob); ok {
+		return j.ContextJob
+	}
+	return nil
 }
 
 func (e *Entry) NextSchedule(now time.Time) {
@@ -133,6 +204,7 @@ func New(opts ...Option) *Cron {
 		stop:      make(chan struct{}),
 		snapshot:  make(chan chan []Entry),
 		remove:    make(chan EntryID),
+		update:    make(chan entryUpdate),
 		running:   false,
 		runningMu: sync.Mutex{},
 		logger:    DefaultLogger,
@@ -155,6 +227,17 @@ func (f FuncJob) Next(now time.Time) time.Time { return time.Time{} }
 
 func (f FuncJob) EndTime() time.Time { return time.Date(9999, 1, 1, 0, 0, 0, 0, time.Local) }
 
+// FuncContextJob is a wrapper that turns a func(context.Context) error into a
+// cron.ContextJob
+type FuncContextJob func(ctx context.Context) error
+
+func (f FuncContextJob) Run(ctx context.Context) error { return f(ctx) }
+
+func (f FuncContextJob) ID() string                   { return "" }
+func (f FuncContextJob) Next(now time.Time) time.Time { return time.Time{} }
+
+func (f FuncContextJob) EndTime() time.Time { return time.Date(9999, 1, 1, 0, 0, 0, 0, time.Local) }
+
 // AddFunc adds a func to the Cron to be run on the given schedule.
 // The spec is parsed using the time zone of this Cron instance as the default.
 // An opaque ID is returned that can be used to later remove it.
@@ -173,16 +256,43 @@ func (c *Cron) AddJob(spec string, cmd Job) (EntryID, error) {
 	return c.Schedule(schedule, cmd), nil
 }
 
+// AddContextFunc adds a func taking a context to the Cron to be run on the
+// given schedule.
+func (c *Cron) AddContextFunc(spec string, cmd func(ctx context.Context) error) (EntryID, error) {
+	return c.AddContextJob(spec, FuncContextJob(cmd))
+}
+
+// AddContextJob adds a ContextJob to the Cron to be run on the given schedule.
+func (c *Cron) AddContextJob(spec string, cmd ContextJob) (EntryID, error) {
+	return c.AddJob(spec, contextJob{cmd})
+}
+
+// ScheduleContext adds a ContextJob to the Cron to be run on the given
+// schedule.
+func (c *Cron) ScheduleContext(schedule Schedule, cmd ContextJob) EntryID {
+	return c.Schedule(schedule, contextJob{cmd})
+}
+
 // Schedule adds a Job to the Cron to be run on the given schedule.
-// The job is wrapped with the configured Chain.
+// The job is wrapped with the configured Chain, and the schedule with the
+// configured jitter and blackout windows.
 func (c *Cron) Schedule(schedule Schedule, cmd Job) EntryID {
 	c.runningMu.Lock()
 	defer c.runningMu.Unlock()
+	return c.schedule(schedule, cmd)
+}
+
+// schedule adds a Job, c.runningMu must be held.
+func (c *Cron) schedule(schedule Schedule, cmd Job) EntryID {
 	c.nextID++
+	seed := cmd.ID()
+	if seed == "" {
+		seed = strconv.Itoa(int(c.nextID))
+	}
 	entry := &Entry{
 		ID:         c.nextID,
 		UniqueID:   cmd.ID(),
-		Schedule:   schedule,
+		Schedule:   c.decorate(schedule, seed),
 		WrappedJob: c.chain.Then(cmd),
 		Job:        cmd,
 	}
@@ -194,6 +304,132 @@ func (c *Cron) Schedule(schedule Schedule, cmd Job) EntryID {
 	return entry.ID
 }
 
+// decorate applies the configured jitter and blackout windows to schedule.
+func (c *Cron) decorate(schedule Schedule, seed string) Schedule {
+	if c.jitter > 0 {
+		schedule = Jitter(schedule, c.jitter, seed)
+	}
+	if len(c.blackouts) > 0 {
+		schedule = Blackout(schedule, c.blackoutMode, c.blackouts...)
+	}
+	return schedule
+}
+
+// ErrEntryNotFound is returned by UpdateBySID when no entry has the sid.
+var ErrEntryNotFound = errors.New("cron: entry not found")
+
+// entryUpdate replaces the schedule, and the job if not nil, of the entry
+// whose job has the unique id sid.
+type entryUpdate struct {
+	sid      string
+	schedule Schedule
+	job      Job
+	reply    chan EntryID
+}
+
+// UpdateBySID changes the schedule of the entry with the given sid. The entry
+// keeps its ID, Prev and last result, and its next activation is computed
+// from the new spec at once, so no activation is run twice or lost to a
+// remove-then-add.
+func (c *Cron) UpdateBySID(sid, spec string) error {
+	schedule, err := c.parser.Parse(spec)
+	if err != nil {
+		return err
+	}
+
+	c.runningMu.Lock()
+	defer c.runningMu.Unlock()
+	if c.updateEntry(entryUpdate{sid: sid, schedule: schedule}) == 0 {
+		return ErrEntryNotFound
+	}
+	return nil
+}
+
+// Upsert replaces the job and schedule of the entry with the same ID() as
+// cmd, keeping its EntryID and history like UpdateBySID, or adds cmd if there
+// is none. cmd must have an ID.
+func (c *Cron) Upsert(spec string, cmd Job) (EntryID, error) {
+	if cmd.ID() == "" {
+		return 0, errors.New("cron: job has no ID")
+	}
+	schedule, err := c.parser.Parse(spec)
+	if err != nil {
+		return 0, err
+	}
+
+	c.runningMu.Lock()
+	defer c.runningMu.Unlock()
+	if id := c.updateEntry(entryUpdate{sid: cmd.ID(), schedule: schedule, job: cmd}); id != 0 {
+		return id, nil
+	}
+	// nothing else can add the sid while runningMu is held
+	return c.schedule(schedule, cmd), nil
+}
+
+// updateEntry hands u to the run loop, or applies it if Cron is not running.
+// c.runningMu must be held.
+func (c *Cron) updateEntry(u entryUpdate) EntryID {
+	if !c.running {
+		return c.applyUpdate(u, time.Time{})
+	}
+	u.reply = make(chan EntryID, 1)
+	c.update <- u
+	return <-u.reply
+}
+
+// applyUpdate updates the entry and computes its next activation from now,
+// unless now is zero. It returns the ID of the entry, 0 if not found.
+func (c *Cron) applyUpdate(u entryUpdate, now time.Time) EntryID {
+	for _, e := range c.entries {
+		if e.Job.ID() != u.sid {
+			continue
+		}
+
+		e.Schedule = c.decorate(u.schedule, u.sid)
+		if u.job != nil {
+			e.Job = u.job
+			e.WrappedJob = c.chain.Then(u.job)
+		}
+		if !now.IsZero() {
+			e.NextSchedule(now)
+			c.observers.scheduled(c.entryCopy(e))
+		}
+		return e.ID
+	}
+	return 0
+}
+
+// ValidateSpec checks that spec is accepted by the parser of this Cron.
+func (c *Cron) ValidateSpec(spec string) error {
+	_, err := c.parser.Parse(spec)
+	return err
+}
+
+// NextN returns the next n activations of spec from now, in the location of
+// this Cron, e.g. to preview a schedule. Blackout windows are applied, the
+// per-entry jitter is not. Fewer times are returned if the schedule ends.
+func (c *Cron) NextN(spec string, n int) ([]time.Time, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("expected a positive count, found %d", n)
+	}
+	schedule, err := c.parser.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.blackouts) > 0 {
+		schedule = Blackout(schedule, c.blackoutMode, c.blackouts...)
+	}
+
+	times := make([]time.Time, 0, n)
+	for t := c.now(); len(times) < n; {
+		if t = schedule.Next(t); t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
 // Entries returns a snapshot of the cron entries.
 func (c *Cron) Entries() []Entry {
 	c.runningMu.Lock()
@@ -236,7 +472,7 @@ func (c *Cron) Remove(id EntryID) {
 func (c *Cron) GetEntryBySID(sid string) Entry {
 	for _, v := range c.entries {
 		if v.Job.ID() == sid {
-			return *v
+			return c.entryCopy(v)
 		}
 	}
 
@@ -247,7 +483,7 @@ func (c *Cron) GetEntryBySID(sid string) Entry {
 func (c *Cron) GetEntry(id EntryID) Entry {
 	for _, v := range c.entries {
 		if v.ID == id {
-			return *v
+			return c.entryCopy(v)
 		}
 	}
 
@@ -279,6 +515,7 @@ func (c *Cron) Start() {
 		return
 	}
 	c.running = true
+	c.jobCtx, c.jobCancel = context.WithCancel(context.Background())
 	go c.run()
 }
 
@@ -290,6 +527,7 @@ func (c *Cron) Run() {
 		return
 	}
 	c.running = true
+	c.jobCtx, c.jobCancel = context.WithCancel(context.Background())
 	c.runningMu.Unlock()
 	c.run()
 }
@@ -303,6 +541,7 @@ func (c *Cron) run() {
 	now := c.now()
 	for _, entry := range c.entries {
 		entry.NextSchedule(now)
+		c.observers.scheduled(c.entryCopy(entry))
 		log.Glog.Debug("cron schedule first", zap.Time("now", now), zap.Time("next", entry.Next), zap.String("unique_id", entry.UniqueID), zap.Int("len", len(c.entries)))
 	}
 
@@ -318,7 +557,11 @@ func (c *Cron) run() {
 			// 	zap.Bool("c1", !entry.Job.EndTime().IsZero() && entry.Next.After(entry.Job.EndTime())),
 			// 	zap.Bool("c2", entry.Next.Before(now)),
 			// 	zap.String("unique_id", entry.UniqueID))
-			if (!entry.Job.EndTime().IsZero() && entry.Next.After(entry.Job.EndTime())) || entry.Next.Before(now) { //  entry.Next > entry.Job.EndTime() || entry.Next > now
+			overEnd := !entry.Job.EndTime().IsZero() && entry.Next.After(entry.Job.EndTime())
+			if overEnd || entry.Next.Before(now) { //  entry.Next > entry.Job.EndTime() || entry.Next > now
+				if entry.Status >= 0 && !overEnd && !entry.Next.IsZero() {
+					c.observers.missed(c.entryCopy(entry))
+				}
 				entry.Status = -1
 
 				go func(id EntryID) {
@@ -358,9 +601,10 @@ func (c *Cron) run() {
 						continue
 					}
 
-					c.startJob(e.WrappedJob)
+					c.startJob(e)
 					e.Prev = e.Next
 					e.NextSchedule(now)
+					c.observers.scheduled(c.entryCopy(e))
 					log.Glog.Info("cron do", zap.Int("id", int(e.ID)), zap.Time("now", now), zap.Time("next", e.Next), zap.String("unique_id", e.UniqueID))
 				}
 
@@ -369,8 +613,16 @@ func (c *Cron) run() {
 				now = c.now()
 				newEntry.NextSchedule(now)
 				c.entries = append(c.entries, newEntry)
+				c.observers.scheduled(c.entryCopy(newEntry))
 				log.Glog.Info("cron added", zap.Int("id", int(newEntry.ID)), zap.Time("now", now), zap.Time("next", newEntry.Next), zap.String("unique_id", newEntry.UniqueID))
 
+			case u := <-c.update:
+				timer.Stop() // the updated entry may be scheduled first
+				now = c.now()
+				id := c.applyUpdate(u, now)
+				u.reply <- id
+				log.Glog.Info("cron updated", zap.Int("id", int(id)), zap.String("unique_id", u.sid))
+
 			case replyChan := <-c.snapshot:
 				replyChan <- c.entrySnapshot()
 				continue
@@ -395,12 +647,53 @@ func (c *Cron) run() {
 	}
 }
 
-// startJob runs the given job in a new goroutine.
-func (c *Cron) startJob(j Job) {
+// startJob runs the given entry's job in a new goroutine and records its
+// result on the entry.
+func (c *Cron) startJob(e *Entry) {
+	j, ctx, snap := e.WrappedJob, c.jobCtx, c.entryCopy(e)
 	c.jobWaiter.Add(1)
 	go func() {
 		defer c.jobWaiter.Done()
-		j.Run()
+
+		if c.slots != nil {
+			select {
+			case c.slots <- struct{}{}:
+			default:
+				log.Glog.Debug("cron job waits for a slot", zap.Int("id", int(e.ID)), zap.String("unique_id", e.UniqueID))
+				select {
+				case c.slots <- struct{}{}:
+				case <-ctx.Done():
+					c.resultMu.Lock()
+					e.LastErr = ctx.Err()
+					e.LastDuration = 0
+					c.resultMu.Unlock()
+					c.observers.missed(snap)
+					return
+				}
+			}
+			defer func() { <-c.slots }()
+		}
+
+		following := snap
+		following.NextSchedule(snap.Next)
+		ctx = context.WithValue(ctx, activationKey{}, activation{at: snap.Next, next: following.Next})
+
+		ctxs := c.observers.start(ctx, snap)
+		if len(ctxs) > 0 {
+			ctx = ctxs[len(ctxs)-1]
+		}
+		start := time.Now()
+		err := runJob(ctx, j)
+		duration := time.Since(start)
+		c.observers.finish(ctxs, snap, err, duration)
+		if err != nil {
+			log.Glog.Error("cron job failed", zap.Int("id", int(e.ID)), zap.String("unique_id", e.UniqueID), zap.Duration("duration", duration), zap.Error(err))
+		}
+
+		c.resultMu.Lock()
+		e.LastErr = err
+		e.LastDuration = duration
+		c.resultMu.Unlock()
 	}()
 }
 
@@ -410,6 +703,7 @@ func (c *Cron) now() time.Time {
 }
 
 // Stop stops the cron scheduler if it is running; otherwise it does nothing.
+// The contexts of running ContextJobs are cancelled; plain Jobs keep running.
 // A context is returned so the caller can wait for running jobs to complete.
 func (c *Cron) Stop() context.Context {
 	c.runningMu.Lock()
@@ -417,6 +711,7 @@ func (c *Cron) Stop() context.Context {
 	if c.running {
 		c.stop <- struct{}{}
 		c.running = false
+		c.jobCancel()
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -430,16 +725,26 @@ func (c *Cron) Stop() context.Context {
 func (c *Cron) entrySnapshot() []Entry {
 	var entries = make([]Entry, len(c.entries))
 	for i, e := range c.entries {
-		entries[i] = *e
+		entries[i] = c.entryCopy(e)
 	}
 	return entries
 }
 
+// entryCopy returns a copy of e, synchronized with jobs recording their
+// results.
+func (c *Cron) entryCopy(e *Entry) Entry {
+	c.resultMu.Lock()
+	defer c.resultMu.Unlock()
+	return *e
+}
+
 func (c *Cron) removeEntry(id EntryID) {
 	var entries []*Entry
 	for _, e := range c.entries {
 		if e.ID != id {
 			entries = append(entries, e)
+		} else {
+			c.observers.removed(c.entryCopy(e))
 		}
 	}
 	c.entries = entries
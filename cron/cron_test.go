@@ -2,6 +2,8 @@ package cron
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -687,6 +689,195 @@ func TestStopAndWait(t *testing.T) {
 	})
 }
 
+func TestContextJobRecordsResult(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	cron := newWithSeconds()
+	id, _ := cron.AddContextFunc("* * * * * ?", func(ctx context.Context) error {
+		defer wg.Done()
+		time.Sleep(10 * time.Millisecond)
+		return errors.New("backup failed")
+	})
+	cron.Start()
+
+	select {
+	case <-time.After(OneSecond):
+		t.Fatal("expected job runs")
+	case <-wait(wg):
+	}
+	// the result is recorded after the job returns, Stop waits for it
+	<-cron.Stop().Done()
+
+	entry := cron.Entry(id)
+	if entry.LastErr == nil || entry.LastErr.Error() != "backup failed" {
+		t.Errorf("expected the job error to be recorded, got %v", entry.LastErr)
+	}
+	if entry.LastDuration < 10*time.Millisecond {
+		t.Errorf("expected the duration to be recorded, got %v", entry.LastDuration)
+	}
+	if entry.ContextJob() == nil {
+		t.Error("expected the submitted ContextJob")
+	}
+}
+
+func TestStopCancelsContextJobs(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	cron := newWithSeconds()
+	cron.AddContextFunc("* * * * * ?", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	cron.Start()
+
+	select {
+	case <-time.After(OneSecond):
+		t.Fatal("expected job runs")
+	case <-started:
+	}
+
+	ctx := cron.Stop()
+	select {
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("expected the job context to be cancelled on Stop")
+	case <-cancelled:
+	}
+	select {
+	case <-time.After(100 * time.Millisecond):
+		t.Error("expected Stop to wait for the cancelled job")
+	case <-ctx.Done():
+	}
+}
+
+func TestContextJobTimeout(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	cron := New(WithParser(secondParser), WithChain(WithTimeout(20*time.Millisecond)))
+	id, _ := cron.AddContextFunc("* * * * * ?", func(ctx context.Context) error {
+		defer wg.Done()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	cron.Start()
+
+	select {
+	case <-time.After(OneSecond):
+		t.Fatal("expected job runs")
+	case <-wait(wg):
+	}
+	<-cron.Stop().Done()
+
+	if err := cron.Entry(id).LastErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestUpdateBySID(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	cron := newWithSeconds()
+	id, _ := cron.AddJob("* * * * * ?", newIdJob("backup", wg.Done))
+	cron.Start()
+	defer cron.Stop()
+
+	select {
+	case <-time.After(OneSecond):
+		t.Fatal("expected job runs")
+	case <-wait(wg):
+	}
+	prev := cron.Entry(id).Prev
+
+	if err := cron.UpdateBySID("backup", "0 0 0 1 1 ?"); err != nil {
+		t.Fatal(err)
+	}
+	entries := cron.Entries()
+	if len(entries) != 1 || entries[0].ID != id {
+		t.Fatalf("expected the entry to be updated in place, got %v", entries)
+	}
+	if entries[0].Prev.IsZero() || !entries[0].Prev.Equal(prev) {
+		t.Errorf("expected Prev %v to be kept, got %v", prev, entries[0].Prev)
+	}
+	if next := entries[0].Next; next.Month() != time.January || next.Day() != 1 || next.Hour() != 0 {
+		t.Errorf("expected the next run on January 1st, got %v", next)
+	}
+
+	if err := cron.UpdateBySID("restore", "0 0 0 1 1 ?"); err != ErrEntryNotFound {
+		t.Errorf("expected ErrEntryNotFound, got %v", err)
+	}
+	if err := cron.UpdateBySID("backup", "bad spec"); err == nil {
+		t.Error("expected an error for an invalid spec")
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	cron := newWithSeconds()
+	id, err := cron.Upsert("0 0 0 1 1 ?", newIdJob("backup", func() { t.Error("expected the job to be replaced") }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := cron.Upsert("* * * * * ?", newIdJob("backup", wg.Done))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id2 != id || len(cron.Entries()) != 1 {
+		t.Fatalf("expected the entry %d to be replaced, got %d and %d entries", id, id2, len(cron.Entries()))
+	}
+
+	cron.Start()
+	defer cron.Stop()
+	select {
+	case <-time.After(OneSecond):
+		t.Fatal("expected the new job runs")
+	case <-wait(wg):
+	}
+
+	if _, err = cron.Upsert("* * * * * ?", FuncJob(func() {})); err == nil {
+		t.Error("expected an error for a job without ID")
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	cron := New()
+	if err := cron.ValidateSpec("0 0 * * *"); err != nil {
+		t.Error(err)
+	}
+	if err := cron.ValidateSpec("0 0 * *"); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+}
+
+func TestNextN(t *testing.T) {
+	cron := New(WithLocation(time.UTC))
+	times, err := cron.NextN("0 0 * * *", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 3 {
+		t.Fatalf("expected 3 times, got %v", times)
+	}
+	if d := time.Until(times[0]); d <= 0 || d > 24*time.Hour {
+		t.Errorf("expected the first run within a day, got %v", times[0])
+	}
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d != 24*time.Hour {
+			t.Errorf("expected daily runs, got %v after %v", times[i], times[i-1])
+		}
+	}
+
+	if _, err = cron.NextN("0 0 * * *", 0); err == nil {
+		t.Error("expected an error for a zero count")
+	}
+}
+
 func TestMultiThreadedStartAndStop(t *testing.T) {
 	cron := New()
 	go cron.Run()
@@ -105,6 +105,22 @@ Question mark ( ? )
 Question mark may be used instead of '*' for leaving either day-of-month or
 day-of-week blank.
 
+L, W and hash ( # )
+
+Parsers created with the Extended option accept Quartz style day expressions.
+In the day-of-month field "L" is the last day of the month, "L-3" the third to
+last, "15W" the weekday (Monday to Friday) nearest to the 15th without leaving
+the month, and "LW" the last weekday of the month. In the day-of-week field
+"FRIL" (or "5L") is the last Friday of the month and "FRI#2" the second Friday.
+They may be mixed with other items of a list, e.g. "1,L".
+
+Year
+
+Parsers created with the Year or YearOptional option accept a year field
+(1970-2099) after the day-of-week field, e.g. "0 0 22 ? * FRIL 2025-2030".
+The specs using a year or L, W and # are parsed to an ExtendedSchedule, the
+others still to a SpecSchedule.
+
 Predefined schedules
 
 You may use one of several pre-defined schedules in place of a cron expression.
@@ -178,6 +194,7 @@ to achieve the following effects:
   - Recover any panics from jobs (activated by default)
   - Delay a job's execution if the previous run hasn't completed yet
   - Skip a job's execution if the previous run hasn't completed yet
+  - Run a job on only one of several replicas (DistributedLock)
   - Log each job's invocations
 
 Install wrappers for all jobs added to a cron using the `cron.WithChain` option:
@@ -192,6 +209,17 @@ Install wrappers for individual jobs by explicitly wrapping them:
 		cron.SkipIfStillRunning(logger),
 	).Then(job)
 
+Context jobs
+
+Jobs that need cancellation or want to report failure implement ContextJob and
+are added with AddContextJob, or AddContextFunc for a plain func:
+
+	c.AddContextFunc("@hourly", func(ctx context.Context) error { return backup(ctx) })
+
+Their context is cancelled by Stop and by the WithTimeout wrapper. The error
+and duration of the last run are recorded on the Entry as LastErr and
+LastDuration.
+
 Thread safety
 
 Since the Cron service runs concurrently with the calling code, some amount of
@@ -216,6 +244,17 @@ Activate it with a one-off logger as follows:
 		cron.WithLogger(
 			cron.VerbosePrintfLogger(log.New(os.Stdout, "cron: ", log.LstdFlags))))
 
+ZapLogger adapts a *zap.Logger, or log.Glog when given nil.
+
+Observers
+
+WithObserver registers Observers notified when entries are scheduled, start,
+finish, miss an activation or are removed. OtelObserver records a span per run
+and run count, duration and lateness metrics:
+
+	obs, err := cron.NewOtelObserver(nil, nil) // global providers
+	c := cron.New(cron.WithObserver(obs))
+
 
 Implementation
 
@@ -0,0 +1,284 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/meilihao/golib/v2/rand"
+)
+
+var (
+	// ErrLockHeld is returned by Locker.TryLock when another owner holds the lock.
+	ErrLockHeld = errors.New("cron: lock is held by another owner")
+	// ErrLockLost is returned by Locker.Refresh when the lease has expired or
+	// has been taken over by another owner.
+	ErrLockLost = errors.New("cron: lock lost")
+)
+
+// Lease is a granted named lock.
+type Lease struct {
+	Key   string
+	Owner string
+	// Token is a fencing token, strictly increasing for every grant of Key.
+	// Resources touched by the job can reject writes carrying an older token.
+	Token    int64
+	ExpireAt time.Time
+}
+
+// Locker is a pluggable provider of named locks with a TTL, shared by all
+// the replicas running the same cron specs.
+type Locker interface {
+	// TryLock acquires key for owner until ttl elapses. It does not wait,
+	// returning ErrLockHeld if the lock is held by someone else.
+	TryLock(ctx context.Context, key, owner string, ttl time.Duration) (*Lease, error)
+	// Refresh extends the lease to expire ttl from now.
+	Refresh(ctx context.Context, lease *Lease, ttl time.Duration) error
+	// Unlock releases the lease. Releasing a lost lease is not an error.
+	Unlock(ctx context.Context, lease *Lease) error
+}
+
+// LeaseJob is implemented by jobs that want the fencing token of the lease
+// acquired by DistributedLock. RunWithLease is called instead of Run.
+type LeaseJob interface {
+	Job
+	RunWithLease(lease *Lease)
+}
+
+type leaseKey struct{}
+
+// LeaseFromContext returns the lease acquired by DistributedLock for the
+// current run of a ContextJob, or nil.
+func LeaseFromContext(ctx context.Context) *Lease {
+	lease, _ := ctx.Value(leaseKey{}).(*Lease)
+	return lease
+}
+
+// LockOptions configures DistributedLock.
+type LockOptions struct {
+	// TTL bounds how long a crashed holder keeps the lock. The lease is
+	// refreshed every TTL/3 while the job is running. Default: 1 minute.
+	TTL time.Duration
+	// MinHold keeps the lock for at least this long after the job started, so
+	// replicas whose clocks lag behind skip the same activation instead of
+	// running it once the first replica is done. It must stay below the
+	// interval between activations, or the replica holding the lock skips the
+	// next one too. Default: 0, which holds the lock until halfway to the
+	// following activation for the runs started by Cron, and releases it as
+	// soon as the job is done otherwise. Negative always releases it as soon
+	// as the job is done.
+	MinHold time.Duration
+	// Owner identifies this replica. Default: hostname plus a random suffix.
+	Owner string
+	// KeyPrefix is prepended to Job.ID() to build the lock name. Default: "cron:".
+	KeyPrefix string
+	// Timeout bounds each call to the Locker. Default: 5 seconds.
+	Timeout time.Duration
+}
+
+func (o *LockOptions) setDefaults() {
+	if o.TTL <= 0 {
+		o.TTL = time.Minute
+	}
+	if o.Owner == "" {
+		host, _ := os.Hostname()
+		o.Owner = fmt.Sprintf("%s-%s", host, rand.Rand(4))
+	}
+	if o.KeyPrefix == "" {
+		o.KeyPrefix = "cron:"
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+}
+
+// DistributedLock runs the job only if the lock named after Job.ID() could be
+// acquired from locker, so the same spec deployed on several replicas runs once
+// per activation. Invocations that lose the race are logged at Info and
+// skipped. Jobs without an ID can't be locked and are never run.
+//
+// If the lease is lost while the job runs, e.g. the Locker was unreachable
+// for longer than the TTL, the context of the run is cancelled. Plain Jobs and
+// LeaseJobs have no context and keep running; they should check the fencing
+// token instead.
+//
+// The wrappers of this package keep the ID of the job they wrap, but custom
+// ones returning a FuncJob don't, so DistributedLock should come after them:
+//
+//	NewChain(myWrapper, DistributedLock(locker, logger, LockOptions{}))
+func DistributedLock(locker Locker, logger Logger, opts LockOptions) JobWrapper {
+	opts.setDefaults()
+
+	return func(j Job) Job {
+		return wrappedJob{Job: j, run: func(ctx context.Context) error {
+			if j.ID() == "" {
+				err := errors.New("job has no ID")
+				logger.Error(err, "lock")
+				return err
+			}
+			key := opts.KeyPrefix + j.ID()
+
+			lockCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+			lease, err := locker.TryLock(lockCtx, key, opts.Owner, opts.TTL)
+			cancel()
+			if err != nil {
+				if errors.Is(err, ErrLockHeld) {
+					logger.Info("lock held, skip", "key", key)
+					return nil
+				}
+				logger.Error(err, "lock", "key", key)
+				return err
+			}
+
+			// refreshes update ExpireAt of held, lease is handed to the job
+			held := *lease
+			until := holdUntil(ctx, opts.MinHold, time.Now())
+			ctx, lost := context.WithCancel(ctx)
+			defer lost()
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				keepLease(locker, &held, logger, opts, stop, lost)
+			}()
+
+			defer func() {
+				close(stop)
+				wg.Wait()
+				releaseLease(locker, &held, logger, opts, until)
+			}()
+
+			if lj, ok := j.(LeaseJob); ok {
+				lj.RunWithLease(lease)
+				return nil
+			}
+			return runJob(context.WithValue(ctx, leaseKey{}, lease), j)
+		}}
+	}
+}
+
+// keepLease refreshes the lease every TTL/3 until stop is closed. It calls
+// lost and gives up if the lease is lost.
+func keepLease(locker Locker, lease *Lease, logger Logger, opts LockOptions, stop chan struct{}, lost context.CancelFunc) {
+	ticker := time.NewTicker(opts.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+			err := locker.Refresh(ctx, lease, opts.TTL)
+			cancel()
+			if err != nil {
+				logger.Error(err, "lock refresh", "key", lease.Key, "token", lease.Token)
+				if errors.Is(err, ErrLockLost) {
+					lost()
+					return
+				}
+			}
+		}
+	}
+}
+
+// holdUntil returns until when the lock of a run started at start is kept,
+// see LockOptions.MinHold.
+func holdUntil(ctx context.Context, minHold time.Duration, start time.Time) time.Time {
+	if minHold > 0 {
+		return start.Add(minHold)
+	}
+	if minHold == 0 {
+		if a, ok := ctx.Value(activationKey{}).(activation); ok && a.next.After(a.at) {
+			return a.at.Add(a.next.Sub(a.at) / 2)
+		}
+	}
+	return time.Time{}
+}
+
+// releaseLease unlocks the lease, or shortens it to expire at until.
+func releaseLease(locker Locker, lease *Lease, logger Logger, opts LockOptions, until time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	var err error
+	if rest := time.Until(until); rest > 0 {
+		err = locker.Refresh(ctx, lease, rest)
+	} else {
+		err = locker.Unlock(ctx, lease)
+	}
+	if err != nil {
+		logger.Error(err, "lock release", "key", lease.Key, "token", lease.Token)
+	}
+}
+
+// MemoryLocker is a process-local Locker, useful for tests and single-node
+// deployments.
+type MemoryLocker struct {
+	mu     sync.Mutex
+	leases map[string]Lease
+	tokens map[string]int64
+	now    func() time.Time
+}
+
+// NewMemoryLocker returns an empty MemoryLocker.
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		leases: make(map[string]Lease),
+		tokens: make(map[string]int64),
+		now:    time.Now,
+	}
+}
+
+func (l *MemoryLocker) TryLock(ctx context.Context, key, owner string, ttl time.Duration) (*Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if cur, ok := l.leases[key]; ok && cur.ExpireAt.After(now) {
+		return nil, ErrLockHeld
+	}
+
+	l.tokens[key]++
+	lease := Lease{
+		Key:      key,
+		Owner:    owner,
+		Token:    l.tokens[key],
+		ExpireAt: now.Add(ttl),
+	}
+	l.leases[key] = lease
+
+	return &lease, nil
+}
+
+func (l *MemoryLocker) Refresh(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	cur, ok := l.leases[lease.Key]
+	if !ok || cur.Token != lease.Token || !cur.ExpireAt.After(now) {
+		return ErrLockLost
+	}
+
+	cur.ExpireAt = now.Add(ttl)
+	l.leases[lease.Key] = cur
+	lease.ExpireAt = cur.ExpireAt
+
+	return nil
+}
+
+func (l *MemoryLocker) Unlock(ctx context.Context, lease *Lease) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cur, ok := l.leases[lease.Key]; ok && cur.Token == lease.Token {
+		delete(l.leases, lease.Key)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package cron
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The lock value is "owner:token" and the fencing counter lives in
+// "<key>:fence", so tokens keep increasing after the lock key expires.
+var (
+	redisLockScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local token = redis.call("INCR", KEYS[2])
+redis.call("SET", KEYS[1], ARGV[1] .. ":" .. token, "PX", ARGV[2])
+return token
+`)
+
+	redisRefreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+	redisUnlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+)
+
+// RedisLocker is a Locker backed by Redis, e.g. the client returned by
+// db.InitRedis.
+type RedisLocker struct {
+	client redis.UniversalClient
+}
+
+// NewRedisLocker returns a Locker using client.
+func NewRedisLocker(client redis.UniversalClient) *RedisLocker {
+	return &RedisLocker{client: client}
+}
+
+func (l *RedisLocker) TryLock(ctx context.Context, key, owner string, ttl time.Duration) (*Lease, error) {
+	now := time.Now()
+	token, err := redisLockScript.Run(ctx, l.client,
+		[]string{key, key + ":fence"},
+		owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return nil, err
+	}
+	if token == 0 {
+		return nil, ErrLockHeld
+	}
+
+	return &Lease{
+		Key:      key,
+		Owner:    owner,
+		Token:    token,
+		ExpireAt: now.Add(ttl),
+	}, nil
+}
+
+func (l *RedisLocker) Refresh(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	now := time.Now()
+	n, err := redisRefreshScript.Run(ctx, l.client,
+		[]string{lease.Key},
+		redisLockValue(lease), ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+
+	lease.ExpireAt = now.Add(ttl)
+
+	return nil
+}
+
+func (l *RedisLocker) Unlock(ctx context.Context, lease *Lease) error {
+	return redisUnlockScript.Run(ctx, l.client,
+		[]string{lease.Key},
+		redisLockValue(lease)).Err()
+}
+
+func redisLockValue(lease *Lease) string {
+	return lease.Owner + ":" + strconv.FormatInt(lease.Token, 10)
+}
@@ -0,0 +1,180 @@
+package cron
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLLocker is a Locker backed by a SQL table, for deployments that already
+// share a MySQL or PostgreSQL database but no Redis.
+//
+// Expiry is stored as unix milliseconds taken from the replica's clock, so
+// replicas are expected to be NTP synced. Rows are never deleted, which keeps
+// the fencing token increasing across unlocks.
+type SQLLocker struct {
+	db      *sql.DB
+	dialect string
+	table   string
+	now     func() time.Time
+}
+
+// NewSQLLocker returns a Locker storing locks in table. dialect is "mysql" or
+// "postgres" and only selects the placeholder style.
+func NewSQLLocker(db *sql.DB, dialect, table string) *SQLLocker {
+	if table == "" {
+		table = "cron_lock"
+	}
+
+	return &SQLLocker{
+		db:      db,
+		dialect: dialect,
+		table:   table,
+		now:     time.Now,
+	}
+}
+
+// CreateTable creates the lock table if it does not exist.
+func (l *SQLLocker) CreateTable(ctx context.Context) error {
+	_, err := l.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	name VARCHAR(255) NOT NULL PRIMARY KEY,
+	owner VARCHAR(255) NOT NULL,
+	token BIGINT NOT NULL,
+	expire_at BIGINT NOT NULL
+)`, l.table))
+
+	return err
+}
+
+func (l *SQLLocker) TryLock(ctx context.Context, key, owner string, ttl time.Duration) (*Lease, error) {
+	now := l.now()
+	expireAt := now.Add(ttl)
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		l.rebind(fmt.Sprintf("UPDATE %s SET owner = ?, token = token + 1, expire_at = ? WHERE name = ? AND expire_at <= ?", l.table)),
+		owner, expireAt.UnixMilli(), key, now.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	var token int64
+	if n == 1 {
+		if err = tx.QueryRowContext(ctx,
+			l.rebind(fmt.Sprintf("SELECT token FROM %s WHERE name = ?", l.table)),
+			key).Scan(&token); err != nil {
+			return nil, err
+		}
+	} else {
+		held, err := l.exists(ctx, tx, key)
+		if err != nil {
+			return nil, err
+		}
+		if held {
+			return nil, ErrLockHeld
+		}
+
+		token = 1
+		if _, err = tx.ExecContext(ctx,
+			l.rebind(fmt.Sprintf("INSERT INTO %s (name, owner, token, expire_at) VALUES (?, ?, ?, ?)", l.table)),
+			key, owner, token, expireAt.UnixMilli()); err != nil {
+			// lost the race against another replica inserting the same key
+			tx.Rollback()
+			if held, _ = l.exists(ctx, l.db, key); held {
+				return nil, ErrLockHeld
+			}
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &Lease{
+		Key:      key,
+		Owner:    owner,
+		Token:    token,
+		ExpireAt: expireAt,
+	}, nil
+}
+
+func (l *SQLLocker) Refresh(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	now := l.now()
+	expireAt := now.Add(ttl)
+
+	res, err := l.db.ExecContext(ctx,
+		l.rebind(fmt.Sprintf("UPDATE %s SET expire_at = ? WHERE name = ? AND owner = ? AND token = ? AND expire_at > ?", l.table)),
+		expireAt.UnixMilli(), lease.Key, lease.Owner, lease.Token, now.UnixMilli())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+
+	lease.ExpireAt = expireAt
+
+	return nil
+}
+
+func (l *SQLLocker) Unlock(ctx context.Context, lease *Lease) error {
+	_, err := l.db.ExecContext(ctx,
+		l.rebind(fmt.Sprintf("UPDATE %s SET expire_at = 0 WHERE name = ? AND owner = ? AND token = ?", l.table)),
+		lease.Key, lease.Owner, lease.Token)
+
+	return err
+}
+
+type sqlQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (l *SQLLocker) exists(ctx context.Context, q sqlQueryer, key string) (bool, error) {
+	var token int64
+	err := q.QueryRowContext(ctx,
+		l.rebind(fmt.Sprintf("SELECT token FROM %s WHERE name = ?", l.table)),
+		key).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// rebind converts "?" placeholders to "$n" for postgres.
+func (l *SQLLocker) rebind(query string) string {
+	if l.dialect != "postgres" {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,318 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+type idJob struct {
+	id  string
+	run func()
+}
+
+func newIdJob(id string, run func()) idJob { return idJob{id: id, run: run} }
+
+func (j idJob) Run()                         { j.run() }
+func (j idJob) ID() string                   { return j.id }
+func (j idJob) Next(now time.Time) time.Time { return time.Time{} }
+
+func (j idJob) EndTime() time.Time { return time.Date(9999, 1, 1, 0, 0, 0, 0, time.Local) }
+
+type leaseRecorder struct {
+	idJob
+	f func(*Lease)
+}
+
+func leaseJob(id string, f func(*Lease)) Job { return leaseRecorder{idJob{id: id}, f} }
+
+func (j leaseRecorder) RunWithLease(lease *Lease) { j.f(lease) }
+
+func TestDistributedLockRunsOnce(t *testing.T) {
+	locker := NewMemoryLocker()
+
+	var runs int32
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		job := NewChain(DistributedLock(locker, DiscardLogger, LockOptions{TTL: time.Second})).
+			Then(newIdJob("backup", func() {
+				atomic.AddInt32(&runs, 1)
+				time.Sleep(50 * time.Millisecond)
+			}))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			job.Run()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if runs != 1 {
+		t.Errorf("expected 1 run across replicas, got %d", runs)
+	}
+}
+
+func TestDistributedLockKeepsID(t *testing.T) {
+	job := DistributedLock(NewMemoryLocker(), DiscardLogger, LockOptions{})(newIdJob("a", func() {}))
+	if job.ID() != "a" {
+		t.Errorf("expected wrapped job to keep its ID, got %q", job.ID())
+	}
+}
+
+func TestDistributedLockWithoutID(t *testing.T) {
+	var ran bool
+	DistributedLock(NewMemoryLocker(), DiscardLogger, LockOptions{})(FuncJob(func() { ran = true })).Run()
+	if ran {
+		t.Error("expected a job without ID to be skipped")
+	}
+}
+
+func TestDistributedLockMinHold(t *testing.T) {
+	locker := NewMemoryLocker()
+	wrapper := DistributedLock(locker, DiscardLogger, LockOptions{TTL: time.Second, MinHold: time.Minute})
+
+	var runs int
+	job := wrapper(newIdJob("a", func() { runs++ }))
+	job.Run()
+	job.Run()
+	if runs != 1 {
+		t.Errorf("expected the second run to be skipped during MinHold, got %d runs", runs)
+	}
+
+	// without MinHold, the lock is released as soon as the job is done
+	runs = 0
+	job = DistributedLock(locker, DiscardLogger, LockOptions{TTL: time.Second})(newIdJob("b", func() { runs++ }))
+	job.Run()
+	job.Run()
+	if runs != 2 {
+		t.Errorf("expected 2 runs, got %d", runs)
+	}
+}
+
+func TestDistributedLockHoldsActivation(t *testing.T) {
+	locker := NewMemoryLocker()
+	now := time.Now()
+	ctx := context.WithValue(context.Background(), activationKey{}, activation{at: now, next: now.Add(time.Minute)})
+
+	var runs int
+	job := DistributedLock(locker, DiscardLogger, LockOptions{TTL: time.Second})(newIdJob("a", func() { runs++ }))
+	runJob(ctx, job)
+	runJob(ctx, job)
+	if runs != 1 {
+		t.Errorf("expected a lagging replica to skip the activation, got %d runs", runs)
+	}
+
+	runs = 0
+	job = DistributedLock(locker, DiscardLogger, LockOptions{TTL: time.Second, MinHold: -1})(newIdJob("b", func() { runs++ }))
+	runJob(ctx, job)
+	runJob(ctx, job)
+	if runs != 2 {
+		t.Errorf("expected negative MinHold to release the lock, got %d runs", runs)
+	}
+}
+
+func TestDistributedLockFencingToken(t *testing.T) {
+	locker := NewMemoryLocker()
+	var tokens []int64
+	job := DistributedLock(locker, DiscardLogger, LockOptions{})(leaseJob("a", func(l *Lease) {
+		tokens = append(tokens, l.Token)
+	}))
+	job.Run()
+	job.Run()
+
+	if len(tokens) != 2 || tokens[0] != 1 || tokens[1] != 2 {
+		t.Errorf("expected increasing fencing tokens [1 2], got %v", tokens)
+	}
+}
+
+func TestDistributedLockRefresh(t *testing.T) {
+	locker := NewMemoryLocker()
+	job := DistributedLock(locker, DiscardLogger, LockOptions{TTL: 30 * time.Millisecond})(newIdJob("a", func() {
+		time.Sleep(100 * time.Millisecond)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		job.Run()
+		close(done)
+	}()
+
+	time.Sleep(70 * time.Millisecond)
+	if _, err := locker.TryLock(context.Background(), "cron:a", "other", time.Second); !errors.Is(err, ErrLockHeld) {
+		t.Errorf("expected the lease to be refreshed while the job runs, got %v", err)
+	}
+	<-done
+}
+
+type ctxIdJob struct {
+	idJob
+	f func(ctx context.Context) error
+}
+
+func (j ctxIdJob) RunContext(ctx context.Context) error { return j.f(ctx) }
+
+func TestDistributedLockLost(t *testing.T) {
+	locker := NewMemoryLocker()
+	job := DistributedLock(locker, DiscardLogger, LockOptions{TTL: 30 * time.Millisecond})(ctxIdJob{idJob{id: "a"}, func(ctx context.Context) error {
+		// another owner takes the lock over
+		locker.Unlock(ctx, LeaseFromContext(ctx))
+		if _, err := locker.TryLock(ctx, "cron:a", "other", time.Minute); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+			return errors.New("run not cancelled")
+		}
+	}})
+
+	if err := runJob(context.Background(), job); err != nil {
+		t.Errorf("expected the run to be cancelled when the lease is lost, got %v", err)
+	}
+}
+
+func testLocker(t *testing.T, locker Locker) {
+	ctx := context.Background()
+
+	lease, err := locker.TryLock(ctx, "k", "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locker.TryLock(ctx, "k", "b", time.Minute); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("expected ErrLockHeld, got %v", err)
+	}
+	if err = locker.Refresh(ctx, lease, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err = locker.Unlock(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	if err = locker.Refresh(ctx, lease, time.Minute); !errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected ErrLockLost, got %v", err)
+	}
+
+	lease2, err := locker.TryLock(ctx, "k", "b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease2.Token <= lease.Token {
+		t.Errorf("expected token to increase, got %d after %d", lease2.Token, lease.Token)
+	}
+
+	// a stale lease must not release the current holder
+	if err = locker.Unlock(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locker.TryLock(ctx, "k", "c", time.Minute); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("expected ErrLockHeld, got %v", err)
+	}
+}
+
+func TestMemoryLocker(t *testing.T) {
+	testLocker(t, NewMemoryLocker())
+}
+
+func TestRedisLocker(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+
+	testLocker(t, NewRedisLocker(client))
+
+	// the lock expires, the fencing counter does not
+	s.FastForward(2 * time.Minute)
+	lease, err := NewRedisLocker(client).TryLock(context.Background(), "k", "d", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Token != 3 {
+		t.Errorf("expected token 3, got %d", lease.Token)
+	}
+}
+
+func TestSQLLocker(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	locker := NewSQLLocker(db, "postgres", "")
+	now := time.Unix(1000, 0)
+	locker.now = func() time.Time { return now }
+
+	// first grant inserts the row
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE cron_lock SET owner = $1, token = token + 1, expire_at = $2 WHERE name = $3 AND expire_at <= $4")).
+		WithArgs("a", int64(1060000), "k", int64(1000000)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT token FROM cron_lock WHERE name = $1")).
+		WithArgs("k").
+		WillReturnRows(sqlmock.NewRows([]string{"token"}))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO cron_lock (name, owner, token, expire_at) VALUES ($1, $2, $3, $4)")).
+		WithArgs("k", "a", int64(1), int64(1060000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	lease, err := locker.TryLock(context.Background(), "k", "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Token != 1 {
+		t.Errorf("expected token 1, got %d", lease.Token)
+	}
+
+	// held by someone else
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE cron_lock SET owner").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT token FROM cron_lock").
+		WillReturnRows(sqlmock.NewRows([]string{"token"}).AddRow(1))
+	mock.ExpectRollback()
+
+	if _, err = locker.TryLock(context.Background(), "k", "b", time.Minute); !errors.Is(err, ErrLockHeld) {
+		t.Errorf("expected ErrLockHeld, got %v", err)
+	}
+
+	// expired lease is taken over with the next token
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE cron_lock SET owner").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT token FROM cron_lock").
+		WillReturnRows(sqlmock.NewRows([]string{"token"}).AddRow(2))
+	mock.ExpectCommit()
+
+	lease2, err := locker.TryLock(context.Background(), "k", "b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease2.Token != 2 {
+		t.Errorf("expected token 2, got %d", lease2.Token)
+	}
+
+	// the old holder can't refresh any more
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE cron_lock SET expire_at = $1 WHERE name = $2 AND owner = $3 AND token = $4 AND expire_at > $5")).
+		WithArgs(int64(1060000), "k", "a", int64(1), int64(1000000)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err = locker.Refresh(context.Background(), lease, time.Minute); !errors.Is(err, ErrLockLost) {
+		t.Errorf("expected ErrLockLost, got %v", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	glog "github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
 )
 
 // DefaultLogger is used by Cron if none is specified.
@@ -56,6 +59,34 @@ func (pl printfLogger) Error(err error, msg string, keysAndValues ...interface{}
 		append([]interface{}{msg, "error", err}, keysAndValues...)...)
 }
 
+// ZapLogger adapts a zap.Logger into an implementation of the Logger interface
+// which logs everything, with keysAndValues as fields. A nil l logs to
+// log.Glog, looked up on every call so that a logger set up later by
+// log.InitZap is used.
+func ZapLogger(l *zap.Logger) Logger {
+	return zapLogger{l}
+}
+
+type zapLogger struct {
+	logger *zap.Logger
+}
+
+func (zl zapLogger) sugar() *zap.SugaredLogger {
+	l := zl.logger
+	if l == nil {
+		l = glog.Glog
+	}
+	return l.WithOptions(zap.AddCallerSkip(1)).Sugar()
+}
+
+func (zl zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	zl.sugar().Infow(msg, keysAndValues...)
+}
+
+func (zl zapLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	zl.sugar().Errorw(msg, append([]interface{}{zap.Error(err)}, keysAndValues...)...)
+}
+
 // formatString returns a logfmt-like format string for the number of
 // key/values.
 func formatString(numKeysAndValues int) string {
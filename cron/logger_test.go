@@ -0,0 +1,29 @@
+package cron
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestZapLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := ZapLogger(zap.New(core))
+
+	logger.Info("skip", "key", "cron:a")
+	logger.Error(errors.New("boom"), "lock", "key", "cron:b")
+
+	entries := logs.AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Level != zapcore.InfoLevel || e.Message != "skip" || e.ContextMap()["key"] != "cron:a" {
+		t.Errorf("unexpected info entry %v %v", e.Entry, e.ContextMap())
+	}
+	if e := entries[1]; e.Level != zapcore.ErrorLevel || e.ContextMap()["error"] != "boom" || e.ContextMap()["key"] != "cron:b" {
+		t.Errorf("unexpected error entry %v %v", e.Entry, e.ContextMap())
+	}
+}
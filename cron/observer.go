@@ -0,0 +1,90 @@
+package cron
+
+import (
+	"context"
+	"time"
+)
+
+// Observer is notified of the lifecycle of the entries of a Cron, e.g. to
+// export metrics. OnScheduled is called from the scheduler goroutine, OnStart
+// and OnFinish from the goroutine running the job. OnMissed is called from the
+// scheduler goroutine, or from the goroutine of the job if Cron was stopped
+// while it waited for a slot. OnRemoved is called from the scheduler
+// goroutine, or from the goroutine calling Remove if Cron is not running.
+// Implementations must be safe for concurrent use and must not block.
+//
+// The Entry passed in is a snapshot, its Next is the activation being handled.
+type Observer interface {
+	// OnScheduled is called when the next activation of an entry has been
+	// computed: when the scheduler starts, when the entry is added while it
+	// is running, and after each activation.
+	OnScheduled(e Entry)
+	// OnStart is called right before the job runs. The returned context,
+	// derived from ctx, is passed to OnFinish, to the OnStart of the next
+	// Observer and, from the last one, to the job.
+	OnStart(ctx context.Context, e Entry) context.Context
+	// OnFinish is called when the job returns, with its error and how long it ran.
+	OnFinish(ctx context.Context, e Entry, err error, duration time.Duration)
+	// OnMissed is called for an activation that was not run: the scheduler
+	// woke up after it, or Cron was stopped while it waited for a slot (see
+	// WithMaxConcurrent).
+	OnMissed(e Entry)
+	// OnRemoved is called when an entry is removed, either explicitly or
+	// because its job is over its EndTime.
+	OnRemoved(e Entry)
+}
+
+// NopObserver implements Observer doing nothing. Embed it to implement only
+// some of the methods.
+type NopObserver struct{}
+
+func (NopObserver) OnScheduled(e Entry) {}
+
+func (NopObserver) OnStart(ctx context.Context, e Entry) context.Context { return ctx }
+
+func (NopObserver) OnFinish(ctx context.Context, e Entry, err error, duration time.Duration) {}
+
+func (NopObserver) OnMissed(e Entry) {}
+
+func (NopObserver) OnRemoved(e Entry) {}
+
+// observers fans out the notifications to every registered Observer.
+type observers []Observer
+
+func (os observers) scheduled(e Entry) {
+	for _, o := range os {
+		o.OnScheduled(e)
+	}
+}
+
+// start calls OnStart of each observer with the context returned by the
+// previous one, and returns these contexts. The last one is passed to the job.
+func (os observers) start(ctx context.Context, e Entry) []context.Context {
+	ctxs := make([]context.Context, len(os)+1)
+	ctxs[0] = ctx
+	for i, o := range os {
+		ctxs[i+1] = o.OnStart(ctxs[i], e)
+	}
+	return ctxs[1:]
+}
+
+// finish calls OnFinish of each observer with the context it returned from
+// OnStart, see start.
+func (os observers) finish(ctxs []context.Context, e Entry, err error, duration time.Duration) {
+	// in reverse, so that spans started in OnStart end innermost first
+	for i := len(os) - 1; i >= 0; i-- {
+		os[i].OnFinish(ctxs[i], e, err, duration)
+	}
+}
+
+func (os observers) missed(e Entry) {
+	for _, o := range os {
+		o.OnMissed(e)
+	}
+}
+
+func (os observers) removed(e Entry) {
+	for _, o := range os {
+		o.OnRemoved(e)
+	}
+}
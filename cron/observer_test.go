@@ -0,0 +1,144 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type observerKey struct{}
+
+// recordingObserver records the notifications it receives as strings.
+type recordingObserver struct {
+	mu       sync.Mutex
+	events   []string
+	finished chan struct{}
+}
+
+func newRecordingObserver() *recordingObserver {
+	return &recordingObserver{finished: make(chan struct{}, 10)}
+}
+
+func (o *recordingObserver) record(format string, args ...interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, fmt.Sprintf(format, args...))
+}
+
+func (o *recordingObserver) Events() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.events...)
+}
+
+func (o *recordingObserver) OnScheduled(e Entry) { o.record("scheduled %d", e.ID) }
+
+func (o *recordingObserver) OnStart(ctx context.Context, e Entry) context.Context {
+	o.record("start %d", e.ID)
+	return context.WithValue(ctx, observerKey{}, "observed")
+}
+
+func (o *recordingObserver) OnFinish(ctx context.Context, e Entry, err error, duration time.Duration) {
+	o.record("finish %d %v %v", e.ID, err, ctx.Value(observerKey{}))
+	o.finished <- struct{}{}
+}
+
+func (o *recordingObserver) OnMissed(e Entry)  { o.record("missed %d", e.ID) }
+func (o *recordingObserver) OnRemoved(e Entry) { o.record("removed %d", e.ID) }
+
+// ctxObserver adds its name to the context in OnStart, and records the name
+// it gets back in OnFinish.
+type ctxObserver struct {
+	NopObserver
+	name     string
+	finished *[]string
+}
+
+type ctxObserverKey struct{}
+
+func (o ctxObserver) OnStart(ctx context.Context, e Entry) context.Context {
+	return context.WithValue(ctx, ctxObserverKey{}, o.name)
+}
+
+func (o ctxObserver) OnFinish(ctx context.Context, e Entry, err error, duration time.Duration) {
+	*o.finished = append(*o.finished, fmt.Sprint(ctx.Value(ctxObserverKey{})))
+}
+
+func TestObserversContext(t *testing.T) {
+	var finished []string
+	os := observers{ctxObserver{name: "outer", finished: &finished}, ctxObserver{name: "inner", finished: &finished}}
+
+	ctxs := os.start(context.Background(), Entry{})
+	if v := ctxs[len(ctxs)-1].Value(ctxObserverKey{}); v != "inner" {
+		t.Errorf("expected the job to get the innermost context, got %v", v)
+	}
+	os.finish(ctxs, Entry{}, nil, 0)
+	if fmt.Sprint(finished) != "[inner outer]" {
+		t.Errorf("expected each observer to get its own context, got %v", finished)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	obs := newRecordingObserver()
+	cron := New(WithParser(secondParser), WithChain(), WithObserver(obs))
+
+	var value interface{}
+	id, _ := cron.AddContextFunc("* * * * * ?", func(ctx context.Context) error {
+		value = ctx.Value(observerKey{})
+		return errors.New("boom")
+	})
+	cron.Start()
+
+	select {
+	case <-time.After(OneSecond):
+		t.Fatal("expected job runs")
+	case <-obs.finished:
+	}
+	<-cron.Stop().Done()
+	cron.Remove(id)
+
+	// the job runs concurrently with the scheduling of the next activation
+	var scheduled, run []string
+	for _, e := range obs.Events() {
+		if e == "scheduled 1" {
+			scheduled = append(scheduled, e)
+		} else {
+			run = append(run, e)
+		}
+	}
+	if len(scheduled) != 2 {
+		t.Errorf("expected 2 activations scheduled, got %v", scheduled)
+	}
+	expected := []string{"start 1", "finish 1 boom observed", "removed 1"}
+	if fmt.Sprint(run) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, run)
+	}
+	if value != "observed" {
+		t.Errorf("expected the job to get the context returned by OnStart, got %v", value)
+	}
+}
+
+// pastJob always asks to run an hour ago.
+type pastJob struct {
+	idJob
+}
+
+func (j pastJob) Next(now time.Time) time.Time { return now.Add(-time.Hour) }
+
+func TestObserverMissed(t *testing.T) {
+	obs := newRecordingObserver()
+	cron := New(WithParser(secondParser), WithChain(), WithObserver(obs))
+	cron.AddJob("* * * * * ?", pastJob{newIdJob("late", func() {})})
+	cron.Start()
+	time.Sleep(50 * time.Millisecond)
+	<-cron.Stop().Done()
+
+	expected := []string{"scheduled 1", "missed 1", "removed 1"}
+	actual := obs.Events()
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
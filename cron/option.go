@@ -43,3 +43,39 @@ func WithLogger(logger Logger) Option {
 		c.logger = logger
 	}
 }
+
+// WithJitter delays the schedule of every entry by a deterministic offset in
+// [0, max), seeded by the entry's UniqueID, so that entries sharing a spec
+// don't all fire at once. See Jitter.
+func WithJitter(max time.Duration) Option {
+	return func(c *Cron) {
+		c.jitter = max
+	}
+}
+
+// WithBlackout keeps all entries from running inside the given windows,
+// skipping or deferring the activations that fall into them. See Blackout.
+func WithBlackout(mode BlackoutMode, windows ...BlackoutWindow) Option {
+	return func(c *Cron) {
+		c.blackoutMode = mode
+		c.blackouts = windows
+	}
+}
+
+// WithMaxConcurrent limits the number of jobs running at the same time.
+// Activations beyond the limit wait for a running job to finish.
+func WithMaxConcurrent(n int) Option {
+	return func(c *Cron) {
+		if n > 0 {
+			c.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithObserver registers observers notified of the lifecycle of entries and
+// of each run. It may be given several times.
+func WithObserver(obs ...Observer) Option {
+	return func(c *Cron) {
+		c.observers = append(c.observers, obs...)
+	}
+}
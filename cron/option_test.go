@@ -1,6 +1,7 @@
 package cron
 
 import (
+	"sync"
 	"testing"
 	"time"
 )
@@ -39,3 +40,87 @@ func TestWithParser(t *testing.T) {
 // 		t.Error("expected to see some actions, got:", out)
 // 	}
 // }
+
+func TestWithJitter(t *testing.T) {
+	c := New(WithJitter(time.Hour))
+	id, _ := c.AddJob("@hourly", newIdJob("backup-1", func() {}))
+
+	s, ok := c.Entry(id).Schedule.(JitterSchedule)
+	if !ok {
+		t.Fatalf("expected a JitterSchedule, got %T", c.Entry(id).Schedule)
+	}
+	if s.Offset != Jitter(nil, time.Hour, "backup-1").Offset {
+		t.Errorf("expected the offset to be seeded by the unique id, got %v", s.Offset)
+	}
+}
+
+func TestWithBlackout(t *testing.T) {
+	w, _ := ParseBlackoutWindow("Mon-Fri 09:00-18:00")
+	c := New(WithJitter(time.Hour), WithBlackout(BlackoutDefer, w))
+	id, _ := c.AddFunc("@hourly", func() {})
+
+	s, ok := c.Entry(id).Schedule.(BlackoutSchedule)
+	if !ok {
+		t.Fatalf("expected a BlackoutSchedule, got %T", c.Entry(id).Schedule)
+	}
+	if _, ok = s.Schedule.(JitterSchedule); !ok {
+		t.Errorf("expected the blackout to apply to the jittered schedule, got %T", s.Schedule)
+	}
+}
+
+func TestWithMaxConcurrent(t *testing.T) {
+	var running, max, done int32
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(4)
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+
+	c := New(WithParser(secondParser), WithChain(), WithMaxConcurrent(2))
+	for i := 0; i < 4; i++ {
+		c.AddFunc("* * * * * ?", func() {
+			mu.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mu.Unlock()
+
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+
+			mu.Lock()
+			running--
+			done++
+			if done <= 4 {
+				wg.Done()
+			}
+			mu.Unlock()
+		})
+	}
+	c.Start()
+
+	// hold the first two jobs until the others are waiting for a slot
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(OneSecond + 300*time.Millisecond):
+			t.Fatal("expected jobs to run")
+		case <-started:
+		}
+	}
+	close(release)
+
+	select {
+	case <-time.After(OneSecond):
+		t.Fatal("expected the waiting jobs to run")
+	case <-wait(&wg):
+	}
+	<-c.Stop().Done()
+
+	if max != 2 {
+		t.Errorf("expected at most 2 jobs running at once, got %d", max)
+	}
+}
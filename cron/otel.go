@@ -0,0 +1,117 @@
+package cron
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/meilihao/golib/v2/cron"
+
+// OtelObserver is an Observer recording a span per run and the metrics:
+//
+//	cron.runs      counter of finished runs, by status (ok or error)
+//	cron.duration  histogram of run durations, in seconds
+//	cron.lateness  histogram of the delay between Entry.Next and the actual
+//	               start of the run, in seconds
+//	cron.missed    counter of activations that were not run
+//
+// All are attributed with the entry's id and unique id.
+type OtelObserver struct {
+	tracer   trace.Tracer
+	runs     metric.Int64Counter
+	duration metric.Float64Histogram
+	lateness metric.Float64Histogram
+	missed   metric.Int64Counter
+}
+
+// NewOtelObserver returns an OtelObserver using the given providers, or the
+// global ones set up by golib.InitOTEL if nil.
+func NewOtelObserver(tp trace.TracerProvider, mp metric.MeterProvider) (*OtelObserver, error) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	o := &OtelObserver{
+		tracer: tp.Tracer(instrumentationName),
+	}
+	var err error
+	if o.runs, err = meter.Int64Counter("cron.runs",
+		metric.WithDescription("Number of finished job runs")); err != nil {
+		return nil, err
+	}
+	if o.duration, err = meter.Float64Histogram("cron.duration",
+		metric.WithDescription("Duration of job runs"), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if o.lateness, err = meter.Float64Histogram("cron.lateness",
+		metric.WithDescription("Delay between the scheduled and the actual start of job runs"), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if o.missed, err = meter.Int64Counter("cron.missed",
+		metric.WithDescription("Number of activations that were not run")); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func entryAttributes(e Entry) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("cron.entry_id", strconv.Itoa(int(e.ID))),
+		attribute.String("cron.unique_id", e.UniqueID),
+	}
+}
+
+func (o *OtelObserver) OnScheduled(e Entry) {}
+
+func (o *OtelObserver) OnStart(ctx context.Context, e Entry) context.Context {
+	now := time.Now()
+	attrs := entryAttributes(e)
+
+	if !e.Next.IsZero() {
+		lateness := now.Sub(e.Next)
+		if lateness < 0 {
+			lateness = 0
+		}
+		o.lateness.Record(ctx, lateness.Seconds(), metric.WithAttributes(attrs...))
+	}
+
+	ctx, _ = o.tracer.Start(ctx, "cron.run",
+		trace.WithTimestamp(now),
+		trace.WithAttributes(attrs...),
+		trace.WithAttributes(attribute.String("cron.scheduled", e.Next.Format(time.RFC3339))))
+	return ctx
+}
+
+func (o *OtelObserver) OnFinish(ctx context.Context, e Entry, err error, duration time.Duration) {
+	attrs := entryAttributes(e)
+
+	span := trace.SpanFromContext(ctx)
+	status := "ok"
+	if err != nil {
+		status = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	o.runs.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("status", status))...))
+	o.duration.Record(ctx, duration.Seconds(), metric.WithAttributes(attrs...))
+}
+
+func (o *OtelObserver) OnMissed(e Entry) {
+	o.missed.Add(context.Background(), 1, metric.WithAttributes(entryAttributes(e)...))
+}
+
+func (o *OtelObserver) OnRemoved(e Entry) {}
@@ -0,0 +1,67 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOtelObserver(t *testing.T) {
+	spans := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	o, err := NewOtelObserver(
+		sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := Entry{ID: 1, UniqueID: "backup", Next: time.Now().Add(-2 * time.Second)}
+	ctx := o.OnStart(context.Background(), e)
+	o.OnFinish(ctx, e, errors.New("boom"), 3*time.Second)
+	o.OnMissed(e)
+
+	if n := len(spans.GetSpans()); n != 1 {
+		t.Fatalf("expected 1 span, got %d", n)
+	}
+	span := spans.GetSpans()[0]
+	if span.Name != "cron.run" || span.Status.Code != codes.Error {
+		t.Errorf("expected a failed cron.run span, got %s %v", span.Name, span.Status)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err = reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+
+	runs := metrics["cron.runs"].(metricdata.Sum[int64]).DataPoints
+	if len(runs) != 1 || runs[0].Value != 1 {
+		t.Fatalf("expected 1 run, got %v", runs)
+	}
+	if status, _ := runs[0].Attributes.Value(attribute.Key("status")); status.AsString() != "error" {
+		t.Errorf("expected status error, got %v", status.AsString())
+	}
+	if d := metrics["cron.duration"].(metricdata.Histogram[float64]).DataPoints; len(d) != 1 || d[0].Sum != 3 {
+		t.Errorf("expected a 3s duration, got %v", d)
+	}
+	if l := metrics["cron.lateness"].(metricdata.Histogram[float64]).DataPoints; len(l) != 1 || l[0].Sum < 2 {
+		t.Errorf("expected a lateness of at least 2s, got %v", l)
+	}
+	if m := metrics["cron.missed"].(metricdata.Sum[int64]).DataPoints; len(m) != 1 || m[0].Value != 1 {
+		t.Errorf("expected 1 missed activation, got %v", m)
+	}
+}
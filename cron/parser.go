@@ -24,6 +24,9 @@ const (
 	Dow                                    // Day of week field, default *
 	DowOptional                            // Optional day of week field, default *
 	Descriptor                             // Allow descriptors such as @monthly, @weekly, etc.
+	Year                                   // Year field after day of week, default *
+	YearOptional                           // Optional year field, default *
+	Extended                               // Allow L, W and # in the day of month and day of week fields
 )
 
 var places = []ParseOption{
@@ -33,6 +36,7 @@ var places = []ParseOption{
 	Dom,
 	Month,
 	Dow,
+	Year,
 }
 
 var defaults = []string{
@@ -42,6 +46,7 @@ var defaults = []string{
 	"*",
 	"*",
 	"*",
+	"*",
 }
 
 // A custom Parser that can be configured.
@@ -68,6 +73,10 @@ type Parser struct {
 //  specParser := NewParser(Dom | Month | DowOptional)
 //  sched, err := specParser.Parse("15 */3")
 //
+//  // Quartz style: last Friday of the month at 22:00, in 2025 and 2026 only
+//  specParser := NewParser(Second | Minute | Hour | Dom | Month | Dow | YearOptional | Extended)
+//  sched, err := specParser.Parse("0 0 22 ? * FRIL 2025-2026")
+//
 func NewParser(options ParseOption) Parser {
 	optionals := 0
 	if options&DowOptional > 0 {
@@ -76,6 +85,9 @@ func NewParser(options ParseOption) Parser {
 	if options&SecondOptional > 0 {
 		optionals++
 	}
+	if options&YearOptional > 0 {
+		optionals++
+	}
 	if optionals > 1 {
 		panic("multiple optionals may not be configured")
 	}
@@ -129,19 +141,40 @@ func (p Parser) Parse(spec string) (Schedule, error) {
 		return bits
 	}
 
+	dayField := func(field string, r bounds, parseRule func(string) (dayRule, bool, error)) (uint64, []dayRule) {
+		if err != nil {
+			return 0, nil
+		}
+		var bits uint64
+		var rules []dayRule
+		if p.options&Extended > 0 {
+			bits, rules, err = getDayField(field, r, parseRule)
+		} else {
+			bits, err = getField(field, r)
+		}
+		return bits, rules
+	}
+
 	var (
-		second     = field(fields[0], seconds)
-		minute     = field(fields[1], minutes)
-		hour       = field(fields[2], hours)
-		dayofmonth = field(fields[3], dom)
-		month      = field(fields[4], months)
-		dayofweek  = field(fields[5], dow)
+		second               = field(fields[0], seconds)
+		minute               = field(fields[1], minutes)
+		hour                 = field(fields[2], hours)
+		dayofmonth, domRules = dayField(fields[3], dom, parseDomRule)
+		month                = field(fields[4], months)
+		dayofweek, dowRules  = dayField(fields[5], dow, parseDowRule)
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &SpecSchedule{
+	var year map[int]struct{}
+	if len(fields) > 6 {
+		if year, err = getYears(fields[6]); err != nil {
+			return nil, err
+		}
+	}
+
+	sched := SpecSchedule{
 		Second:   second,
 		Minute:   minute,
 		Hour:     hour,
@@ -149,6 +182,16 @@ func (p Parser) Parse(spec string) (Schedule, error) {
 		Month:    month,
 		Dow:      dayofweek,
 		Location: loc,
+	}
+	if year == nil && domRules == nil && dowRules == nil {
+		return &sched, nil
+	}
+
+	return &ExtendedSchedule{
+		SpecSchedule: sched,
+		Year:         year,
+		domRules:     domRules,
+		dowRules:     dowRules,
 	}, nil
 }
 
@@ -168,6 +211,10 @@ func normalizeFields(fields []string, options ParseOption) ([]string, error) {
 		options |= Dow
 		optionals++
 	}
+	if options&YearOptional > 0 {
+		options |= Year
+		optionals++
+	}
 	if optionals > 1 {
 		return nil, fmt.Errorf("multiple optionals may not be configured")
 	}
@@ -193,18 +240,30 @@ func normalizeFields(fields []string, options ParseOption) ([]string, error) {
 	if min < max && len(fields) == min {
 		switch {
 		case options&DowOptional > 0:
-			fields = append(fields, defaults[5]) // TODO: improve access to default
+			if options&Year > 0 {
+				// keep the year last
+				last := len(fields) - 1
+				fields = append(append(fields[:last:last], defaults[5]), fields[last])
+			} else {
+				fields = append(fields, defaults[5]) // TODO: improve access to default
+			}
 		case options&SecondOptional > 0:
 			fields = append([]string{defaults[0]}, fields...)
+		case options&YearOptional > 0:
+			fields = append(fields, defaults[6])
 		default:
 			return nil, fmt.Errorf("unknown optional field")
 		}
 	}
 
-	// Populate all fields not part of options with their defaults
+	// Populate all fields not part of options with their defaults.
+	// The year is only returned if configured.
 	n := 0
 	expandedFields := make([]string, len(places))
 	copy(expandedFields, defaults)
+	if options&Year == 0 {
+		expandedFields = expandedFields[:len(places)-1]
+	}
 	for i, place := range places {
 		if options&place > 0 {
 			expandedFields[i] = fields[n]
@@ -317,6 +376,171 @@ func getRange(expr string, r bounds) (uint64, error) {
 	return getBits(start, end, step) | extra, nil
 }
 
+// getDayField is getField for the day of month and day of week fields of
+// Extended parsers. Expressions recognized by parseRule are returned as rules
+// instead of bits.
+func getDayField(field string, r bounds, parseRule func(string) (dayRule, bool, error)) (uint64, []dayRule, error) {
+	var bits uint64
+	var rules []dayRule
+	ranges := strings.FieldsFunc(field, func(r rune) bool { return r == ',' })
+	for _, expr := range ranges {
+		rule, ok, err := parseRule(expr)
+		if err != nil {
+			return 0, nil, err
+		}
+		if ok {
+			rules = append(rules, rule)
+			continue
+		}
+
+		bit, err := getRange(expr, r)
+		if err != nil {
+			return 0, nil, err
+		}
+		bits |= bit
+	}
+	return bits, rules, nil
+}
+
+// parseDomRule parses the day of month expressions
+//   "L" | "L-" number | "LW" | number "W"
+// ok is false if expr is none of them.
+func parseDomRule(expr string) (rule dayRule, ok bool, err error) {
+	upper := strings.ToUpper(expr)
+	switch {
+	case upper == "L":
+		return dayRule{kind: lastDay}, true, nil
+	case upper == "LW":
+		return dayRule{kind: lastWeekday}, true, nil
+	case strings.HasPrefix(upper, "L-"):
+		offset, err := mustParseInt(expr[2:])
+		if err != nil {
+			return rule, false, err
+		}
+		if offset > dom.max-1 {
+			return rule, false, fmt.Errorf("offset from last day (%d) above maximum (%d): %s", offset, dom.max-1, expr)
+		}
+		return dayRule{kind: lastDay, value: int(offset)}, true, nil
+	case len(upper) > 1 && strings.HasSuffix(upper, "W"):
+		day, err := mustParseInt(expr[:len(expr)-1])
+		if err != nil {
+			return rule, false, err
+		}
+		if day < dom.min || day > dom.max {
+			return rule, false, fmt.Errorf("day (%d) out of range [%d, %d]: %s", day, dom.min, dom.max, expr)
+		}
+		return dayRule{kind: nearestWeekday, value: int(day)}, true, nil
+	}
+	return rule, false, nil
+}
+
+// parseDowRule parses the day of week expressions
+//   (number | name) "L" | (number | name) "#" number
+// ok is false if expr is none of them.
+func parseDowRule(expr string) (rule dayRule, ok bool, err error) {
+	parseDay := func(s string) (uint, error) {
+		day, err := parseIntOrName(s, dow.names)
+		if err != nil {
+			return 0, err
+		}
+		if day > dow.max {
+			return 0, fmt.Errorf("day of week (%d) above maximum (%d): %s", day, dow.max, expr)
+		}
+		return day, nil
+	}
+
+	if i := strings.Index(expr, "#"); i >= 0 {
+		day, err := parseDay(expr[:i])
+		if err != nil {
+			return rule, false, err
+		}
+		nth, err := mustParseInt(expr[i+1:])
+		if err != nil {
+			return rule, false, err
+		}
+		if nth < 1 || nth > 5 {
+			return rule, false, fmt.Errorf("occurrence (%d) out of range [1, 5]: %s", nth, expr)
+		}
+		return dayRule{kind: nthDow, value: int(day), nth: int(nth)}, true, nil
+	}
+
+	if len(expr) > 1 && strings.HasSuffix(strings.ToUpper(expr), "L") {
+		day, err := parseDay(expr[:len(expr)-1])
+		if err != nil {
+			return rule, false, err
+		}
+		return dayRule{kind: lastDow, value: int(day)}, true, nil
+	}
+	return rule, false, nil
+}
+
+// getYears returns the set of years represented by the year field, or nil for
+// every year. Its syntax is that of the other fields, see getRange.
+func getYears(field string) (map[int]struct{}, error) {
+	years := make(map[int]struct{})
+	for _, expr := range strings.FieldsFunc(field, func(r rune) bool { return r == ',' }) {
+		var (
+			start, end, step uint
+			rangeAndStep     = strings.Split(expr, "/")
+			lowAndHigh       = strings.Split(rangeAndStep[0], "-")
+			err              error
+		)
+
+		if lowAndHigh[0] == "*" || lowAndHigh[0] == "?" {
+			if len(rangeAndStep) == 1 {
+				return nil, nil
+			}
+			start, end = yearBounds.min, yearBounds.max
+		} else {
+			if start, err = mustParseInt(lowAndHigh[0]); err != nil {
+				return nil, err
+			}
+			switch len(lowAndHigh) {
+			case 1:
+				end = start
+			case 2:
+				if end, err = mustParseInt(lowAndHigh[1]); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("too many hyphens: %s", expr)
+			}
+		}
+
+		switch len(rangeAndStep) {
+		case 1:
+			step = 1
+		case 2:
+			if step, err = mustParseInt(rangeAndStep[1]); err != nil {
+				return nil, err
+			}
+			if len(lowAndHigh) == 1 {
+				end = yearBounds.max
+			}
+		default:
+			return nil, fmt.Errorf("too many slashes: %s", expr)
+		}
+
+		if start < yearBounds.min {
+			return nil, fmt.Errorf("beginning of range (%d) below minimum (%d): %s", start, yearBounds.min, expr)
+		}
+		if end > yearBounds.max {
+			return nil, fmt.Errorf("end of range (%d) above maximum (%d): %s", end, yearBounds.max, expr)
+		}
+		if start > end {
+			return nil, fmt.Errorf("beginning of range (%d) beyond end of range (%d): %s", start, end, expr)
+		}
+		if step == 0 {
+			return nil, fmt.Errorf("step of range should be a positive number: %s", expr)
+		}
+
+		for y := start; y <= end; y += step {
+			years[int(y)] = struct{}{}
+		}
+	}
+	return years, nil
+}
+
 // parseIntOrName returns the (possibly-named) integer contained in expr.
 func parseIntOrName(expr string, names map[string]uint) (uint, error) {
 	if names != nil {
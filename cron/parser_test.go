@@ -8,6 +8,7 @@ import (
 )
 
 var secondParser = NewParser(Second | Minute | Hour | Dom | Month | DowOptional | Descriptor)
+var extendedParser = NewParser(Second | Minute | Hour | Dom | Month | Dow | YearOptional | Extended | Descriptor)
 
 func TestRange(t *testing.T) {
 	zero := uint64(0)
@@ -252,6 +253,24 @@ func TestNormalizeFields(t *testing.T) {
 			SecondOptional | Hour | Dom | Month,
 			[]string{"0", "0", "5", "15", "*", "*"},
 		},
+		{
+			"AllFields_YearOptional_Provided",
+			[]string{"0", "5", "*", "*", "*", "*", "2030"},
+			Second | Minute | Hour | Dom | Month | Dow | YearOptional,
+			[]string{"0", "5", "*", "*", "*", "*", "2030"},
+		},
+		{
+			"AllFields_YearOptional_NotProvided",
+			[]string{"0", "5", "*", "*", "*", "*"},
+			Second | Minute | Hour | Dom | Month | Dow | YearOptional,
+			[]string{"0", "5", "*", "*", "*", "*", "*"},
+		},
+		{
+			"SubsetFields_Year_DowOptional_NotProvided",
+			[]string{"5", "15", "*", "2030"},
+			Hour | Dom | Month | DowOptional | Year,
+			[]string{"0", "0", "5", "15", "*", "*", "2030"},
+		},
 	}
 
 	for _, test := range tests {
@@ -280,6 +299,12 @@ func TestNormalizeFields_Errors(t *testing.T) {
 			SecondOptional | Minute | Hour | Dom | Month | DowOptional,
 			"",
 		},
+		{
+			"TwoOptionals_Year",
+			[]string{"0", "5", "*", "*", "*", "*"},
+			SecondOptional | Minute | Hour | Dom | Month | Dow | YearOptional,
+			"",
+		},
 		{
 			"TooManyFields",
 			[]string{"0", "5", "*", "*"},
@@ -312,6 +337,66 @@ func TestNormalizeFields_Errors(t *testing.T) {
 	}
 }
 
+func TestExtendedParseErrors(t *testing.T) {
+	var tests = []struct {
+		parser    Parser
+		expr, err string
+	}{
+		{secondParser, "0 0 0 L * ?", "failed to parse int from L"},
+		{secondParser, "0 0 0 ? * FRI#2", "failed to parse int from FRI#2"},
+		{extendedParser, "0 0 0 L-31 * ?", "above maximum"},
+		{extendedParser, "0 0 0 32W * ?", "out of range"},
+		{extendedParser, "0 0 0 0W * ?", "out of range"},
+		{extendedParser, "0 0 0 ? * FRI#6", "out of range"},
+		{extendedParser, "0 0 0 ? * FRI#0", "out of range"},
+		{extendedParser, "0 0 0 ? * 7L", "above maximum"},
+		{extendedParser, "0 0 0 ? * XYZ#1", "failed to parse int from XYZ"},
+		{extendedParser, "0 0 0 ? * L", "failed to parse int from L"},
+		{extendedParser, "0 0 0 * * ? 1969", "below minimum"},
+		{extendedParser, "0 0 0 * * ? 2100", "above maximum"},
+		{extendedParser, "0 0 0 * * ? 2030-2020", "beyond end of range"},
+		{extendedParser, "0 0 0 * * ? 2020/0", "should be a positive number"},
+	}
+	for _, c := range tests {
+		_, err := c.parser.Parse(c.expr)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s => expected %v, got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestExtendedParse(t *testing.T) {
+	sched, err := extendedParser.Parse("0 0 22 L,15W ? * 2025,2027-2029")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sched.(*ExtendedSchedule)
+	if !reflect.DeepEqual(s.domRules, []dayRule{{kind: lastDay}, {kind: nearestWeekday, value: 15}}) {
+		t.Errorf("unexpected day of month rules: %+v", s.domRules)
+	}
+	if s.Dom != 0 {
+		t.Errorf("expected no day of month bits, got %b", s.Dom)
+	}
+	if !reflect.DeepEqual(s.Year, map[int]struct{}{2025: {}, 2027: {}, 2028: {}, 2029: {}}) {
+		t.Errorf("unexpected years: %v", s.Year)
+	}
+
+	sched, err = extendedParser.Parse("0 0 22 ? * 1-3,FRIL,mon#2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = sched.(*ExtendedSchedule)
+	if !reflect.DeepEqual(s.dowRules, []dayRule{{kind: lastDow, value: 5}, {kind: nthDow, value: 1, nth: 2}}) {
+		t.Errorf("unexpected day of week rules: %+v", s.dowRules)
+	}
+	if s.Dow != getBits(1, 3, 1) {
+		t.Errorf("unexpected day of week bits: %b", s.Dow)
+	}
+	if s.Year != nil {
+		t.Errorf("expected every year, got %v", s.Year)
+	}
+}
+
 func TestStandardSpecSchedule(t *testing.T) {
 	entries := []struct {
 		expr     string
@@ -0,0 +1,201 @@
+package cron
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// JitterSchedule delays every activation of a Schedule by the same offset,
+// derived from a seed, so that many entries on the same spec (e.g. @hourly)
+// are spread over the jitter window instead of all firing at :00.
+type JitterSchedule struct {
+	Schedule Schedule
+	Offset   time.Duration
+}
+
+// Jitter returns s delayed by an offset in [0, max) derived from seed,
+// usually the Entry's UniqueID. The offset is deterministic, so each entry
+// keeps its slot across restarts and replicas.
+func Jitter(s Schedule, max time.Duration, seed string) JitterSchedule {
+	var offset time.Duration
+	if max >= time.Second {
+		h := fnv.New64a()
+		h.Write([]byte(seed))
+		// the spec has a one second granularity
+		offset = time.Duration(h.Sum64()%uint64(max/time.Second)) * time.Second
+	}
+
+	return JitterSchedule{
+		Schedule: s,
+		Offset:   offset,
+	}
+}
+
+// Next returns the next activation of the wrapped schedule plus the offset.
+func (s JitterSchedule) Next(t time.Time) time.Time {
+	next := s.Schedule.Next(t.Add(-s.Offset))
+	if next.IsZero() {
+		return next
+	}
+	return next.Add(s.Offset)
+}
+
+// BlackoutWindow is a period of the day, on some days of the week, during
+// which jobs must not run.
+type BlackoutWindow struct {
+	// Days the window starts on, every day if empty.
+	Days []time.Weekday
+	// Start and End are offsets from midnight. If End is not after Start the
+	// window ends the next day.
+	Start, End time.Duration
+}
+
+// ParseBlackoutWindow parses "Mon-Fri 09:00-18:00", "Sat,Sun 22:00-06:00" or,
+// for every day, "09:00-18:00".
+func ParseBlackoutWindow(s string) (BlackoutWindow, error) {
+	var w BlackoutWindow
+
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+	case 2:
+		bits, err := getField(fields[0], dow)
+		if err != nil {
+			return w, err
+		}
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if 1<<uint(d)&bits > 0 {
+				w.Days = append(w.Days, d)
+			}
+		}
+	default:
+		return w, fmt.Errorf("expected [days] start-end, found %q", s)
+	}
+
+	span := strings.Split(fields[len(fields)-1], "-")
+	if len(span) != 2 {
+		return w, fmt.Errorf("expected start-end, found %q", fields[len(fields)-1])
+	}
+	var err error
+	if w.Start, err = parseClock(span[0]); err != nil {
+		return w, err
+	}
+	if w.End, err = parseClock(span[1]); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+// parseClock parses "15:04" into an offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse time of day %s: %s", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// end returns the end of the window containing t, or the zero time if t is
+// outside the window.
+func (w BlackoutWindow) end(t time.Time) time.Time {
+	// the window may have started the day before
+	for _, back := range []int{0, -1} {
+		day := t.AddDate(0, 0, back)
+		if !w.onDay(day.Weekday()) {
+			continue
+		}
+		// wall clock times, so DST transitions don't shift the window
+		clock := func(days int, offset time.Duration) time.Time {
+			return time.Date(day.Year(), day.Month(), day.Day()+days, 0, int(offset/time.Minute), 0, 0, t.Location())
+		}
+		start, end := clock(0, w.Start), clock(0, w.End)
+		if w.End <= w.Start {
+			end = clock(1, w.End)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return end
+		}
+	}
+	return time.Time{}
+}
+
+func (w BlackoutWindow) onDay(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, v := range w.Days {
+		if v == d {
+			return true
+		}
+	}
+	return false
+}
+
+// BlackoutMode selects what happens to activations inside a blackout window.
+type BlackoutMode int
+
+const (
+	// BlackoutSkip drops activations inside a window.
+	BlackoutSkip BlackoutMode = iota
+	// BlackoutDefer moves activations inside a window to its end, running
+	// them once there.
+	BlackoutDefer
+)
+
+// BlackoutSchedule keeps a Schedule from activating inside blackout windows.
+// Windows are evaluated in the location of the time given to Next.
+type BlackoutSchedule struct {
+	Schedule Schedule
+	Windows  []BlackoutWindow
+	Mode     BlackoutMode
+}
+
+// Blackout returns s restricted to run outside of windows.
+func Blackout(s Schedule, mode BlackoutMode, windows ...BlackoutWindow) BlackoutSchedule {
+	return BlackoutSchedule{
+		Schedule: s,
+		Windows:  windows,
+		Mode:     mode,
+	}
+}
+
+// Next returns the next activation of the wrapped schedule outside of the
+// blackout windows, or the zero time if none is found within a year.
+func (s BlackoutSchedule) Next(t time.Time) time.Time {
+	limit := t.AddDate(1, 0, 0)
+
+	next := s.Schedule.Next(t)
+	for !next.IsZero() && next.Before(limit) {
+		end := s.blackoutEnd(next)
+		if end.IsZero() {
+			return next
+		}
+		if s.Mode == BlackoutDefer {
+			// windows may be adjacent or overlap
+			for end.Before(limit) {
+				e := s.blackoutEnd(end)
+				if e.IsZero() {
+					return end
+				}
+				end = e
+			}
+			return time.Time{}
+		}
+		// the end of the window is outside of it
+		next = s.Schedule.Next(end.Add(-time.Nanosecond))
+	}
+	return time.Time{}
+}
+
+// blackoutEnd returns the end of the window containing t, or the zero time.
+func (s BlackoutSchedule) blackoutEnd(t time.Time) time.Time {
+	var end time.Time
+	for _, w := range s.Windows {
+		if e := w.end(t); e.After(end) {
+			end = e
+		}
+	}
+	return end
+}
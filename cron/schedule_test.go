@@ -0,0 +1,114 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	hourly, _ := ParseStandard("@hourly")
+
+	a := Jitter(hourly, time.Hour, "backup-1")
+	b := Jitter(hourly, time.Hour, "backup-2")
+	if a.Offset != Jitter(hourly, time.Hour, "backup-1").Offset {
+		t.Error("expected the offset to be deterministic")
+	}
+	if a.Offset == b.Offset {
+		t.Errorf("expected different offsets, got %v for both", a.Offset)
+	}
+
+	for _, s := range []JitterSchedule{a, b} {
+		if s.Offset < 0 || s.Offset >= time.Hour || s.Offset%time.Second != 0 {
+			t.Errorf("offset %v out of [0, 1h) or not whole seconds", s.Offset)
+		}
+
+		now := getTime("Mon Jul 9 15:00 2012")
+		next := s.Next(now)
+		if expected := now.Add(s.Offset); s.Offset > 0 && !next.Equal(expected) {
+			t.Errorf("expected %v, got %v", expected, next)
+		}
+		// the activation after is an hour later
+		if after := s.Next(next); !after.Equal(next.Add(time.Hour)) {
+			t.Errorf("expected %v, got %v", next.Add(time.Hour), after)
+		}
+	}
+
+	if s := Jitter(hourly, 0, "backup-1"); s.Offset != 0 {
+		t.Errorf("expected no offset, got %v", s.Offset)
+	}
+}
+
+func TestParseBlackoutWindow(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected BlackoutWindow
+		err      bool
+	}{
+		{"Mon-Fri 09:00-18:00", BlackoutWindow{[]time.Weekday{1, 2, 3, 4, 5}, 9 * time.Hour, 18 * time.Hour}, false},
+		{"sat,sun 22:30-06:00", BlackoutWindow{[]time.Weekday{0, 6}, 22*time.Hour + 30*time.Minute, 6 * time.Hour}, false},
+		{"01:00-02:00", BlackoutWindow{nil, time.Hour, 2 * time.Hour}, false},
+		{"Mon-Fri", BlackoutWindow{}, true},
+		{"Mon-Fri 9-18", BlackoutWindow{}, true},
+		{"Xyz 09:00-18:00", BlackoutWindow{}, true},
+		{"Mon Tue 09:00-18:00", BlackoutWindow{}, true},
+	}
+	for _, c := range tests {
+		w, err := ParseBlackoutWindow(c.expr)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if len(w.Days) != len(c.expected.Days) || w.Start != c.expected.Start || w.End != c.expected.End {
+			t.Errorf("%s: expected %+v, got %+v", c.expr, c.expected, w)
+		}
+	}
+}
+
+func TestBlackout(t *testing.T) {
+	workHours, _ := ParseBlackoutWindow("Mon-Fri 09:00-18:00")
+	night, _ := ParseBlackoutWindow("22:00-02:00")
+	hourly, _ := ParseStandard("@hourly")
+	daily10, _ := ParseStandard("0 10 * * *")
+
+	tests := []struct {
+		time     string
+		schedule Schedule
+		expected string
+	}{
+		// Mon Jul 9 2012
+		{"Mon Jul 9 07:30 2012", Blackout(hourly, BlackoutSkip, workHours), "Mon Jul 9 08:00 2012"},
+		{"Mon Jul 9 08:00 2012", Blackout(hourly, BlackoutSkip, workHours), "Mon Jul 9 18:00 2012"},
+		{"Mon Jul 9 08:00 2012", Blackout(hourly, BlackoutDefer, workHours), "Mon Jul 9 18:00 2012"},
+		{"Mon Jul 9 10:00 2012", Blackout(daily10, BlackoutSkip, workHours), "Sat Jul 14 10:00 2012"},
+		{"Mon Jul 9 10:00 2012", Blackout(daily10, BlackoutDefer, workHours), "Tue Jul 10 18:00 2012"},
+		{"Sat Jul 14 08:00 2012", Blackout(hourly, BlackoutSkip, workHours), "Sat Jul 14 09:00 2012"},
+
+		// windows across midnight
+		{"Mon Jul 9 21:30 2012", Blackout(hourly, BlackoutSkip, night), "Tue Jul 10 02:00 2012"},
+		{"Tue Jul 10 00:30 2012", Blackout(hourly, BlackoutSkip, night), "Tue Jul 10 02:00 2012"},
+
+		// adjacent windows are deferred to the last end
+		{"Mon Jul 9 08:00 2012", Blackout(hourly, BlackoutDefer, workHours, BlackoutWindow{Start: 18 * time.Hour, End: 20 * time.Hour}), "Mon Jul 9 20:00 2012"},
+
+		// always blacked out
+		{"Mon Jul 9 08:00 2012", Blackout(hourly, BlackoutSkip, BlackoutWindow{}), ""},
+		{"Mon Jul 9 08:00 2012", Blackout(hourly, BlackoutDefer, BlackoutWindow{}), ""},
+
+		// DST 2am EST (-5) -> 3am EDT (-4): the window stays on the wall clock
+		{"TZ=America/New_York 2012-03-11T00:30:00-0500", Blackout(hourly, BlackoutSkip, BlackoutWindow{Start: 0, End: 4 * time.Hour}), "2012-03-11T04:00:00-0400"},
+	}
+
+	for _, c := range tests {
+		actual := c.schedule.Next(getTime(c.time))
+		expected := getTime(c.expected)
+		if !actual.Equal(expected) {
+			t.Errorf("%s: (expected) %v != %v (actual)", c.time, expected, actual)
+		}
+	}
+}
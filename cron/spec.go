@@ -11,6 +11,78 @@ type SpecSchedule struct {
 	Location *time.Location
 }
 
+// ExtendedSchedule is a SpecSchedule with a year field or L, W and #
+// expressions, returned by the parsers with the Year, YearOptional or
+// Extended options for the specs using them.
+type ExtendedSchedule struct {
+	SpecSchedule
+
+	// Year holds the years of the year field, nil for every year.
+	Year map[int]struct{}
+
+	// L, W and # expressions of the day fields, which depend on the month.
+	domRules, dowRules []dayRule
+}
+
+type dayRuleKind int
+
+const (
+	lastDay        dayRuleKind = iota // "L", "L-3": value days before the last day of the month
+	lastWeekday                       // "LW": last Monday to Friday of the month
+	nearestWeekday                    // "15W": Monday to Friday nearest to day value, within the month
+	lastDow                           // "5L": last day of week value of the month
+	nthDow                            // "5#2": nth day of week value of the month
+)
+
+// dayRule is a day of month or day of week expression of Extended parsers.
+type dayRule struct {
+	kind  dayRuleKind
+	value int
+	nth   int
+}
+
+// matches returns true if the day of t satisfies the rule.
+func (r dayRule) matches(t time.Time) bool {
+	day, last := t.Day(), daysIn(t.Year(), t.Month())
+	switch r.kind {
+	case lastDay:
+		return day == last-r.value
+	case lastWeekday:
+		return day == weekdayNear(t.Year(), t.Month(), last, t.Location())
+	case nearestWeekday:
+		return r.value <= last && day == weekdayNear(t.Year(), t.Month(), r.value, t.Location())
+	case lastDow:
+		return int(t.Weekday()) == r.value && day+7 > last
+	case nthDow:
+		return int(t.Weekday()) == r.value && (day-1)/7+1 == r.nth
+	}
+	return false
+}
+
+// daysIn returns the number of days of the month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 12, 0, 0, 0, time.UTC).Day()
+}
+
+// weekdayNear returns the Monday to Friday nearest to day without leaving
+// the month, i.e. a Saturday the 1st moves to Monday the 3rd.
+func weekdayNear(year int, month time.Month, day int, loc *time.Location) int {
+	// noon is never skipped by DST
+	switch time.Date(year, month, day, 12, 0, 0, 0, loc).Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return day + 2
+		}
+		return day - 1
+	case time.Sunday:
+		if day == daysIn(year, month) {
+			return day - 2
+		}
+		return day + 1
+	}
+	return day
+}
+
 // bounds provides a range of acceptable values (plus a map of name to value).
 type bounds struct {
 	min, max uint
@@ -19,6 +91,8 @@ type bounds struct {
 
 // The bounds for each field.
 var (
+	yearBounds = bounds{1970, 2099, nil}
+
 	seconds = bounds{0, 59, nil}
 	minutes = bounds{0, 59, nil}
 	hours   = bounds{0, 23, nil}
@@ -56,6 +130,22 @@ const (
 // Next returns the next time this schedule is activated, greater than the given
 // time.  If no time can be found to satisfy the schedule, return the zero time.
 func (s *SpecSchedule) Next(t time.Time) time.Time {
+	return s.next(t, nil)
+}
+
+// Next returns the next time this schedule is activated, greater than the
+// given time, or the zero time if none can be found.
+func (s *ExtendedSchedule) Next(t time.Time) time.Time {
+	return s.SpecSchedule.next(t, s)
+}
+
+// next returns the next activation of s, restricted by ext if not nil.
+func (s *SpecSchedule) next(t time.Time, ext *ExtendedSchedule) time.Time {
+	var year map[int]struct{}
+	if ext != nil {
+		year = ext.Year
+	}
+
 	// General approach
 	//
 	// For Month, Day, Hour, Minute, Second:
@@ -84,14 +174,30 @@ func (s *SpecSchedule) Next(t time.Time) time.Time {
 	// This flag indicates whether a field has been incremented.
 	added := false
 
-	// If no time is found within five years, return zero.
+	// If no time is found within five years, or after the last year of the
+	// year field, return zero.
 	yearLimit := t.Year() + 5
+	if year != nil {
+		yearLimit = 0
+		for y := range year {
+			if y > yearLimit {
+				yearLimit = y
+			}
+		}
+	}
 
 WRAP:
 	if t.Year() > yearLimit {
 		return time.Time{}
 	}
 
+	// Find the first applicable year.
+	if _, ok := year[t.Year()]; year != nil && !ok {
+		added = true
+		t = time.Date(t.Year()+1, time.January, 1, 0, 0, 0, 0, loc)
+		goto WRAP
+	}
+
 	// Find the first applicable month.
 	// If it's this month, then do nothing.
 	for 1<<uint(t.Month())&s.Month == 0 {
@@ -114,7 +220,7 @@ WRAP:
 	// NOTE: This causes issues for daylight savings regimes where midnight does
 	// not exist.  For example: Sao Paulo has DST that transforms midnight on
 	// 11/3 into 1am. Handle that by noticing when the Hour ends up != 0.
-	for !dayMatches(s, t) {
+	for !dayMatches(s, ext, t) {
 		if !added {
 			added = true
 			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
@@ -175,14 +281,28 @@ WRAP:
 }
 
 // dayMatches returns true if the schedule's day-of-week and day-of-month
-// restrictions are satisfied by the given time.
-func dayMatches(s *SpecSchedule, t time.Time) bool {
+// restrictions are satisfied by the given time, with the rules of ext if not
+// nil.
+func dayMatches(s *SpecSchedule, ext *ExtendedSchedule, t time.Time) bool {
 	var (
 		domMatch bool = 1<<uint(t.Day())&s.Dom > 0
 		dowMatch bool = 1<<uint(t.Weekday())&s.Dow > 0
 	)
+	if ext != nil {
+		domMatch = domMatch || rulesMatch(ext.domRules, t)
+		dowMatch = dowMatch || rulesMatch(ext.dowRules, t)
+	}
 	if s.Dom&starBit > 0 || s.Dow&starBit > 0 {
 		return domMatch && dowMatch
 	}
 	return domMatch || dowMatch
 }
+
+func rulesMatch(rules []dayRule, t time.Time) bool {
+	for _, r := range rules {
+		if r.matches(t) {
+			return true
+		}
+	}
+	return false
+}
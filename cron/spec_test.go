@@ -199,6 +199,70 @@ func TestNext(t *testing.T) {
 	}
 }
 
+func TestNextExtended(t *testing.T) {
+	runs := []struct {
+		time, spec string
+		expected   string
+	}{
+		// Last day of the month
+		{"Mon Jul 9 23:35 2012", "0 0 0 L * ?", "Tue Jul 31 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 L Feb ?", "Thu Feb 28 00:00 2013"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 L-2 * ?", "Sun Jul 29 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 1,L * ?", "Tue Jul 31 00:00 2012"},
+
+		// Weekdays: Sep 1 2012 is a Saturday, Sep 15 a Saturday, Sep 30 a Sunday
+		{"Mon Sep 3 00:00 2012", "0 0 0 LW * ?", "Fri Sep 28 00:00 2012"},
+		{"Mon Sep 3 00:00 2012", "0 0 0 15W * ?", "Fri Sep 14 00:00 2012"},
+		{"Mon Sep 3 00:00 2012", "0 0 0 30W * ?", "Fri Sep 28 00:00 2012"},
+		{"Fri Aug 31 12:00 2012", "0 0 0 1W * ?", "Mon Sep 3 00:00 2012"},
+		{"Mon Sep 3 00:00 2012", "0 0 0 31W * ?", "Wed Oct 31 00:00 2012"}, // skips 30 day months
+
+		// Last and nth day of week
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * FRIL", "Fri Jul 27 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * 5L", "Fri Jul 27 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * FRI#2", "Fri Jul 13 00:00 2012"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * MON#5", "Mon Jul 30 00:00 2012"},
+		{"Tue Jul 31 00:00 2012", "0 0 0 ? * MON#5", "Mon Oct 29 00:00 2012"},
+
+		// Both day fields restricted: either matches
+		{"Mon Jul 9 23:35 2012", "0 0 0 L * FRI#2", "Fri Jul 13 00:00 2012"},
+
+		// Year
+		{"Mon Jul 9 23:35 2012", "0 0 0 1 1 ? 2015", "Thu Jan 1 00:00 2015"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 1 1 ? 2020", "Wed Jan 1 00:00 2020"},
+		{"Wed Jan 2 00:00 2013", "0 0 0 1 1 ? 2013/5", "Mon Jan 1 00:00 2018"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 ? * FRIL 2014", "Fri Jan 31 00:00 2014"},
+		{"Mon Jul 9 23:35 2012", "0 0 0 1 1 ? 2010", ""},
+
+		// DST: 2am EST (-5) -> 3am EDT (-4) on the second Sunday of March
+		{"2012-03-01T00:00:00-0500", "TZ=America/New_York 0 0 1 ? 3 SUN#2", "2012-03-11T01:00:00-0500"},
+		{"2012-03-01T00:00:00-0500", "TZ=America/New_York 0 0 3 ? 3 SUN#2", "2012-03-11T03:00:00-0400"},
+		// 2:30 never exists on that day
+		{"2012-03-01T00:00:00-0500", "TZ=America/New_York 0 30 2 ? 3 SUN#2", ""},
+
+		// DST: 2am EDT (-4) => 1am EST (-5) on the first Sunday of November
+		{"2012-11-01T00:00:00-0400", "TZ=America/New_York 0 30 1 ? 11 SUN#1", "2012-11-04T01:30:00-0400"},
+		{"2012-10-01T00:00:00-0400", "TZ=America/New_York 0 0 3 ? 11 SUNL", "2012-11-25T03:00:00-0500"},
+
+		// Midnight does not exist on Sunday Nov 4 2018 in Sao Paulo
+		{"2018-10-17T05:00:00-0400", "TZ=America/Sao_Paulo 0 0 9 ? 11 SUN#1", "2018-11-04T06:00:00-0500"},
+		{"2018-10-17T05:00:00-0400", "TZ=America/Sao_Paulo 0 0 9 4W 11 ?", "2018-11-05T06:00:00-0500"},
+	}
+
+	for _, c := range runs {
+		sched, err := extendedParser.Parse(c.spec)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		actual := sched.Next(getTime(c.time))
+		expected := getTime(c.expected)
+		if !actual.Equal(expected) {
+			t.Errorf("%s, \"%s\": (expected) %v != %v (actual)", c.time, c.spec, expected, actual)
+		}
+	}
+}
+
 func TestErrors(t *testing.T) {
 	invalidSpecs := []string{
 		"xyz",
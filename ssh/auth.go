@@ -0,0 +1,227 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// The auth methods of ClientConfig.AuthOrder.
+const (
+	AuthPublicKey           = "publickey"
+	AuthPassword            = "password"
+	AuthKeyboardInteractive = "keyboard-interactive"
+)
+
+var (
+	ErrNotCertificate = errors.New("ssh: not a certificate")
+
+	defaultAuthOrder = []string{AuthPublicKey, AuthPassword, AuthKeyboardInteractive}
+)
+
+// AuthError is returned by Connect when the server refused the credentials.
+type AuthError struct {
+	User, Host string
+	// Tried are the methods tried, in order, the server may not allow all
+	// the configured ones.
+	Tried []string
+	// Problems are the configured credentials that could not be used, e.g.
+	// an unreadable private key.
+	Problems []string
+	Err      error
+}
+
+func (e *AuthError) Error() string {
+	s := fmt.Sprintf("ssh: auth failed for %s@%s, tried [%s]", e.User, e.Host, strings.Join(e.Tried, " "))
+	if len(e.Problems) > 0 {
+		s += ", " + strings.Join(e.Problems, ", ")
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// authTracker records the methods tried during a handshake.
+type authTracker struct {
+	mu       sync.Mutex
+	tried    []string
+	problems []string
+}
+
+func (t *authTracker) try(method string) {
+	t.mu.Lock()
+	t.tried = append(t.tried, method)
+	t.mu.Unlock()
+}
+
+// error returns the AuthError of the failed handshake of c, or err if the
+// failure is not about auth.
+func (t *authTracker) error(c *Client, err error) error {
+	// the error of x/crypto is not typed
+	if !strings.Contains(err.Error(), "unable to authenticate") {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return &AuthError{
+		User:     c.Conf.User,
+		Host:     c.Conf.Host,
+		Tried:    append([]string(nil), t.tried...),
+		Problems: t.problems,
+		Err:      err,
+	}
+}
+
+// authMethods returns the auth methods of the config of c, in AuthOrder.
+func (c *Client) authMethods() ([]ssh.AuthMethod, *authTracker, error) {
+	t := &authTracker{}
+	order := c.Conf.AuthOrder
+	if len(order) == 0 {
+		order = defaultAuthOrder
+	}
+
+	var methods []ssh.AuthMethod
+	for _, name := range order {
+		switch name {
+		case AuthPublicKey:
+			signers := c.signers(t)
+			if len(signers) > 0 {
+				methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+					t.try(AuthPublicKey)
+					return signers, nil
+				}))
+			}
+		case AuthPassword:
+			if c.Conf.Password != "" {
+				methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+					t.try(AuthPassword)
+					return c.Conf.Password, nil
+				}))
+			}
+		case AuthKeyboardInteractive:
+			if c.Conf.KeyboardInteractive != nil {
+				methods = append(methods, ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+					t.try(AuthKeyboardInteractive)
+					return c.Conf.KeyboardInteractive(name, instruction, questions, echos)
+				}))
+			}
+		default:
+			return nil, nil, fmt.Errorf("ssh: unknown auth method %q", name)
+		}
+	}
+
+	if len(t.problems) > 0 {
+		log.Glog.Warn("ssh auth", zap.String("host", c.Conf.Host), zap.Strings("problems", t.problems))
+	}
+	if len(methods) == 0 {
+		if len(t.problems) > 0 {
+			return nil, nil, fmt.Errorf("%w: %s", ErrNoAuth, strings.Join(t.problems, ", "))
+		}
+		return nil, nil, ErrNoAuth
+	}
+	return methods, t, nil
+}
+
+// closeAgent closes the connection to the agent, if any.
+func (c *Client) closeAgent() {
+	if c.agent != nil {
+		c.agent.Close()
+		c.agent = nil
+	}
+}
+
+// signers returns the keys of the agent and of the config, a key with a
+// certificate is offered with it first, like OpenSSH.
+func (c *Client) signers(t *authTracker) []ssh.Signer {
+	var signers []ssh.Signer
+	if !c.Conf.DisableAgent && os.Getenv("SSH_AUTH_SOCK") != "" {
+		// the connection of a previous Connect
+		c.closeAgent()
+		var err error
+		if c.agent, err = net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
+			if keys, err := agent.NewClient(c.agent).Signers(); err == nil {
+				signers = append(signers, keys...)
+			}
+		}
+	}
+
+	var key ssh.Signer
+	var err error
+	switch {
+	case len(c.Conf.PrivateKeyPEM) != 0:
+		if key, err = ParsePrivateKey(c.Conf.PrivateKeyPEM, c.Conf.Passphrase); err != nil {
+			t.problems = append(t.problems, "private key: "+err.Error())
+		}
+	case c.Conf.PrivateKey != "":
+		if key, err = ReadPrivateKey(c.Conf.PrivateKey, c.Conf.Passphrase); err != nil {
+			t.problems = append(t.problems, "private key "+c.Conf.PrivateKey+": "+err.Error())
+		}
+	}
+	if key == nil {
+		return signers
+	}
+
+	if cert, err := c.certificate(); err != nil {
+		t.problems = append(t.problems, "certificate: "+err.Error())
+	} else if cert != nil {
+		if certSigner, err := ssh.NewCertSigner(cert, key); err == nil {
+			signers = append(signers, certSigner)
+		} else {
+			t.problems = append(t.problems, "certificate: "+err.Error())
+		}
+	}
+
+	return append(signers, key)
+}
+
+// certificate returns the certificate of the config, if any.
+func (c *Client) certificate() (*ssh.Certificate, error) {
+	data := c.Conf.CertificateData
+	if len(data) == 0 && c.Conf.Certificate != "" {
+		var err error
+		if data, err = os.ReadFile(c.Conf.Certificate); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	return ParseCertificate(data)
+}
+
+// ParsePrivateKey parses a PEM private key, decrypted with passphrase if
+// not empty.
+func ParsePrivateKey(pem []byte, passphrase string) (ssh.Signer, error) {
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+	}
+
+	return ssh.ParsePrivateKey(pem)
+}
+
+// ParseCertificate parses an OpenSSH certificate in the authorized_keys
+// format, e.g. the content of id_ed25519-cert.pub.
+func ParseCertificate(data []byte) (*ssh.Certificate, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, ErrNotCertificate
+	}
+	return cert, nil
+}
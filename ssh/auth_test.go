@@ -0,0 +1,249 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newTestKey returns a new ed25519 key and its PKCS8 PEM.
+func newTestKey(t *testing.T) (ssh.Signer, []byte) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestAuthCertificate(t *testing.T) {
+	ca, _ := newTestKey(t)
+	key, keyPEM := newTestKey(t)
+
+	cert := &ssh.Certificate{
+		Key:             key.PublicKey(),
+		Serial:          1,
+		CertType:        ssh.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: []string{testUser},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+	}
+	s := newTestServer(t, &ssh.ServerConfig{PublicKeyCallback: checker.Authenticate})
+
+	conf := s.clientConfig()
+	conf.Password = ""
+	conf.PrivateKeyPEM = keyPEM
+	conf.CertificateData = ssh.MarshalAuthorizedKey(cert)
+	c, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if r, err := c.Execute("echo cert"); err != nil || r.Stdout() != "cert" {
+		t.Errorf("unexpected result %v: %v", r, err)
+	}
+
+	// the key alone is not accepted
+	conf = s.clientConfig()
+	conf.Password = ""
+	conf.PrivateKeyPEM = keyPEM
+	_, err = NewClient(conf)
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected an AuthError, got %v", err)
+	}
+	if !reflect.DeepEqual(authErr.Tried, []string{AuthPublicKey}) {
+		t.Errorf("unexpected methods tried %v", authErr.Tried)
+	}
+}
+
+func TestParseCertificate(t *testing.T) {
+	key, _ := newTestKey(t)
+	if _, err := ParseCertificate(ssh.MarshalAuthorizedKey(key.PublicKey())); !errors.Is(err, ErrNotCertificate) {
+		t.Errorf("expected ErrNotCertificate, got %v", err)
+	}
+}
+
+func TestAuthKeyboardInteractive(t *testing.T) {
+	s := newTestServer(t, &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"OTP: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 || answers[0] != "123456" {
+				return nil, errors.New("bad OTP")
+			}
+			return nil, nil
+		},
+	})
+
+	conf := s.clientConfig()
+	conf.Password = ""
+	conf.KeyboardInteractive = func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(questions) != 1 || questions[0] != "OTP: " {
+			t.Errorf("unexpected questions %q", questions)
+		}
+		return []string{"123456"}, nil
+	}
+	c, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestAuthOrder(t *testing.T) {
+	var mu sync.Mutex
+	var tried []string
+	record := func(method string) {
+		mu.Lock()
+		tried = append(tried, method)
+		mu.Unlock()
+	}
+
+	config := testServerConfig()
+	password := config.PasswordCallback
+	config.PasswordCallback = func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+		record(AuthPassword)
+		return password(c, pass)
+	}
+	config.KeyboardInteractiveCallback = func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		record(AuthKeyboardInteractive)
+		return nil, errors.New("refused")
+	}
+	s := newTestServer(t, config)
+
+	conf := s.clientConfig()
+	conf.KeyboardInteractive = func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		return make([]string, len(questions)), nil
+	}
+	conf.AuthOrder = []string{AuthKeyboardInteractive, AuthPassword}
+	c, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	if want := []string{AuthKeyboardInteractive, AuthPassword}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("expected %v, got %v", want, tried)
+	}
+
+	conf = s.clientConfig()
+	conf.AuthOrder = []string{"gssapi"}
+	if _, err = NewClient(conf); err == nil || !strings.Contains(err.Error(), "gssapi") {
+		t.Errorf("expected an unknown method error, got %v", err)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	conf := s.clientConfig()
+	conf.Password = "wrong"
+	_, err := NewClient(conf)
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected an AuthError, got %v", err)
+	}
+	if !reflect.DeepEqual(authErr.Tried, []string{AuthPassword}) {
+		t.Errorf("unexpected methods tried %v", authErr.Tried)
+	}
+	if !strings.Contains(err.Error(), "tried [password]") {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	// a bad key is reported, with the methods left
+	conf = s.clientConfig()
+	conf.Password = "wrong"
+	conf.PrivateKeyPEM = []byte("not a key")
+	_, err = NewClient(conf)
+	if !errors.As(err, &authErr) || len(authErr.Problems) != 1 || !strings.Contains(authErr.Problems[0], "private key") {
+		t.Errorf("expected the key problem, got %v", err)
+	}
+
+	conf = s.clientConfig()
+	conf.Password = ""
+	conf.PrivateKeyPEM = []byte("not a key")
+	if _, err = NewClient(conf); !errors.Is(err, ErrNoAuth) || !strings.Contains(err.Error(), "private key") {
+		t.Errorf("expected ErrNoAuth with the key problem, got %v", err)
+	}
+}
+
+func TestAuthAgentClosed(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var open int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&open, 1)
+			go func() {
+				agent.ServeAgent(agent.NewKeyring(), conn)
+				atomic.AddInt32(&open, -1)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	s := newTestServer(t, nil)
+	conf := s.clientConfig()
+	conf.DisableAgent = false
+	conf.Password = "wrong"
+	if _, err = NewClient(conf); err == nil {
+		t.Fatal("expected an auth error")
+	}
+
+	conf = s.clientConfig()
+	conf.DisableAgent = false
+	c, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the agent connections of the failed and replaced connections to be closed", func() bool { return atomic.LoadInt32(&open) == 1 })
+
+	c.Close()
+	waitFor(t, "the agent connection to be closed", func() bool { return atomic.LoadInt32(&open) == 0 })
+}
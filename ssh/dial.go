@@ -0,0 +1,183 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+var (
+	ErrUnsupportedProxy = errors.New("ssh: unsupported proxy scheme")
+)
+
+// Dialer makes network connections. A Client is one, connecting from its
+// server, so that other packages, e.g. libvirt with qemu+ssh, can tunnel
+// through it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// DialContext connects to addr from the server, like ssh -W.
+func (c *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		// ssh.Client.Dial has no context
+		conn, err := c.Conn.Dial(network, addr)
+		ch <- result{conn, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// dialer returns the Dialer connecting to the host of c: its last jump host,
+// connected first, or its proxy.
+func (c *Client) dialer(ctx context.Context) (Dialer, error) {
+	if n := len(c.Conf.Jump); n > 0 {
+		hop := *c.Conf.Jump[n-1]
+		hop.Jump, hop.Proxy = c.Conf.Jump[:n-1], c.Conf.Proxy
+
+		jump, err := NewClientContext(ctx, &hop)
+		if err != nil {
+			return nil, fmt.Errorf("ssh: jump host %s: %w", PoolKey(&hop), err)
+		}
+		c.jump = jump
+		return jump, nil
+	}
+
+	forward := &net.Dialer{Timeout: c.Conf.Timeout}
+	if c.Conf.Proxy == "" {
+		return forward, nil
+	}
+	return ProxyDialer(c.Conf.Proxy, forward)
+}
+
+func (c *Client) closeJump() {
+	if c.jump != nil {
+		c.jump.Close()
+		c.jump = nil
+	}
+}
+
+// ProxyDialer returns a Dialer connecting through the proxy at rawURL, with
+// the scheme socks5, socks5h or http, and connecting to it with forward.
+func ProxyDialer(rawURL string, forward Dialer) (Dialer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			auth = &proxy.Auth{User: u.User.Username()}
+			auth.Password, _ = u.User.Password()
+		}
+		d, err := proxy.SOCKS5("tcp", hostPort(u, "1080"), auth, forwardDialer{forward})
+		if err != nil {
+			return nil, err
+		}
+		return d.(proxy.ContextDialer), nil
+	case "http":
+		return &httpProxy{addr: hostPort(u, "80"), user: u.User, forward: forward}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedProxy, u.Scheme)
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// forwardDialer is a Dialer as a proxy.Dialer.
+type forwardDialer struct {
+	Dialer
+}
+
+func (d forwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// httpProxy connects with the CONNECT method of an HTTP proxy.
+type httpProxy struct {
+	addr    string
+	user    *url.Userinfo
+	forward Dialer
+}
+
+func (p *httpProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := p.forward.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if p.user != nil {
+		password, _ := p.user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(p.user.Username()+":"+password)))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("ssh: proxy %s: %s", p.addr, resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose first bytes were read in r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,266 @@
+package ssh
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newSOCKS5Proxy starts a SOCKS5 proxy requiring user and password, and
+// returns its address and the number of connections it made.
+func newSOCKS5Proxy(t *testing.T, user, password string) (string, *int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var count int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if dst := socks5Handshake(conn, user, password); dst != nil {
+					atomic.AddInt32(&count, 1)
+					pipe(conn, dst)
+					return
+				}
+				conn.Close()
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), &count
+}
+
+// socks5Handshake serves the handshake of RFC 1928 with the username and
+// password auth of RFC 1929, and returns the connection to the requested
+// address.
+func socks5Handshake(conn net.Conn, user, password string) net.Conn {
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		return b
+	}
+
+	// version and methods
+	hdr := read(2)
+	if hdr == nil || read(int(hdr[1])) == nil {
+		return nil
+	}
+	conn.Write([]byte{5, 2})
+
+	// auth
+	hdr = read(2)
+	if hdr == nil {
+		return nil
+	}
+	u := read(int(hdr[1]))
+	l := read(1)
+	if u == nil || l == nil {
+		return nil
+	}
+	p := read(int(l[0]))
+	if string(u) != user || string(p) != password {
+		conn.Write([]byte{1, 1})
+		return nil
+	}
+	conn.Write([]byte{1, 0})
+
+	// connect request
+	hdr = read(4)
+	if hdr == nil || hdr[1] != 1 {
+		return nil
+	}
+	var host string
+	switch hdr[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		host = string(read(int(read(1)[0])))
+	case 4:
+		host = net.IP(read(16)).String()
+	}
+	port := read(2)
+	if port == nil {
+		return nil
+	}
+
+	dst, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return dst
+}
+
+// newHTTPProxy starts an HTTP proxy supporting CONNECT, requiring auth if not
+// empty, and returns its address and the number of connections it made.
+func newHTTPProxy(t *testing.T, auth string) (string, *int32) {
+	var count int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if auth != "" && r.Header.Get("Proxy-Authorization") != auth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		dst, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			dst.Close()
+			return
+		}
+		atomic.AddInt32(&count, 1)
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipe(conn, dst)
+	}))
+	t.Cleanup(s.Close)
+
+	return s.Listener.Addr().String(), &count
+}
+
+func TestJump(t *testing.T) {
+	// each hop has its own password
+	config := testServerConfig()
+	config.PasswordCallback = func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if c.User() == "bastion" && string(password) == "hop" {
+			return nil, nil
+		}
+		return nil, ssh.ErrNoAuth
+	}
+	hop1 := newTestServer(t, config)
+	hop2 := newTestServer(t, nil)
+	target := newTestServer(t, nil)
+
+	conf := target.clientConfig()
+	hop1Conf := hop1.clientConfig()
+	hop1Conf.User, hop1Conf.Password = "bastion", "hop"
+	conf.Jump = []*ClientConfig{hop1Conf, hop2.clientConfig()}
+
+	c, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Execute("echo target")
+	if err != nil || r.Stdout() != "target" {
+		t.Fatalf("unexpected result %v %v", r, err)
+	}
+	if hop1.dials() != 1 || hop2.dials() != 1 || target.dials() != 1 {
+		t.Errorf("unexpected dials %d %d %d", hop1.dials(), hop2.dials(), target.dials())
+	}
+
+	jump := c.jump
+	c.Close()
+	if _, err = jump.Execute("true"); err == nil {
+		t.Error("expected the jump hosts to be closed")
+	}
+
+	hop1Conf.Password = "wrong"
+	if _, err = NewClient(conf); err == nil || !strings.Contains(err.Error(), "jump host bastion@"+hop1.Addr) {
+		t.Errorf("expected the error of the first hop, got %v", err)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	s := newTestServer(t, nil)
+	socksAddr, socksCount := newSOCKS5Proxy(t, "u", "p")
+	httpAddr, httpCount := newHTTPProxy(t, "Basic dTpw")
+
+	for _, proxy := range []string{"socks5://u:p@" + socksAddr, "http://u:p@" + httpAddr} {
+		conf := s.clientConfig()
+		conf.Proxy = proxy
+		c, err := NewClient(conf)
+		if err != nil {
+			t.Fatalf("%s: %v", proxy, err)
+		}
+		if r, err := c.Execute("echo proxied"); err != nil || r.Stdout() != "proxied" {
+			t.Errorf("%s: unexpected result %v %v", proxy, r, err)
+		}
+		c.Close()
+	}
+	if atomic.LoadInt32(socksCount) != 1 || atomic.LoadInt32(httpCount) != 1 {
+		t.Errorf("expected a connection per proxy, got %d %d", *socksCount, *httpCount)
+	}
+
+	// the proxy connects to the jump host
+	conf := s.clientConfig()
+	conf.Jump = []*ClientConfig{newTestServer(t, nil).clientConfig()}
+	conf.Proxy = "socks5://u:p@" + socksAddr
+	c, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if atomic.LoadInt32(socksCount) != 2 {
+		t.Errorf("expected the jump host to be reached through the proxy")
+	}
+
+	for proxy, want := range map[string]string{
+		"socks5://u:wrong@" + socksAddr: "",
+		"http://" + httpAddr:            "407",
+		"ftp://" + httpAddr:             ErrUnsupportedProxy.Error(),
+	} {
+		conf.Jump = nil
+		conf.Proxy = proxy
+		if _, err = NewClient(conf); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: unexpected error %v", proxy, err)
+		}
+	}
+}
+
+func TestClientDialContext(t *testing.T) {
+	c := newTestClient(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			io.WriteString(conn, "hello")
+			conn.Close()
+		}
+	}()
+
+	var d Dialer = c
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if b, _ := io.ReadAll(conn); string(b) != "hello" {
+		t.Errorf("unexpected data %q", b)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = d.DialContext(ctx, "tcp", ln.Addr().String()); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a canceled error, got %v", err)
+	}
+}
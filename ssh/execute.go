@@ -0,0 +1,181 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/meilihao/golib/v2/cmd"
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxLineSize is the longest line passed to the callbacks of a
+// StreamOption, the rest of the stream is dropped.
+const maxLineSize = 1 << 20
+
+// StreamOption configures ExecuteStream.
+type StreamOption struct {
+	// OnStdout and OnStderr are called with each line of the output, without
+	// its end of line. They are called from different goroutines.
+	OnStdout, OnStderr func(line string)
+	// Stdin is copied to the standard input of the command, which is closed
+	// once Stdin is read. The copy is not waited for at the end of the
+	// command.
+	Stdin     io.Reader
+	IgnoreErr bool
+}
+
+// ExecuteContext is Execute, the command is sent SIGKILL and its session
+// closed when ctx is done or after Conf.ExecTimeout.
+func (c *Client) ExecuteContext(ctx context.Context, s string, ignoreErr ...bool) (*Result, error) {
+	r := newResult()
+	err := c.execute(ctx, s, r, r.StdoutBuffer, r.StderrBuffer, nil, len(ignoreErr) > 0)
+
+	return r, err
+}
+
+// ExecuteStream runs s like ExecuteContext, but passes its output to the
+// callbacks of opt line by line as it is written instead of buffering it,
+// e.g. to tail a log.
+func (c *Client) ExecuteStream(ctx context.Context, s string, opt *StreamOption) (*Result, error) {
+	if opt == nil {
+		opt = &StreamOption{}
+	}
+	r := newResult()
+
+	var wg sync.WaitGroup
+	stdout, stderr := lineWriter(&wg, opt.OnStdout), lineWriter(&wg, opt.OnStderr)
+	err := c.execute(ctx, s, r, stdout, stderr, opt.Stdin, opt.IgnoreErr)
+	stdout.Close()
+	stderr.Close()
+	wg.Wait()
+
+	return r, err
+}
+
+func newResult() *Result {
+	return &Result{
+		StdoutBuffer: bytes.NewBuffer(nil),
+		StderrBuffer: bytes.NewBuffer(nil),
+	}
+}
+
+// lineWriter returns a writer calling fn with each line written to it until
+// closed.
+func lineWriter(wg *sync.WaitGroup, fn func(string)) io.WriteCloser {
+	pr, pw := io.Pipe()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		s := bufio.NewScanner(pr)
+		s.Buffer(nil, maxLineSize)
+		for s.Scan() {
+			if fn != nil {
+				fn(s.Text())
+			}
+		}
+		if s.Err() != nil {
+			// don't block the session on a too long line
+			io.Copy(io.Discard, pr)
+		}
+	}()
+
+	return pw
+}
+
+func (c *Client) execute(ctx context.Context, s string, r *Result, stdout, stderr io.Writer, stdin io.Reader, ignoreErr bool) error {
+	if c.Conf.ExecTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Conf.ExecTimeout)
+		defer cancel()
+	}
+	display := cmd.Redact(s)
+	started := time.Now()
+
+	ses, err := c.Conn.NewSession()
+	if err != nil {
+		r.Error = &sessionError{err}
+		return r.Error
+	}
+	defer ses.Close()
+
+	if c.Conf.UsePty {
+		tmodes := ssh.TerminalModes{
+			ssh.ECHO:          0,     // disable echoing
+			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+		}
+
+		if r.Error = ses.RequestPty("xterm", 80, 40, tmodes); r.Error != nil {
+			return r.Error
+		}
+	}
+
+	ses.Stdout = stdout
+	ses.Stderr = stderr
+	if stdin != nil {
+		// not ses.Stdin, the end of the command would wait for the one of stdin
+		w, err := ses.StdinPipe()
+		if err != nil {
+			r.Error = err
+			return r.Error
+		}
+		go func() {
+			io.Copy(w, stdin)
+			w.Close()
+		}()
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ses.Signal(ssh.SIGKILL)
+			ses.Close()
+		case <-stop:
+		}
+	}()
+
+	r.Error = ses.Run(s)
+	r.Duration = time.Since(started)
+
+	var exitError *ssh.ExitError
+	switch {
+	case ctx.Err() != nil:
+		r.ExitStatus = -1
+		r.Error = fmt.Errorf("ssh: %s: %w", display, ctx.Err())
+		log.Glog.Error("ssh exec", zap.String("host", c.Conf.Host), zap.String("cmd", display), zap.Duration("time", r.Duration), zap.Error(r.Error))
+		return r.Error
+	case errors.As(r.Error, &exitError):
+		r.ExitStatus = exitError.ExitStatus()
+		r.Error = nil
+	case r.Error != nil:
+		return r.Error
+	}
+
+	if !r.IsSuccess() {
+		// if r.StderrBuffer.Len() > 0 {
+		// 	r.Error = errors.New(r.StdoutBuffer.String())
+		// }
+
+		if ignoreErr {
+			log.Glog.Warn("ssh exec", zap.String("cmd", display), zap.Duration("time", r.Duration), zap.Int("code", r.ExitStatus), zap.String("output", cmd.Truncate(cmd.Redact(r.Stderr()))))
+		} else {
+			log.Glog.Error("ssh exec", zap.String("cmd", display), zap.Duration("time", r.Duration), zap.Int("code", r.ExitStatus), zap.String("output", cmd.Truncate(cmd.Redact(r.Stderr()))))
+		}
+	} else {
+		log.Glog.Debug("ssh exec", zap.String("cmd", display), zap.Duration("time", r.Duration))
+	}
+
+	return nil
+}
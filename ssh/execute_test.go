@@ -0,0 +1,137 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestExecuteContext(t *testing.T) {
+	c := newTestClient(t)
+
+	r, err := c.ExecuteContext(context.Background(), "echo out; echo err >&2; exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Stdout() != "out" || r.Stderr() != "err" || r.ExitStatus != 3 {
+		t.Errorf("unexpected result %v", r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	r, err = c.ExecuteContext(ctx, "sleep 5")
+	if !errors.Is(err, context.DeadlineExceeded) || r.ExitStatus != -1 {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+	if time.Since(started) > 2*time.Second {
+		t.Errorf("the command was not killed")
+	}
+
+	// the connection is still usable
+	if r, err = c.Execute("echo again"); err != nil || r.Stdout() != "again" {
+		t.Errorf("unexpected result %v %v", r, err)
+	}
+}
+
+func TestExecuteRedacted(t *testing.T) {
+	c := newTestClient(t)
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	old := log.Glog
+	log.Glog = zap.New(core)
+	defer func() { log.Glog = old }()
+
+	if _, err := c.Execute("echo 'login failed: password=hunter2' >&2; exit 1", true); err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.AllUntimed()
+	if len(entries) != 1 || entries[0].ContextMap()["output"] != "login failed: password=***" {
+		t.Errorf("expected the redacted output to be logged, got %v", entries)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	s := newTestServer(t, nil)
+	conf := s.clientConfig()
+	conf.ExecTimeout = 100 * time.Millisecond
+	c, err := NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err = c.Execute("sleep 5"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+}
+
+func TestExecuteStream(t *testing.T) {
+	c := newTestClient(t)
+
+	var mu sync.Mutex
+	var stdout, stderr []string
+	r, err := c.ExecuteStream(context.Background(), "while read l; do echo \"got $l\"; done; echo done >&2; exit 2", &StreamOption{
+		OnStdout: func(line string) {
+			mu.Lock()
+			stdout = append(stdout, line)
+			mu.Unlock()
+		},
+		OnStderr: func(line string) {
+			mu.Lock()
+			stderr = append(stderr, line)
+			mu.Unlock()
+		},
+		Stdin:     strings.NewReader("a\nb\n"),
+		IgnoreErr: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ExitStatus != 2 {
+		t.Errorf("expected exit status 2, got %d", r.ExitStatus)
+	}
+	if strings.Join(stdout, ",") != "got a,got b" || strings.Join(stderr, ",") != "done" {
+		t.Errorf("unexpected lines %q %q", stdout, stderr)
+	}
+}
+
+func TestExecuteStreamCancel(t *testing.T) {
+	c := newTestClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := make(chan string, 10)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.ExecuteStream(ctx, "while true; do echo tick; sleep 0.02; done", &StreamOption{
+			OnStdout: func(line string) { lines <- line },
+		})
+		errc <- err
+	}()
+
+	if l := <-lines; l != "tick" {
+		t.Errorf("unexpected line %q", l)
+	}
+	cancel()
+	go func() {
+		for range lines {
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected a canceled error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command was not stopped")
+	}
+}
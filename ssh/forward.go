@@ -0,0 +1,341 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrForwardClosed = errors.New("ssh: forward closed")
+)
+
+// Forward is a port forwarding of a Client, listening until closed by Close
+// or with the Client.
+type Forward struct {
+	c    *Client
+	kind string
+	ln   net.Listener
+	// dial connects the accepted conn to its destination
+	dial func(conn net.Conn) (net.Conn, error)
+	to   string
+	wg   sync.WaitGroup
+
+	active, total int64
+
+	mu     sync.Mutex
+	conns  map[io.Closer]bool
+	closed bool
+}
+
+// ForwardLocal forwards the connections to localAddr to remoteAddr, reached
+// from the server, like ssh -L. localAddr may have port 0, see Addr.
+func (c *Client) ForwardLocal(localAddr, remoteAddr string) (*Forward, error) {
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.forward("local", ln, remoteAddr, func(net.Conn) (net.Conn, error) {
+		return c.Conn.Dial("tcp", remoteAddr)
+	})
+}
+
+// ForwardRemote forwards the connections to remoteAddr, on the server, to
+// localAddr, like ssh -R. remoteAddr may have port 0, see Addr.
+func (c *Client) ForwardRemote(remoteAddr, localAddr string) (*Forward, error) {
+	ln, err := c.Conn.Listen("tcp", remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.forward("remote", ln, localAddr, func(net.Conn) (net.Conn, error) {
+		return net.DialTimeout("tcp", localAddr, c.Conf.Timeout)
+	})
+}
+
+// ForwardDynamic serves a SOCKS5 proxy, without auth, on localAddr,
+// connecting from the server, like ssh -D.
+func (c *Client) ForwardDynamic(localAddr string) (*Forward, error) {
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.forward("dynamic", ln, "socks5", func(conn net.Conn) (net.Conn, error) {
+		return socks5Connect(conn, func(addr string) (net.Conn, error) {
+			return c.Conn.Dial("tcp", addr)
+		})
+	})
+}
+
+func (c *Client) forward(kind string, ln net.Listener, to string, dial func(net.Conn) (net.Conn, error)) (*Forward, error) {
+	f := &Forward{
+		c:     c,
+		kind:  kind,
+		ln:    ln,
+		dial:  dial,
+		to:    to,
+		conns: map[io.Closer]bool{},
+	}
+
+	c.fwdMu.Lock()
+	if c.forwards == nil {
+		c.forwards = map[*Forward]bool{}
+	}
+	c.forwards[f] = true
+	c.fwdMu.Unlock()
+
+	f.wg.Add(1)
+	go f.serve()
+	log.Glog.Debug("ssh forward", zap.String("host", c.Conf.Host), zap.String("forward", f.String()))
+
+	return f, nil
+}
+
+// Addr returns the address listened on, locally or on the server for a
+// remote forwarding.
+func (f *Forward) Addr() net.Addr {
+	return f.ln.Addr()
+}
+
+func (f *Forward) String() string {
+	return fmt.Sprintf("%s %s -> %s", f.kind, f.ln.Addr(), f.to)
+}
+
+// Active returns the number of connections being forwarded.
+func (f *Forward) Active() int {
+	return int(atomic.LoadInt64(&f.active))
+}
+
+// Total returns the number of connections accepted.
+func (f *Forward) Total() int {
+	return int(atomic.LoadInt64(&f.total))
+}
+
+// Close stops listening, closes the forwarded connections and waits for their
+// handlers, a handler still dialing through the server ending at the latest
+// with the connection of the Client.
+func (f *Forward) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return ErrForwardClosed
+	}
+	f.closed = true
+	err := f.ln.Close()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+
+	f.c.fwdMu.Lock()
+	delete(f.c.forwards, f)
+	f.c.fwdMu.Unlock()
+
+	return err
+}
+
+func (f *Forward) serve() {
+	defer f.wg.Done()
+
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			// closed, by Close or with the connection of the Client
+			return
+		}
+		if !f.track(conn) {
+			conn.Close()
+			return
+		}
+		atomic.AddInt64(&f.total, 1)
+
+		f.wg.Add(1)
+		go f.handle(conn)
+	}
+}
+
+func (f *Forward) handle(conn net.Conn) {
+	defer f.wg.Done()
+	atomic.AddInt64(&f.active, 1)
+	defer atomic.AddInt64(&f.active, -1)
+	defer f.untrack(conn)
+
+	dst, err := f.dial(conn)
+	if err != nil {
+		log.Glog.Warn("ssh forward", zap.String("host", f.c.Conf.Host), zap.String("forward", f.String()), zap.Error(err))
+		conn.Close()
+		return
+	}
+	if !f.track(dst) {
+		dst.Close()
+		conn.Close()
+		return
+	}
+	defer f.untrack(dst)
+
+	pipe(conn, dst)
+}
+
+// track records conn to be closed by Close, it returns false if f is closed.
+func (f *Forward) track(conn io.Closer) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+	f.conns[conn] = true
+	return true
+}
+
+func (f *Forward) untrack(conn io.Closer) {
+	f.mu.Lock()
+	delete(f.conns, conn)
+	f.mu.Unlock()
+}
+
+// closeForwards closes the forwardings of c.
+func (c *Client) closeForwards() {
+	c.fwdMu.Lock()
+	forwards := make([]*Forward, 0, len(c.forwards))
+	for f := range c.forwards {
+		forwards = append(forwards, f)
+	}
+	c.fwdMu.Unlock()
+
+	for _, f := range forwards {
+		f.Close()
+	}
+}
+
+// pipe copies between a and b until both directions end, then closes them.
+func pipe(a, b io.ReadWriteCloser) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(a, b)
+		closeWrite(a)
+		close(done)
+	}()
+	io.Copy(b, a)
+	closeWrite(b)
+	<-done
+
+	a.Close()
+	b.Close()
+}
+
+// closeWrite signals the end of the data written to c, closing it if it
+// can't be half closed.
+func closeWrite(c io.Closer) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
+
+// socks5Connect serves the handshake of a SOCKS5 CONNECT, RFC 1928 without
+// auth, on conn and returns the connection made by dial.
+func socks5Connect(conn net.Conn, dial func(addr string) (net.Conn, error)) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	b := make([]byte, 256)
+	read := func(n int) ([]byte, error) {
+		_, err := io.ReadFull(conn, b[:n])
+		return b[:n], err
+	}
+	reply := func(code byte) error {
+		_, err := conn.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+
+	// version and methods
+	hdr, err := read(2)
+	if err != nil {
+		return nil, err
+	}
+	if hdr[0] != 5 {
+		return nil, fmt.Errorf("ssh: socks: bad version %d", hdr[0])
+	}
+	methods, err := read(int(hdr[1]))
+	if err != nil {
+		return nil, err
+	}
+	noAuth := false
+	for _, m := range methods {
+		noAuth = noAuth || m == 0
+	}
+	if !noAuth {
+		conn.Write([]byte{5, 0xff})
+		return nil, errors.New("ssh: socks: no supported auth method")
+	}
+	if _, err = conn.Write([]byte{5, 0}); err != nil {
+		return nil, err
+	}
+
+	// request
+	hdr, err = read(4)
+	if err != nil {
+		return nil, err
+	}
+	if hdr[1] != 1 {
+		reply(7)
+		return nil, fmt.Errorf("ssh: socks: unsupported command %d", hdr[1])
+	}
+	var host string
+	switch hdr[3] {
+	case 1, 4:
+		n := net.IPv4len
+		if hdr[3] == 4 {
+			n = net.IPv6len
+		}
+		ip, err := read(n)
+		if err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n, err := read(1)
+		if err != nil {
+			return nil, err
+		}
+		name, err := read(int(n[0]))
+		if err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		reply(8)
+		return nil, fmt.Errorf("ssh: socks: unsupported address type %d", hdr[3])
+	}
+	port, err := read(2)
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	dst, err := dial(addr)
+	if err != nil {
+		reply(5)
+		return nil, err
+	}
+	if err = reply(0); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	return dst, nil
+}
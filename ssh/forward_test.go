@@ -0,0 +1,202 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// newEchoServer starts a TCP server echoing what it reads.
+func newEchoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// echo writes msg to conn, ends its writes and returns what is read back.
+func echo(t *testing.T, conn net.Conn, msg string) string {
+	t.Helper()
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	conn.(interface{ CloseWrite() error }).CloseWrite()
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestForwardLocal(t *testing.T) {
+	c := newTestClient(t)
+	addr := newEchoServer(t)
+
+	f, err := c.ForwardLocal("127.0.0.1:0", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", f.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := echo(t, conn, "ping"); got != "ping" {
+			t.Errorf("unexpected echo %q", got)
+		}
+	}
+	waitFor(t, "the connections to end", func() bool { return f.Active() == 0 })
+	if f.Total() != 2 {
+		t.Errorf("expected 2 connections, got %d", f.Total())
+	}
+}
+
+func TestForwardRemote(t *testing.T) {
+	c := newTestClient(t)
+	addr := newEchoServer(t)
+
+	f, err := c.ForwardRemote("127.0.0.1:0", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the server is local
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := echo(t, conn, "pong"); got != "pong" {
+		t.Errorf("unexpected echo %q", got)
+	}
+	if f.Total() != 1 {
+		t.Errorf("expected 1 connection, got %d", f.Total())
+	}
+
+	if err = f.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err = net.DialTimeout("tcp", f.Addr().String(), time.Second); err == nil {
+		t.Error("expected the remote listener to be closed")
+	}
+}
+
+func TestForwardDynamic(t *testing.T) {
+	c := newTestClient(t)
+	addr := newEchoServer(t)
+
+	f, err := c.ForwardDynamic("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	d, err := proxy.SOCKS5("tcp", f.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := echo(t, conn, "socks"); got != "socks" {
+		t.Errorf("unexpected echo %q", got)
+	}
+
+	// the server can't connect
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	ln.Close()
+	if _, err = d.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("expected a connection error")
+	}
+}
+
+func TestForwardClose(t *testing.T) {
+	s := newTestServer(t, nil)
+	c, err := NewClient(s.clientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := newEchoServer(t)
+
+	f, err := c.ForwardLocal("127.0.0.1:0", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "the connection to be forwarded", func() bool { return f.Active() == 1 })
+
+	// closes the forwardings and their connections
+	c.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+	if f.Active() != 0 {
+		t.Errorf("expected no active connection, got %d", f.Active())
+	}
+	if _, err = net.Dial("tcp", f.Addr().String()); err == nil {
+		t.Error("expected the listener to be closed")
+	}
+	if err = f.Close(); !errors.Is(err, ErrForwardClosed) {
+		t.Errorf("expected ErrForwardClosed, got %v", err)
+	}
+}
+
+func TestForwardCloseDialing(t *testing.T) {
+	s := newTestServer(t, nil)
+	c, err := NewClient(s.clientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := c.ForwardLocal("127.0.0.1:0", hangHost+":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "the connection to be dialing", func() bool { return f.Active() == 1 })
+
+	done := make(chan struct{})
+	go func() {
+		c.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close not to wait for the dialing handler")
+	}
+}
@@ -0,0 +1,275 @@
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meilihao/golib/v2/cmd"
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrSkipped = errors.New("ssh: skipped after a failure")
+)
+
+// GroupOption configures a Group.
+type GroupOption struct {
+	// Concurrency is the number of hosts run at once, 10 by default.
+	Concurrency int
+	// Timeout bounds the run on each host, connection included, none if 0.
+	// The connections of a Pool are shared, their dial is also bounded by
+	// PoolOption.DialTimeout.
+	Timeout time.Duration
+	// FailFast stops at the first failed host: the running hosts are
+	// canceled and the others skipped with ErrSkipped. Otherwise all the
+	// hosts are run.
+	FailFast bool
+	// Pool provides the connections, else they are dialed and closed for
+	// each run.
+	Pool *Pool
+}
+
+// Group runs commands on many hosts.
+type Group struct {
+	Hosts []*ClientConfig
+	opt   GroupOption
+}
+
+// NewGroup returns a Group of hosts, opt may be nil.
+func NewGroup(hosts []*ClientConfig, opt *GroupOption) *Group {
+	g := &Group{Hosts: hosts}
+	if opt != nil {
+		g.opt = *opt
+	}
+	if g.opt.Concurrency <= 0 {
+		g.opt.Concurrency = 10
+	}
+
+	return g
+}
+
+// HostResult is the result of a host, Result is nil if it failed before
+// running the command.
+type HostResult struct {
+	// Host is "user@host:port".
+	Host   string
+	Result *Result
+	Err    error
+}
+
+// Failed returns true if the host failed or the command exited non zero.
+func (r *HostResult) Failed() bool {
+	return r.Err != nil || r.Result == nil || !r.Result.IsSuccess()
+}
+
+// ReportGroup are the hosts with the same result.
+type ReportGroup struct {
+	// ExitStatus is -1 for an error.
+	ExitStatus     int
+	Stdout, Stderr string
+	Err            string
+	Hosts          []string
+}
+
+// Report is the result of a Group run.
+type Report struct {
+	// Results are in the order of the hosts.
+	Results []*HostResult
+	// Groups are by identical result, the largest first.
+	Groups   []*ReportGroup
+	Duration time.Duration
+}
+
+// Failed returns the results of the failed hosts.
+func (r *Report) Failed() []*HostResult {
+	var failed []*HostResult
+	for _, hr := range r.Results {
+		if hr.Failed() {
+			failed = append(failed, hr)
+		}
+	}
+	return failed
+}
+
+// String summarizes the report, a group per section.
+func (r *Report) String() string {
+	var b strings.Builder
+
+	failed := len(r.Failed())
+	fmt.Fprintf(&b, "%d hosts, %d ok, %d failed, %s\n", len(r.Results), len(r.Results)-failed, failed, r.Duration.Round(time.Millisecond))
+	for _, g := range r.Groups {
+		status := fmt.Sprintf("exit %d", g.ExitStatus)
+		if g.Err != "" {
+			status = "error: " + g.Err
+		}
+		fmt.Fprintf(&b, "--- %d hosts, %s: %s\n", len(g.Hosts), status, strings.Join(g.Hosts, ", "))
+		if g.Stdout != "" {
+			fmt.Fprintln(&b, g.Stdout)
+		}
+		if g.Stderr != "" {
+			fmt.Fprintln(&b, g.Stderr)
+		}
+	}
+	return b.String()
+}
+
+// Execute runs s on the hosts.
+func (g *Group) Execute(ctx context.Context, s string) *Report {
+	return g.run(ctx, func(ctx context.Context, c *Client) (*Result, error) {
+		return c.ExecuteContext(ctx, s)
+	})
+}
+
+// Script uploads script to a temporary file on the hosts, and runs it with
+// args, then removes it, even if ctx is done.
+func (g *Group) Script(ctx context.Context, script []byte, args ...string) *Report {
+	return g.run(ctx, func(ctx context.Context, c *Client) (*Result, error) {
+		// by host, the hosts may share /tmp
+		b := make([]byte, 8)
+		rand.Read(b)
+		name := "/tmp/.golib-script-" + hex.EncodeToString(b)
+
+		cmdline := cmd.Quote(name)
+		for _, arg := range args {
+			cmdline += " " + cmd.Quote(arg)
+		}
+		cmdline += "; rc=$?; rm -f " + cmd.Quote(name) + "; exit $rc"
+
+		if err := c.WriteFile(name, script, 0700); err != nil {
+			return nil, err
+		}
+		r, err := c.ExecuteContext(ctx, cmdline)
+		if ctx.Err() != nil {
+			// killed before removing the script
+			cctx, cancel := context.WithTimeout(context.Background(), c.Conf.Timeout)
+			defer cancel()
+			c.ExecuteContext(cctx, "rm -f "+cmd.Quote(name), true)
+		}
+		return r, err
+	})
+}
+
+// run calls fn for each host, Concurrency at once.
+func (g *Group) run(ctx context.Context, fn func(ctx context.Context, c *Client) (*Result, error)) *Report {
+	started := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*HostResult, len(g.Hosts))
+	for i, conf := range g.Hosts {
+		results[i] = &HostResult{Host: PoolKey(conf)}
+	}
+
+	var mu sync.Mutex
+	failed := false
+	skip := func(hr *HostResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		hr.Err = ctx.Err()
+		if failed {
+			hr.Err = ErrSkipped
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, g.opt.Concurrency)
+	for i, conf := range g.Hosts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			for _, hr := range results[i:] {
+				skip(hr)
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func(hr *HostResult, conf *ClientConfig) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			hr.Result, hr.Err = g.runHost(ctx, conf, fn)
+			if g.opt.FailFast && hr.Failed() {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+				cancel()
+			}
+		}(results[i], conf)
+	}
+	wg.Wait()
+
+	r := &Report{Results: results, Groups: groupResults(results), Duration: time.Since(started)}
+	log.Glog.Info("ssh group", zap.Int("hosts", len(results)), zap.Int("failed", len(r.Failed())), zap.Duration("time", r.Duration))
+
+	return r
+}
+
+func (g *Group) runHost(ctx context.Context, conf *ClientConfig, fn func(ctx context.Context, c *Client) (*Result, error)) (r *Result, err error) {
+	if g.opt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.opt.Timeout)
+		defer cancel()
+	}
+
+	if g.opt.Pool != nil {
+		err = g.opt.Pool.do(ctx, conf, func(c *Client) error {
+			r, err = fn(ctx, c)
+			return err
+		})
+		return r, err
+	}
+
+	// NewClient sets the defaults of its conf
+	hostConf := *conf
+	c, err := NewClientContext(ctx, &hostConf)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	return fn(ctx, c)
+}
+
+// groupResults groups the results by identical status and output.
+func groupResults(results []*HostResult) []*ReportGroup {
+	var groups []*ReportGroup
+	index := map[string]*ReportGroup{}
+	for _, hr := range results {
+		g := &ReportGroup{ExitStatus: -1}
+		if hr.Result != nil {
+			g.Stdout, g.Stderr = hr.Result.Stdout(), hr.Result.Stderr()
+			if hr.Err == nil {
+				g.ExitStatus = hr.Result.ExitStatus
+			}
+		}
+		if hr.Err != nil {
+			g.Err = hr.Err.Error()
+		}
+
+		key := fmt.Sprintf("%d\x00%s\x00%s\x00%s", g.ExitStatus, g.Err, g.Stdout, g.Stderr)
+		if found := index[key]; found != nil {
+			g = found
+		} else {
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.Hosts = append(g.Hosts, hr.Host)
+	}
+
+	// stable, so that groups of the same size are in the order of the hosts
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].Hosts) > len(groups[j].Hosts) })
+
+	return groups
+}
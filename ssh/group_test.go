@@ -0,0 +1,169 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closedHost returns the config of a host refusing connections.
+func closedHost(t *testing.T) *ClientConfig {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+
+	return &ClientConfig{User: testUser, Host: addr.IP.String(), Port: addr.Port, Password: testPassword, DisableAgent: true}
+}
+
+func TestGroupExecute(t *testing.T) {
+	var hosts []*ClientConfig
+	for i := 0; i < 3; i++ {
+		hosts = append(hosts, newTestServer(t, nil).clientConfig())
+	}
+	hosts = append(hosts, closedHost(t))
+
+	r := NewGroup(hosts, &GroupOption{Concurrency: 2}).Execute(context.Background(), "echo same")
+	if len(r.Results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(r.Results))
+	}
+	for i, hr := range r.Results {
+		if hr.Host != PoolKey(hosts[i]) {
+			t.Errorf("unexpected order %s", hr.Host)
+		}
+	}
+	if failed := r.Failed(); len(failed) != 1 || failed[0] != r.Results[3] || failed[0].Err == nil {
+		t.Errorf("expected the closed host to fail, got %v", failed)
+	}
+
+	if len(r.Groups) != 2 {
+		t.Fatalf("expected 2 groups, got %+v", r.Groups)
+	}
+	if g := r.Groups[0]; len(g.Hosts) != 3 || g.ExitStatus != 0 || g.Stdout != "same" {
+		t.Errorf("unexpected group %+v", g)
+	}
+	if g := r.Groups[1]; len(g.Hosts) != 1 || g.ExitStatus != -1 || g.Err == "" {
+		t.Errorf("unexpected group %+v", g)
+	}
+	if s := r.String(); !strings.HasPrefix(s, "4 hosts, 3 ok, 1 failed") || !strings.Contains(s, "--- 3 hosts, exit 0: ") {
+		t.Errorf("unexpected report %s", s)
+	}
+}
+
+func TestGroupStatus(t *testing.T) {
+	hosts := []*ClientConfig{newTestServer(t, nil).clientConfig(), newTestServer(t, nil).clientConfig()}
+
+	r := NewGroup(hosts, nil).Execute(context.Background(), "echo failed >&2; exit 2")
+	if len(r.Groups) != 1 || len(r.Failed()) != 2 {
+		t.Fatalf("unexpected groups %+v", r.Groups)
+	}
+	if g := r.Groups[0]; g.ExitStatus != 2 || g.Stderr != "failed" || g.Err != "" {
+		t.Errorf("unexpected group %+v", g)
+	}
+}
+
+func TestGroupTimeout(t *testing.T) {
+	hosts := []*ClientConfig{newTestServer(t, nil).clientConfig(), newTestServer(t, nil).clientConfig()}
+
+	started := time.Now()
+	r := NewGroup(hosts, &GroupOption{Timeout: 200 * time.Millisecond}).Execute(context.Background(), "sleep 5")
+	for _, hr := range r.Results {
+		if !errors.Is(hr.Err, context.DeadlineExceeded) {
+			t.Errorf("expected a deadline error, got %v", hr.Err)
+		}
+	}
+	if time.Since(started) > 3*time.Second {
+		t.Error("the hosts were not run at once")
+	}
+}
+
+func TestGroupConnectTimeout(t *testing.T) {
+	host := newStalledServer(t)
+
+	started := time.Now()
+	r := NewGroup([]*ClientConfig{host}, &GroupOption{Timeout: 200 * time.Millisecond}).Execute(context.Background(), "true")
+	if err := r.Results[0].Err; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+	if time.Since(started) > 3*time.Second {
+		t.Error("expected the handshake to be abandoned")
+	}
+}
+
+func TestGroupFailFast(t *testing.T) {
+	var hosts []*ClientConfig
+	for i := 0; i < 3; i++ {
+		hosts = append(hosts, newTestServer(t, nil).clientConfig())
+	}
+
+	r := NewGroup(hosts, &GroupOption{Concurrency: 1, FailFast: true}).Execute(context.Background(), "exit 1")
+	if hr := r.Results[0]; hr.Err != nil || hr.Result.ExitStatus != 1 {
+		t.Errorf("unexpected result %+v", hr)
+	}
+	for _, hr := range r.Results[1:] {
+		if !errors.Is(hr.Err, ErrSkipped) || hr.Result != nil {
+			t.Errorf("expected the host to be skipped, got %+v", hr)
+		}
+	}
+
+	// best effort
+	r = NewGroup(hosts, &GroupOption{Concurrency: 1}).Execute(context.Background(), "exit 1")
+	if len(r.Failed()) != 3 || r.Results[2].Result == nil {
+		t.Errorf("expected all the hosts to run, got %v", r)
+	}
+}
+
+func TestGroupScript(t *testing.T) {
+	hosts := []*ClientConfig{newTestServer(t, nil).clientConfig(), newTestServer(t, nil, withoutSFTP).clientConfig()}
+
+	r := NewGroup(hosts, nil).Script(context.Background(), []byte("#!/bin/bash\necho $0\necho \"args $1|$2\"\n"), "a b", "c")
+	if len(r.Failed()) != 0 {
+		t.Fatalf("unexpected report %s", r)
+	}
+	for _, hr := range r.Results {
+		lines := strings.Split(hr.Result.Stdout(), "\n")
+		if len(lines) != 2 || lines[1] != "args a b|c" {
+			t.Fatalf("unexpected output %q", hr.Result.Stdout())
+		}
+		if _, err := os.Stat(lines[0]); !os.IsNotExist(err) {
+			t.Errorf("expected the script to be removed, got %v", err)
+		}
+	}
+}
+
+func TestGroupScriptTimeout(t *testing.T) {
+	before, _ := filepath.Glob("/tmp/.golib-script-*")
+	hosts := []*ClientConfig{newTestServer(t, nil).clientConfig()}
+
+	r := NewGroup(hosts, &GroupOption{Timeout: 300 * time.Millisecond}).Script(context.Background(), []byte("#!/bin/bash\nsleep 5\n"))
+	if err := r.Results[0].Err; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	after, _ := filepath.Glob("/tmp/.golib-script-*")
+	if len(after) > len(before) {
+		t.Errorf("expected the script to be removed, got %v", after)
+	}
+}
+
+func TestGroupPool(t *testing.T) {
+	s := newTestServer(t, nil)
+	p := NewPool(nil)
+	defer p.Close()
+
+	g := NewGroup([]*ClientConfig{s.clientConfig()}, &GroupOption{Pool: p})
+	for i := 0; i < 2; i++ {
+		if r := g.Execute(context.Background(), "true"); len(r.Failed()) != 0 {
+			t.Fatalf("unexpected report %s", r)
+		}
+	}
+	if s.dials() != 1 {
+		t.Errorf("expected the connection to be reused, got %d dials", s.dials())
+	}
+}
@@ -0,0 +1,201 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	ErrHostKeyMismatch = errors.New("ssh: host key mismatch")
+	ErrHostKeyUnknown  = errors.New("ssh: host key unknown")
+	ErrHostKeyRevoked  = errors.New("ssh: host key revoked")
+)
+
+// HostKeyError is returned by Connect when the server is not trusted. It
+// matches ErrHostKeyMismatch, ErrHostKeyUnknown or ErrHostKeyRevoked with
+// errors.Is.
+type HostKeyError struct {
+	Hostname string
+	Remote   net.Addr
+	Key      ssh.PublicKey
+	// Want are the fingerprints of the keys expected for the host.
+	Want []string
+	Err  error
+}
+
+func (e *HostKeyError) Error() string {
+	s := fmt.Sprintf("%v for %s: got %s %s", e.Err, e.Hostname, e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+	if len(e.Want) > 0 {
+		s += ", want " + strings.Join(e.Want, " or ")
+	}
+	return s
+}
+
+func (e *HostKeyError) Unwrap() error {
+	return e.Err
+}
+
+// HostKeyPolicy verifies the host key of the server a Client connects to.
+type HostKeyPolicy interface {
+	// Check returns an error, preferably a *HostKeyError, if key is not
+	// trusted for hostname, "host:port".
+	Check(hostname string, remote net.Addr, key ssh.PublicKey) error
+}
+
+// HostKeyFunc is a HostKeyPolicy function, e.g. to ask the user.
+type HostKeyFunc func(hostname string, remote net.Addr, key ssh.PublicKey) error
+
+func (f HostKeyFunc) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	return f(hostname, remote, key)
+}
+
+// InsecureIgnoreHostKey accepts any host key, it is the default for
+// compatibility.
+func InsecureIgnoreHostKey() HostKeyPolicy {
+	return HostKeyFunc(ssh.InsecureIgnoreHostKey())
+}
+
+// PinnedFingerprints accepts the host keys with one of fingerprints, in the
+// format of ssh-keygen -l: "SHA256:..." or "MD5:aa:bb:...".
+func PinnedFingerprints(fingerprints ...string) HostKeyPolicy {
+	return HostKeyFunc(func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		sha256, md5 := ssh.FingerprintSHA256(key), "MD5:"+ssh.FingerprintLegacyMD5(key)
+		for _, fp := range fingerprints {
+			if fp == sha256 || strings.EqualFold(fp, md5) {
+				return nil
+			}
+		}
+		return &HostKeyError{Hostname: hostname, Remote: remote, Key: key, Want: fingerprints, Err: ErrHostKeyMismatch}
+	})
+}
+
+// knownHostsPolicy checks the keys with known_hosts files.
+type knownHostsPolicy struct {
+	mu       sync.Mutex
+	callback ssh.HostKeyCallback
+	files    []string
+	// tofu is the file the keys of unknown hosts are added to, if any
+	tofu string
+}
+
+// KnownHosts accepts the host keys listed for the host in OpenSSH
+// known_hosts files, e.g. ~/.ssh/known_hosts.
+func KnownHosts(files ...string) (HostKeyPolicy, error) {
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, err
+	}
+	return &knownHostsPolicy{callback: callback, files: files}, nil
+}
+
+// TrustOnFirstUse accepts the host keys in the known_hosts file path, and
+// adds the key of unknown hosts to it. The file is created if needed.
+func TrustOnFirstUse(path string) (HostKeyPolicy, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, err
+	}
+	return &knownHostsPolicy{callback: callback, files: []string{path}, tofu: path}, nil
+}
+
+func (p *knownHostsPolicy) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.callback(hostname, remote, key)
+
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &revokedErr):
+		return &HostKeyError{Hostname: hostname, Remote: remote, Key: key, Err: ErrHostKeyRevoked}
+	case !errors.As(err, &keyErr):
+		return err
+	case len(keyErr.Want) > 0:
+		want := make([]string, len(keyErr.Want))
+		for i, k := range keyErr.Want {
+			want[i] = ssh.FingerprintSHA256(k.Key)
+		}
+		return &HostKeyError{Hostname: hostname, Remote: remote, Key: key, Want: want, Err: ErrHostKeyMismatch}
+	case p.tofu == "":
+		return &HostKeyError{Hostname: hostname, Remote: remote, Key: key, Err: ErrHostKeyUnknown}
+	}
+
+	if err = p.trust(hostname, key); err != nil {
+		return err
+	}
+	log.Glog.Warn("ssh trust host key on first use", zap.String("host", hostname), zap.String("key", ssh.FingerprintSHA256(key)))
+
+	return nil
+}
+
+// trust adds key for hostname to the tofu file.
+func (p *knownHostsPolicy) trust(hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(p.tofu, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	p.callback, err = knownhosts.New(p.files...)
+	return err
+}
+
+// hostKeyAlgorithms returns the algorithms of the keys known for hostname,
+// so that the server is not asked for another key type it may also have.
+func (p *knownHostsPolicy) hostKeyAlgorithms(hostname string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the KeyError of an unknown key lists the known ones
+	probe, _ := ssh.NewPublicKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public())
+	var keyErr *knownhosts.KeyError
+	if err := p.callback(hostname, &net.TCPAddr{}, probe); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	types := make([]string, 0, len(keyErr.Want))
+	for _, k := range keyErr.Want {
+		types = append(types, k.Key.Type())
+	}
+	sort.Strings(types)
+
+	// Want has a key per type
+	var algos []string
+	for _, typ := range types {
+		if typ == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algos = append(algos, typ)
+	}
+	return algos
+}
@@ -0,0 +1,144 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func writeKnownHosts(t *testing.T, s *testServer, key ssh.PublicKey) string {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.Addr)}, key)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func connect(s *testServer, policy HostKeyPolicy) error {
+	conf := s.clientConfig()
+	conf.HostKey = policy
+	c, err := NewClient(conf)
+	if err == nil {
+		c.Close()
+	}
+	return err
+}
+
+func TestKnownHosts(t *testing.T) {
+	s := newTestServer(t, nil)
+	other := newTestServer(t, nil)
+
+	policy, err := KnownHosts(writeKnownHosts(t, s, s.HostKey.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = connect(s, policy); err != nil {
+		t.Errorf("expected the known key to be accepted, got %v", err)
+	}
+
+	policy, _ = KnownHosts(writeKnownHosts(t, s, other.HostKey.PublicKey()))
+	err = connect(s, policy)
+	var hkErr *HostKeyError
+	if !errors.Is(err, ErrHostKeyMismatch) || !errors.As(err, &hkErr) {
+		t.Fatalf("expected ErrHostKeyMismatch, got %v", err)
+	}
+	if len(hkErr.Want) != 1 || hkErr.Want[0] != ssh.FingerprintSHA256(other.HostKey.PublicKey()) {
+		t.Errorf("unexpected want %v", hkErr.Want)
+	}
+
+	if err = connect(other, policy); !errors.Is(err, ErrHostKeyUnknown) {
+		t.Errorf("expected ErrHostKeyUnknown, got %v", err)
+	}
+}
+
+func TestKnownHostsAlgorithms(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigner, _ := ssh.NewSignerFromKey(key)
+
+	// the client prefers RSA, the server must be asked for the known ed25519
+	// key
+	config := testServerConfig()
+	config.AddHostKey(rsaSigner)
+	s := newTestServer(t, config)
+
+	policy, _ := KnownHosts(writeKnownHosts(t, s, s.HostKey.PublicKey()))
+	if err = connect(s, policy); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	s := newTestServer(t, nil)
+	path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	policy, err := TrustOnFirstUse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = connect(s, policy); err != nil {
+		t.Fatalf("expected the first key to be trusted, got %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), s.HostKey.PublicKey().Type()) {
+		t.Errorf("expected the key to be stored, got %q", data)
+	}
+
+	// a new policy reads the stored key
+	policy, _ = TrustOnFirstUse(path)
+	if err = connect(s, policy); err != nil {
+		t.Errorf("expected the stored key to be accepted, got %v", err)
+	}
+
+	other := newTestServer(t, nil)
+	if err = os.WriteFile(path, []byte(knownhosts.Line([]string{knownhosts.Normalize(other.Addr)}, s.HostKey.PublicKey())+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, _ = TrustOnFirstUse(path)
+	if err = connect(other, policy); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("expected ErrHostKeyMismatch, got %v", err)
+	}
+}
+
+func TestPinnedFingerprints(t *testing.T) {
+	s := newTestServer(t, nil)
+	key := s.HostKey.PublicKey()
+
+	if err := connect(s, PinnedFingerprints("SHA256:nope", ssh.FingerprintSHA256(key))); err != nil {
+		t.Error(err)
+	}
+	if err := connect(s, PinnedFingerprints("MD5:"+strings.ToUpper(ssh.FingerprintLegacyMD5(key)))); err != nil {
+		t.Error(err)
+	}
+	if err := connect(s, PinnedFingerprints("SHA256:nope")); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("expected ErrHostKeyMismatch, got %v", err)
+	}
+}
+
+func TestHostKeyFunc(t *testing.T) {
+	s := newTestServer(t, nil)
+	errRefused := errors.New("refused")
+
+	var hostname string
+	err := connect(s, HostKeyFunc(func(h string, remote net.Addr, key ssh.PublicKey) error {
+		hostname = h
+		return errRefused
+	}))
+	if !errors.Is(err, errRefused) {
+		t.Errorf("expected the error of the callback, got %v", err)
+	}
+	if hostname != s.Addr {
+		t.Errorf("expected hostname %s, got %s", s.Addr, hostname)
+	}
+}
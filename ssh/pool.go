@@ -0,0 +1,453 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/meilihao/golib/v2/cmd"
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrPoolClosed = errors.New("ssh: pool closed")
+)
+
+// PoolOption configures a Pool.
+type PoolOption struct {
+	// KeepaliveInterval is the interval of the keepalive requests, 30s by
+	// default, none if negative.
+	KeepaliveInterval time.Duration
+	// KeepaliveTimeout is how long to wait for the reply of a keepalive
+	// request before closing the connection, 15s by default.
+	KeepaliveTimeout time.Duration
+	// MaxSessions is the number of sessions open at once per host, 10 by
+	// default like the MaxSessions of sshd.
+	MaxSessions int
+	// DialTimeout bounds each connection, handshake and auth included, 30s
+	// by default. The dials of a host are serialized, a stalled one delays
+	// the others that long.
+	DialTimeout time.Duration
+}
+
+// HostStats is the health of the connection of a Pool to a host.
+type HostStats struct {
+	// Key is "user@host:port".
+	Key       string
+	Connected bool
+	// Sessions is the number of sessions in use.
+	Sessions int
+	// Dials is the number of connections made, Reconnects the ones
+	// replacing a lost connection, Failures the failed dials.
+	Dials      int
+	Reconnects int
+	Failures   int
+	// KeepaliveFailures is the number of connections closed because a
+	// keepalive request failed.
+	KeepaliveFailures int
+	// LastKeepalive is the time of the last answered keepalive request and
+	// RTT its round trip time.
+	LastKeepalive time.Time
+	RTT           time.Duration
+	// LastError is the last dial or connection error.
+	LastError error
+}
+
+// Pool shares a connection per "user@host:port" between its users. The
+// connections are checked with keepalive requests and redialed when lost.
+type Pool struct {
+	opt  PoolOption
+	done chan struct{}
+
+	mu     sync.Mutex
+	hosts  map[string]*poolHost
+	closed bool
+}
+
+// NewPool returns a Pool, opt may be nil.
+func NewPool(opt *PoolOption) *Pool {
+	p := &Pool{
+		done:  make(chan struct{}),
+		hosts: map[string]*poolHost{},
+	}
+	if opt != nil {
+		p.opt = *opt
+	}
+	if p.opt.KeepaliveInterval == 0 {
+		p.opt.KeepaliveInterval = 30 * time.Second
+	}
+	if p.opt.KeepaliveTimeout == 0 {
+		p.opt.KeepaliveTimeout = 15 * time.Second
+	}
+	if p.opt.MaxSessions == 0 {
+		p.opt.MaxSessions = 10
+	}
+	if p.opt.DialTimeout == 0 {
+		p.opt.DialTimeout = 30 * time.Second
+	}
+
+	return p
+}
+
+// PoolKey returns the key of the connection to conf in a Pool,
+// "user@host:port", followed by the jump hosts and the proxy it goes
+// through, if any, the password of the proxy being masked:
+// "root@10.0.0.2:22 via admin@bastion:22 via socks5://proxy:1080".
+func PoolKey(conf *ClientConfig) string {
+	key := addrKey(conf)
+	for _, j := range conf.Jump {
+		key += " via " + addrKey(j)
+	}
+	if conf.Proxy != "" {
+		proxy := conf.Proxy
+		if u, err := url.Parse(proxy); err == nil {
+			proxy = u.Redacted()
+		}
+		key += " via " + proxy
+	}
+	return key
+}
+
+// addrKey returns "user@host:port" for conf.
+func addrKey(conf *ClientConfig) string {
+	port := conf.Port
+	if port == 0 {
+		port = 22
+	}
+	return conf.User + "@" + net.JoinHostPort(conf.Host, strconv.Itoa(port))
+}
+
+// poolHost is the connection to a host.
+type poolHost struct {
+	p   *Pool
+	key string
+	// sem limits the sessions
+	sem chan struct{}
+	// dial serializes the dials, a slot that can be waited for with a ctx
+	dial chan struct{}
+
+	mu    sync.Mutex
+	conf  ClientConfig
+	conn  *poolConn
+	stats HostStats
+}
+
+// poolConn is a connection of a poolHost, closed once dead.
+type poolConn struct {
+	c    *Client
+	dead chan struct{}
+	once sync.Once
+}
+
+func (pc *poolConn) alive() bool {
+	select {
+	case <-pc.dead:
+		return false
+	default:
+		return true
+	}
+}
+
+func (pc *poolConn) close() {
+	pc.once.Do(func() {
+		close(pc.dead)
+		pc.c.Close()
+	})
+}
+
+// host returns the poolHost of conf. The last conf of a key is the one used
+// for the next dial.
+func (p *Pool) host(conf *ClientConfig) (*poolHost, error) {
+	key := PoolKey(conf)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	h := p.hosts[key]
+	if h == nil {
+		h = &poolHost{
+			p:     p,
+			key:   key,
+			sem:   make(chan struct{}, p.opt.MaxSessions),
+			dial:  make(chan struct{}, 1),
+			stats: HostStats{Key: key},
+		}
+		p.hosts[key] = h
+	}
+
+	h.mu.Lock()
+	h.conf = *conf
+	h.mu.Unlock()
+
+	return h, nil
+}
+
+// connect returns the live connection of h, dialing it if needed. The dial
+// is bounded by ctx and PoolOption.DialTimeout.
+func (h *poolHost) connect(ctx context.Context) (*poolConn, error) {
+	select {
+	case h.dial <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-h.p.done:
+		return nil, ErrPoolClosed
+	}
+	defer func() { <-h.dial }()
+
+	h.mu.Lock()
+	pc, conf := h.conn, h.conf
+	h.mu.Unlock()
+	if pc != nil && pc.alive() {
+		return pc, nil
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, h.p.opt.DialTimeout)
+	c, err := NewClientContext(dialCtx, &conf)
+	cancel()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		// the caller giving up says nothing about the host
+		if ctx.Err() == nil {
+			h.stats.Failures++
+			h.stats.LastError = err
+			log.Glog.Error("ssh pool dial", zap.String("host", h.key), zap.Error(err))
+		}
+		return nil, err
+	}
+	// checked under h.mu, so that Close sees the new connection otherwise
+	select {
+	case <-h.p.done:
+		c.Close()
+		return nil, ErrPoolClosed
+	default:
+	}
+
+	h.stats.Dials++
+	if pc != nil {
+		h.stats.Reconnects++
+		log.Glog.Warn("ssh pool reconnect", zap.String("host", h.key))
+	}
+	h.conn = &poolConn{c: c, dead: make(chan struct{})}
+	go h.watch(h.conn)
+
+	return h.conn, nil
+}
+
+// watch sends the keepalive requests of pc until it is closed.
+func (h *poolHost) watch(pc *poolConn) {
+	wait := make(chan error, 1)
+	go func() {
+		wait <- pc.c.Conn.Wait()
+	}()
+
+	var tick <-chan time.Time
+	if h.p.opt.KeepaliveInterval > 0 {
+		t := time.NewTicker(h.p.opt.KeepaliveInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case err := <-wait:
+			h.lost(pc, fmt.Errorf("ssh: connection lost: %v", err))
+			return
+		case <-h.p.done:
+			pc.close()
+			return
+		case <-tick:
+			started := time.Now()
+			err := pc.c.Keepalive(h.p.opt.KeepaliveTimeout)
+
+			h.mu.Lock()
+			if err != nil {
+				h.stats.KeepaliveFailures++
+			} else {
+				h.stats.LastKeepalive, h.stats.RTT = time.Now(), time.Since(started)
+			}
+			h.mu.Unlock()
+
+			if err != nil {
+				h.lost(pc, err)
+				return
+			}
+		}
+	}
+}
+
+// lost closes pc, which failed with err, so that the next user redials.
+func (h *poolHost) lost(pc *poolConn, err error) {
+	if !pc.alive() {
+		return
+	}
+	pc.close()
+
+	h.mu.Lock()
+	h.stats.LastError = err
+	h.mu.Unlock()
+	log.Glog.Warn("ssh pool connection lost", zap.String("host", h.key), zap.Error(err))
+}
+
+// acquire waits for a session slot of h and returns its connection, and the
+// function releasing the slot.
+func (h *poolHost) acquire(ctx context.Context) (*poolConn, func(), error) {
+	select {
+	case h.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-h.p.done:
+		return nil, nil, ErrPoolClosed
+	}
+
+	pc, err := h.connect(ctx)
+	if err != nil {
+		<-h.sem
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return pc, func() { once.Do(func() { <-h.sem }) }, nil
+}
+
+// Get returns the shared Client connected to conf. It must not be closed,
+// and its sessions are not limited, see Acquire.
+func (p *Pool) Get(conf *ClientConfig) (*Client, error) {
+	h, err := p.host(conf)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := h.connect(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return pc.c, nil
+}
+
+// Acquire waits until a session can be opened on the host of conf, and
+// returns the shared Client connected to it and the function to call once
+// the session is closed. The Client must not be closed.
+func (p *Pool) Acquire(ctx context.Context, conf *ClientConfig) (*Client, func(), error) {
+	h, err := p.host(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	pc, release, err := h.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pc.c, release, nil
+}
+
+// do calls fn with a session slot on the host of conf. If fn can't open its
+// session, fn didn't run anything and is retried once on a new connection.
+func (p *Pool) do(ctx context.Context, conf *ClientConfig, fn func(c *Client) error) error {
+	h, err := p.host(conf)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		pc, release, err := h.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		err = fn(pc.c)
+		release()
+
+		var se *sessionError
+		if i > 0 || !errors.As(err, &se) {
+			return err
+		}
+		h.lost(pc, err)
+	}
+}
+
+// Execute is Client.ExecuteContext on the shared connection to conf,
+// redialed if lost.
+func (p *Pool) Execute(ctx context.Context, conf *ClientConfig, s string, ignoreErr ...bool) (r *Result, err error) {
+	err = p.do(ctx, conf, func(c *Client) error {
+		r, err = c.ExecuteContext(ctx, s, ignoreErr...)
+		return err
+	})
+	return r, err
+}
+
+// Runner returns a cmd.Executor running commands like Runner on the shared
+// connection to conf, redialed if lost.
+func (p *Pool) Runner(conf *ClientConfig) cmd.Executor {
+	return &poolRunner{p: p, conf: conf}
+}
+
+type poolRunner struct {
+	p    *Pool
+	conf *ClientConfig
+}
+
+func (r *poolRunner) RunCommand(ctx context.Context, opt *cmd.Option, c *cmd.Command) (res *cmd.Result, err error) {
+	err = r.p.do(ctx, r.conf, func(client *Client) error {
+		res, err = NewRunner(client).RunCommand(ctx, opt, c)
+		return err
+	})
+	if res == nil {
+		res = &cmd.Result{ExitCode: -1}
+	}
+	return res, err
+}
+
+// Stats returns the health of the connections, by key.
+func (p *Pool) Stats() []HostStats {
+	p.mu.Lock()
+	hosts := make([]*poolHost, 0, len(p.hosts))
+	for _, h := range p.hosts {
+		hosts = append(hosts, h)
+	}
+	p.mu.Unlock()
+
+	stats := make([]HostStats, 0, len(hosts))
+	for _, h := range hosts {
+		h.mu.Lock()
+		st := h.stats
+		st.Connected = h.conn != nil && h.conn.alive()
+		h.mu.Unlock()
+		st.Sessions = len(h.sem)
+
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+
+	return stats
+}
+
+// Close closes the connections, the Clients in use included.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	hosts := p.hosts
+	p.mu.Unlock()
+
+	for _, h := range hosts {
+		h.mu.Lock()
+		if h.conn != nil {
+			h.conn.close()
+		}
+		h.mu.Unlock()
+	}
+}
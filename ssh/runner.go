@@ -0,0 +1,179 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/meilihao/golib/v2/cmd"
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrUnsupportedOption = errors.New("ssh: cmd option not supported on a remote host")
+)
+
+// signals maps the signal names of the SSH protocol to their value.
+var signals = map[string]syscall.Signal{
+	"ABRT": syscall.SIGABRT,
+	"ALRM": syscall.SIGALRM,
+	"FPE":  syscall.SIGFPE,
+	"HUP":  syscall.SIGHUP,
+	"ILL":  syscall.SIGILL,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"PIPE": syscall.SIGPIPE,
+	"QUIT": syscall.SIGQUIT,
+	"SEGV": syscall.SIGSEGV,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// Runner is a cmd.Executor running commands on the host of a Client, so
+// that code using cmd.Executor, e.g. sys.GetMediumxsOn, works remotely
+// unchanged.
+//
+// Commands are run by the login shell of the user, with LANG=POSIX like
+// locally. Pipelines are run with pipefail like locally, which that shell
+// must support, e.g. bash. A command that is not found exits with 127.
+// Setpgid, Credential, Limits and SysProcAttr are not supported.
+type Runner struct {
+	Client *Client
+}
+
+// NewRunner returns a Runner using c.
+func NewRunner(c *Client) *Runner {
+	return &Runner{Client: c}
+}
+
+// RunCommand implements cmd.Executor. On cancel or timeout, the command is
+// sent SIGKILL and its session closed. It is audited like local commands,
+// see cmd.SetAuditSink.
+func (r *Runner) RunCommand(ctx context.Context, opt *cmd.Option, c *cmd.Command) (*cmd.Result, error) {
+	if opt == nil {
+		opt = &cmd.Option{}
+	}
+	res := &cmd.Result{ExitCode: -1}
+	if opt.Setpgid || opt.Credential != nil || opt.Limits != nil || opt.SysProcAttr != nil {
+		return res, ErrUnsupportedOption
+	}
+
+	if opt.Timeout.Seconds() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.Timeout)
+		defer cancel()
+	}
+
+	cmdline := CommandLine(c)
+	if len(c.Stages()) > 1 {
+		cmdline = "set -o pipefail; " + cmdline
+	}
+	if opt.Combined {
+		// one stream keeps the order of stdout and stderr
+		cmdline = "exec 2>&1; " + cmdline
+	}
+	started := time.Now()
+
+	ses, err := r.Client.Conn.NewSession()
+	if err != nil {
+		return res, &sessionError{err}
+	}
+	defer ses.Close()
+
+	var stdout, stderr bytes.Buffer
+	ses.Stdout = &stdout
+	ses.Stderr = &stderr
+	if opt.Stdin != nil {
+		ses.Stdin = opt.Stdin
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ses.Signal(ssh.SIGKILL)
+			ses.Close()
+		case <-stop:
+		}
+	}()
+
+	err = ses.Run(cmdline)
+	if opt.Combined {
+		res.Combined = stdout.Bytes()
+	} else {
+		res.Stdout, res.Stderr = stdout.Bytes(), stderr.Bytes()
+	}
+	res.Duration = time.Since(started)
+
+	var exerr *ssh.ExitError
+	switch {
+	case ctx.Err() != nil:
+		err = fmt.Errorf("ssh: %s: %w", c.String(), ctx.Err())
+	case errors.As(err, &exerr):
+		res.ExitCode = exerr.ExitStatus()
+		if sig, ok := signals[exerr.Signal()]; ok {
+			res.ExitCode = -1
+			res.Signal = sig
+		}
+		err = cmd.NewExitError(c.String(), res)
+	case err == nil:
+		res.ExitCode = 0
+	}
+
+	display := cmd.Redact(c.String())
+	if err != nil {
+		fields := []zap.Field{zap.String("host", r.Client.Conf.Host), zap.String("cmd", display), zap.String("error", cmd.Truncate(cmd.Redact(err.Error()))), zap.Int("code", res.ExitCode), zap.Duration("time", res.Duration)}
+		if !opt.IgnoreErr {
+			log.Glog.Error("ssh exec", fields...)
+		} else {
+			log.Glog.Debug("ssh exec", fields...)
+		}
+	} else {
+		log.Glog.Debug("ssh exec", zap.String("host", r.Client.Conf.Host), zap.String("cmd", display), zap.Duration("time", res.Duration))
+	}
+
+	rec := cmd.NewAuditRecord(c, nil, res, err)
+	rec.Host, rec.User = r.Client.Conf.Host, r.Client.Conf.User
+	cmd.Audit(ctx, rec)
+
+	return res, err
+}
+
+// CommandLine renders c for a remote POSIX shell, with the Dir, Env and
+// ClearEnv of its commands.
+func CommandLine(c *cmd.Command) string {
+	stages := c.Stages()
+	parts := make([]string, 0, len(stages))
+	for _, s := range stages {
+		var words []string
+		if s.ClearEnv {
+			words = append(words, "env", "-i")
+		} else {
+			words = append(words, "LANG=POSIX")
+		}
+		for _, kv := range s.Env {
+			if i := strings.Index(kv, "="); i > 0 {
+				words = append(words, kv[:i+1]+cmd.Quote(kv[i+1:]))
+			}
+		}
+		words = append(words, cmd.Quote(s.Name))
+		for _, a := range s.Args {
+			words = append(words, cmd.Quote(a))
+		}
+
+		part := strings.Join(words, " ")
+		if s.Dir != "" {
+			part = "(cd " + cmd.Quote(s.Dir) + " && " + part + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " | ")
+}
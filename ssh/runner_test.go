@@ -0,0 +1,145 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/meilihao/golib/v2/cmd"
+	"github.com/meilihao/golib/v2/sys"
+)
+
+func newTestRunner(t *testing.T) *Runner {
+	s := newTestServer(t, nil)
+	c, err := NewClient(s.clientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	return NewRunner(c)
+}
+
+func TestCommandLine(t *testing.T) {
+	c := cmd.New("udevadm", "info", "/dev/sg 1").Pipe(cmd.New("grep", "E: ID_PATH="))
+	c.Stages()[1].Dir = "/tmp"
+	c.Stages()[1].ClearEnv = true
+	c.SetEnv("A", "b c")
+
+	expected := `LANG=POSIX A='b c' udevadm info '/dev/sg 1' | (cd /tmp && env -i grep 'E: ID_PATH=')`
+	if s := CommandLine(c); s != expected {
+		t.Errorf("expected %s, got %s", expected, s)
+	}
+}
+
+func TestRunner(t *testing.T) {
+	r := newTestRunner(t)
+
+	res, err := r.RunCommand(context.Background(), nil, cmd.New("sh", "-c", "echo out; echo $LANG >&2; exit 3"))
+	if cmd.ExitCode(err) != 3 || res.ExitCode != 3 {
+		t.Fatalf("expected exit code 3, got %v", err)
+	}
+	if string(res.Stdout) != "out\n" || string(res.Stderr) != "POSIX\n" {
+		t.Errorf("unexpected output %q %q", res.Stdout, res.Stderr)
+	}
+
+	res, err = r.RunCommand(context.Background(), &cmd.Option{Stdin: strings.NewReader("a\nb\n")}, cmd.New("cat").Pipe(cmd.New("grep", "b")))
+	if err != nil || string(res.Stdout) != "b\n" {
+		t.Errorf("unexpected %q %v", res.Stdout, err)
+	}
+}
+
+func TestRunnerPipefail(t *testing.T) {
+	r := newTestRunner(t)
+
+	// the status of udevadm failing, not of grep
+	_, err := r.RunCommand(context.Background(), &cmd.Option{IgnoreErr: true}, cmd.New("sh", "-c", "echo E: ID_PATH=x; exit 4").Pipe(cmd.New("grep", "E: ID_PATH=")))
+	if cmd.ExitCode(err) != 4 {
+		t.Errorf("expected exit code 4, got %v", err)
+	}
+
+	_, err = r.RunCommand(context.Background(), &cmd.Option{IgnoreErr: true}, cmd.New("echo", "E: DEVNAME=x").Pipe(cmd.New("grep", "E: ID_PATH=")))
+	if cmd.ExitCode(err) != 1 {
+		t.Errorf("expected exit code 1, got %v", err)
+	}
+}
+
+func TestRunnerCombined(t *testing.T) {
+	r := newTestRunner(t)
+
+	res, err := r.RunCommand(context.Background(), &cmd.Option{Combined: true, IgnoreErr: true}, cmd.New("sh", "-c", "for i in 1 2 3; do echo out$i; echo err$i >&2; done; exit 3"))
+	if cmd.ExitCode(err) != 3 {
+		t.Fatalf("expected exit code 3, got %v", err)
+	}
+	expected := "out1\nerr1\nout2\nerr2\nout3\nerr3\n"
+	if string(res.Combined) != expected || res.Stdout != nil || res.Stderr != nil {
+		t.Errorf("expected %q only in Combined, got %q %q %q", expected, res.Combined, res.Stdout, res.Stderr)
+	}
+	if err.Error() != strings.TrimSpace(expected) {
+		t.Errorf("expected the combined output as error, got %q", err.Error())
+	}
+}
+
+func TestRunnerNotFound(t *testing.T) {
+	r := newTestRunner(t)
+
+	_, err := r.RunCommand(context.Background(), &cmd.Option{IgnoreErr: true}, cmd.New("golib-no-such-command"))
+	if cmd.ExitCode(err) != 127 {
+		t.Errorf("expected exit code 127, got %v", err)
+	}
+
+	if _, err = exec.LookPath("lsscsi"); err == nil {
+		t.Skip("lsscsi is installed")
+	}
+	if _, err = sys.GetMediumxsOn(context.Background(), r); err == nil || !strings.HasPrefix(err.Error(), "lsscsi not found") {
+		t.Errorf("expected lsscsi not found, got %v", err)
+	}
+}
+
+func TestRunnerSignal(t *testing.T) {
+	r := newTestRunner(t)
+
+	res, err := r.RunCommand(context.Background(), &cmd.Option{IgnoreErr: true}, cmd.New("sh", "-c", "kill -TERM $$"))
+	if err == nil || res.Signal != syscall.SIGTERM || res.ExitCode != -1 {
+		t.Errorf("expected SIGTERM, got %v %v", res.Signal, err)
+	}
+}
+
+func TestRunnerTimeout(t *testing.T) {
+	r := newTestRunner(t)
+
+	start := time.Now()
+	_, err := r.RunCommand(context.Background(), &cmd.Option{Timeout: 100 * time.Millisecond, IgnoreErr: true}, cmd.New("sleep", "5"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("expected the command to be killed, took %v", d)
+	}
+
+	// the connection is still usable
+	if _, err = r.RunCommand(context.Background(), nil, cmd.New("true")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunnerUnsupportedOption(t *testing.T) {
+	r := newTestRunner(t)
+
+	if _, err := r.RunCommand(context.Background(), &cmd.Option{Setpgid: true}, cmd.New("true")); !errors.Is(err, ErrUnsupportedOption) {
+		t.Errorf("expected ErrUnsupportedOption, got %v", err)
+	}
+}
+
+func TestRunnerCmdCombinedOn(t *testing.T) {
+	r := newTestRunner(t)
+
+	out, err := cmd.CmdCombinedOn(context.Background(), r, nil, cmd.New("echo", "remote"))
+	if err != nil || string(out) != "remote" {
+		t.Errorf("unexpected %q %v", out, err)
+	}
+}
@@ -0,0 +1,337 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meilihao/golib/v2/cmd"
+)
+
+// The SCP protocol, used when the server has no SFTP subsystem: the source
+// sends "C<mode> <size> <name>" for a file followed by its content,
+// "D<mode> 0 <name>" and "E" around a directory, and "T<mtime> 0 <atime> 0"
+// before them with -p. The sink acks each with a null byte, or 1 (warning)
+// or 2 (error) followed by a message.
+
+// scpAck reads the reply of the other end.
+func scpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+
+	msg, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
+
+// scpSession runs scp with args on the server and calls fn with its stdin
+// and stdout.
+func (c *Client) scpSession(args string, fn func(w io.Writer, r *bufio.Reader) error) error {
+	ses, err := c.Conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer ses.Close()
+
+	w, err := ses.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := ses.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr strings.Builder
+	ses.Stderr = &stderr
+
+	if err = ses.Start("scp " + args); err != nil {
+		return err
+	}
+
+	err = fn(w, bufio.NewReader(out))
+	w.Close()
+	if werr := ses.Wait(); err == nil && werr != nil {
+		err = fmt.Errorf("scp: %v: %s", werr, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+func scpFlags(mode string, opt *TransferOption, dir bool) string {
+	flags := mode
+	if dir {
+		flags += " -r"
+	}
+	if opt.Preserve {
+		flags += " -p"
+	}
+	return flags
+}
+
+// scpUpload is Upload with SCP.
+func (c *Client) scpUpload(local, remote string, fi os.FileInfo, opt *TransferOption, p *progress) error {
+	if !fi.IsDir() {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// scp -t names the file after the source if remote is a directory
+		return c.scpUploadReader(f, remote, filepath.Base(local), fi, opt, p)
+	}
+
+	// into its parent, so that an existing remote is merged, like with SFTP
+	return c.scpSession(scpFlags("-t", opt, true)+" "+cmd.Quote(path.Dir(remote)), func(w io.Writer, r *bufio.Reader) error {
+		if err := scpAck(r); err != nil {
+			return err
+		}
+		return scpSendDir(w, r, local, path.Base(remote), fi, opt, p)
+	})
+}
+
+// scpUploadReader uploads the content of src, described by fi, to remote,
+// or to name in it if it is a directory.
+func (c *Client) scpUploadReader(src io.Reader, remote, name string, fi os.FileInfo, opt *TransferOption, p *progress) error {
+	return c.scpSession(scpFlags("-t", opt, false)+" "+cmd.Quote(remote), func(w io.Writer, r *bufio.Reader) error {
+		if err := scpAck(r); err != nil {
+			return err
+		}
+		return scpSendFile(w, r, src, name, fi, opt, p)
+	})
+}
+
+func scpSendTimes(w io.Writer, r *bufio.Reader, fi os.FileInfo, opt *TransferOption) error {
+	if !opt.Preserve {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "T%d 0 %d 0\n", fi.ModTime().Unix(), fi.ModTime().Unix()); err != nil {
+		return err
+	}
+	return scpAck(r)
+}
+
+func scpSendFile(w io.Writer, r *bufio.Reader, src io.Reader, name string, fi os.FileInfo, opt *TransferOption, p *progress) error {
+	if err := scpSendTimes(w, r, fi, opt); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "C%04o %d %s\n", fi.Mode().Perm(), fi.Size(), name); err != nil {
+		return err
+	}
+	if err := scpAck(r); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(w, &progressReader{r: src, p: p}, fi.Size()); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+	return scpAck(r)
+}
+
+func scpSendDir(w io.Writer, r *bufio.Reader, local, name string, fi os.FileInfo, opt *TransferOption, p *progress) error {
+	if err := scpSendTimes(w, r, fi, opt); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "D%04o 0 %s\n", fi.Mode().Perm(), name); err != nil {
+		return err
+	}
+	if err := scpAck(r); err != nil {
+		return err
+	}
+
+	fis, err := readDir(local)
+	if err != nil {
+		return err
+	}
+	for _, child := range fis {
+		name := filepath.Join(local, child.Name())
+		switch {
+		case child.IsDir():
+			err = scpSendDir(w, r, name, child.Name(), child, opt, p)
+		case child.Mode().IsRegular():
+			err = scpSendLocalFile(w, r, name, child, opt, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err = io.WriteString(w, "E\n"); err != nil {
+		return err
+	}
+	return scpAck(r)
+}
+
+func scpSendLocalFile(w io.Writer, r *bufio.Reader, name string, fi os.FileInfo, opt *TransferOption, p *progress) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return scpSendFile(w, r, f, fi.Name(), fi, opt, p)
+}
+
+// scpDownload is Download with SCP. The total of the progress grows as the
+// files are announced.
+func (c *Client) scpDownload(remote, local string, opt *TransferOption) error {
+	p := newProgress(opt, 0)
+
+	// -r is harmless for a file
+	return c.scpSession(scpFlags("-f", opt, true)+" "+cmd.Quote(remote), func(w io.Writer, r *bufio.Reader) error {
+		ok := func() error {
+			_, err := w.Write([]byte{0})
+			return err
+		}
+		if err := ok(); err != nil {
+			return err
+		}
+
+		// the directories being received, local first
+		dirs := []string{}
+		var times *time.Time
+		var dirTimes []*time.Time
+		for {
+			line, err := r.ReadString('\n')
+			if err == io.EOF && line == "" {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if line[0] == 1 || line[0] == 2 {
+				return fmt.Errorf("scp: %s", strings.TrimSpace(line[1:]))
+			}
+			line = strings.TrimSuffix(line, "\n")
+
+			switch line[0] {
+			case 'T':
+				var mtime, atime int64
+				if _, err = fmt.Sscanf(line, "T%d 0 %d 0", &mtime, &atime); err != nil {
+					return fmt.Errorf("scp: bad times %q", line)
+				}
+				t := time.Unix(mtime, 0)
+				times = &t
+			case 'C', 'D':
+				mode, size, name, err := scpParseHeader(line)
+				if err != nil {
+					return err
+				}
+				dst := scpTarget(local, dirs, name, line[0] == 'D')
+
+				if line[0] == 'D' {
+					if err = os.MkdirAll(dst, 0755); err != nil {
+						return err
+					}
+					dirs = append(dirs, dst)
+					dirTimes = append(dirTimes, times)
+					times = nil
+					if opt.Preserve {
+						if err = os.Chmod(dst, mode); err != nil {
+							return err
+						}
+					}
+					break
+				}
+
+				if err = ok(); err != nil {
+					return err
+				}
+				p.total += size
+				if err = scpReceiveFile(r, dst, mode, size, p); err != nil {
+					return err
+				}
+				if err = scpAck(r); err != nil {
+					return err
+				}
+				if opt.Preserve {
+					if err = os.Chmod(dst, mode); err != nil {
+						return err
+					}
+					if times != nil {
+						if err = os.Chtimes(dst, *times, *times); err != nil {
+							return err
+						}
+					}
+				}
+				times = nil
+			case 'E':
+				if len(dirs) == 0 {
+					return errors.New("scp: unexpected end of directory")
+				}
+				dir, t := dirs[len(dirs)-1], dirTimes[len(dirTimes)-1]
+				dirs, dirTimes = dirs[:len(dirs)-1], dirTimes[:len(dirTimes)-1]
+				if opt.Preserve && t != nil {
+					if err = os.Chtimes(dir, *t, *t); err != nil {
+						return err
+					}
+				}
+			default:
+				return fmt.Errorf("scp: unexpected %q", line)
+			}
+
+			if err = ok(); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// scpTarget returns the local path of an entry name: in the current
+// directory, else local itself, unless a file is received into an existing
+// directory.
+func scpTarget(local string, dirs []string, name string, dir bool) string {
+	if len(dirs) > 0 {
+		return filepath.Join(dirs[len(dirs)-1], name)
+	}
+	if fi, err := os.Stat(local); err == nil && fi.IsDir() && !dir {
+		return filepath.Join(local, name)
+	}
+	return local
+}
+
+// scpParseHeader parses "C0644 12 name" or "D0755 0 name".
+func scpParseHeader(line string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(line[1:], " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("scp: bad header %q", line)
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("scp: bad mode %q", line)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("scp: bad size %q", line)
+	}
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", fmt.Errorf("scp: bad name %q", name)
+	}
+	return os.FileMode(mode).Perm(), size, name, nil
+}
+
+func scpReceiveFile(r *bufio.Reader, dst string, mode os.FileMode, size int64, p *progress) error {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(&progressWriter{w: f, p: p}, r, size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,443 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testUser     = "test"
+	testPassword = "secret"
+	// hangHost is a direct-tcpip destination the server never answers
+	hangHost = "hang.invalid"
+)
+
+// testServer is an in-process SSH server running exec requests with bash,
+// the usual login shell.
+type testServer struct {
+	Addr    string
+	Host    string
+	Port    int
+	HostKey ssh.Signer
+
+	config *ssh.ServerConfig
+	noSFTP bool
+	ln     net.Listener
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	// accepted is the number of connections accepted
+	accepted int
+	// window is the last terminal size, cols and rows, of a window-change
+	window [2]uint32
+}
+
+// withoutSFTP makes the server refuse the SFTP subsystem.
+func withoutSFTP(s *testServer) {
+	s.noSFTP = true
+}
+
+// newTestServer starts a server accepting testUser with testPassword unless
+// config says otherwise.
+func newTestServer(t testing.TB, config *ssh.ServerConfig, opts ...func(*testServer)) *testServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config == nil {
+		config = testServerConfig()
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+
+	s := &testServer{
+		Addr:    addr.String(),
+		Host:    addr.IP.String(),
+		Port:    addr.Port,
+		HostKey: hostKey,
+		config:  config,
+		ln:      ln,
+		conns:   map[net.Conn]bool{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// testServerConfig returns the default config of the test server.
+func testServerConfig() *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == testUser && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+}
+
+// clientConfig returns a ClientConfig for the server.
+func (s *testServer) clientConfig() *ClientConfig {
+	return &ClientConfig{
+		User:         testUser,
+		Host:         s.Host,
+		Port:         s.Port,
+		Password:     testPassword,
+		DisableAgent: true,
+	}
+}
+
+// newStalledServer returns the ClientConfig of a server that accepts the
+// connections but never answers the handshake.
+func newStalledServer(t testing.TB) *ClientConfig {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return &ClientConfig{User: testUser, Host: addr.IP.String(), Port: addr.Port, Password: testPassword, DisableAgent: true}
+}
+
+func (s *testServer) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *testServer) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.accepted++
+		s.mu.Unlock()
+		go s.handleConn(conn)
+	}
+}
+
+// dropConns closes the connections, like a restarted server.
+func (s *testServer) dropConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+func (s *testServer) windowSize() [2]uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.window
+}
+
+func (s *testServer) dials() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.accepted
+}
+
+func (s *testServer) handleConn(conn net.Conn) {
+	sc, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go handleGlobal(sc, reqs)
+
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				continue
+			}
+			go s.handleSession(ch, reqs)
+		case "direct-tcpip":
+			go handleDirect(nc)
+		default:
+			nc.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+// handleGlobal serves the tcpip-forward requests of ssh -R, other requests,
+// e.g. keepalives, are refused.
+func handleGlobal(sc *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	listeners := map[string]net.Listener{}
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+
+	for req := range reqs {
+		var payload struct {
+			Addr string
+			Port uint32
+		}
+		if (req.Type != "tcpip-forward" && req.Type != "cancel-tcpip-forward") || ssh.Unmarshal(req.Payload, &payload) != nil {
+			req.Reply(false, nil)
+			continue
+		}
+
+		addr := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+		if req.Type == "cancel-tcpip-forward" {
+			if ln := listeners[addr]; ln != nil {
+				ln.Close()
+				delete(listeners, addr)
+			}
+			req.Reply(true, nil)
+			continue
+		}
+
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		port := uint32(ln.Addr().(*net.TCPAddr).Port)
+		listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = ln
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				orig := conn.RemoteAddr().(*net.TCPAddr)
+				ch, creqs, err := sc.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+					Addr     string
+					Port     uint32
+					OrigAddr string
+					OrigPort uint32
+				}{payload.Addr, port, orig.IP.String(), uint32(orig.Port)}))
+				if err != nil {
+					conn.Close()
+					continue
+				}
+				go ssh.DiscardRequests(creqs)
+				go pipe(ch, conn)
+			}
+		}()
+	}
+}
+
+// handleDirect connects a direct-tcpip channel, opened by ssh -W or a jump,
+// to its address.
+func handleDirect(nc ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &payload); err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	if payload.Host == hangHost {
+		// never answered, the dial blocks until the connection is closed
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	pipe(ch, conn)
+}
+
+// handleSession runs the exec request of a session, killing its process
+// group on a signal request or when the session is closed, or serves SFTP.
+func (s *testServer) handleSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	var cmd *exec.Cmd
+	var env []string
+	done := make(chan struct{})
+
+	for req := range reqs {
+		switch req.Type {
+		case "exec", "shell":
+			if cmd != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			// the shell reads its commands from stdin, without a real
+			// terminal
+			var payload struct{ Command string }
+			if req.Type == "exec" && ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+
+			cmd = exec.Command("bash", "-c", payload.Command)
+			if req.Type == "shell" {
+				cmd = exec.Command("bash")
+			}
+			cmd.Env = append(os.Environ(), env...)
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			cmd.Stdout = ch
+			cmd.Stderr = ch.Stderr()
+			stdin, _ := cmd.StdinPipe()
+			if err := cmd.Start(); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			go func() {
+				// not waited for, the client may never close stdin
+				io.Copy(stdin, ch)
+				stdin.Close()
+			}()
+			go func() {
+				defer close(done)
+				exitStatus(ch, cmd)
+			}()
+		case "pty-req":
+			var payload struct {
+				Term             string
+				Cols, Rows, W, H uint32
+				Modes            string
+			}
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				env = append(env, "TERM="+payload.Term, fmt.Sprintf("COLUMNS=%d", payload.Cols), fmt.Sprintf("LINES=%d", payload.Rows))
+			}
+			req.Reply(true, nil)
+		case "env":
+			var payload struct{ Name, Value string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				env = append(env, payload.Name+"="+payload.Value)
+			}
+			req.Reply(true, nil)
+		case "window-change":
+			var payload struct{ Cols, Rows, W, H uint32 }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				s.mu.Lock()
+				s.window = [2]uint32{payload.Cols, payload.Rows}
+				s.mu.Unlock()
+			}
+		case "subsystem":
+			var payload struct{ Name string }
+			if s.noSFTP || ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			go func() {
+				if server, err := sftp.NewServer(ch); err == nil {
+					server.Serve()
+				}
+				ch.Close()
+			}()
+		case "signal":
+			var payload struct{ Signal string }
+			if cmd != nil && ssh.Unmarshal(req.Payload, &payload) == nil {
+				if sig, ok := signals[payload.Signal]; ok {
+					syscall.Kill(-cmd.Process.Pid, sig)
+				}
+			}
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+
+	// the client closed the session
+	if cmd != nil {
+		select {
+		case <-done:
+		default:
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			<-done
+		}
+	}
+	ch.Close()
+}
+
+// exitStatus waits for cmd and sends its exit status, then closes ch.
+func exitStatus(ch ssh.Channel, cmd *exec.Cmd) {
+	cmd.Wait()
+	ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if ws.Signaled() {
+		name := strconv.Itoa(int(ws.Signal()))
+		for k, v := range signals {
+			if v == ws.Signal() {
+				name = k
+			}
+		}
+		ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+			Signal     string
+			CoreDumped bool
+			Error      string
+			Lang       string
+		}{Signal: name}))
+	} else {
+		status := make([]byte, 4)
+		binary.BigEndian.PutUint32(status, uint32(ws.ExitStatus()))
+		ch.SendRequest("exit-status", false, status)
+	}
+	ch.Close()
+}
@@ -0,0 +1,327 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/meilihao/golib/v2/log"
+	wsc "github.com/meilihao/golib/v2/ws"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
+)
+
+// The types of the ws.Message sent as text by the browser to a shell served
+// by ServeWS, binary messages are input.
+const (
+	// WSShellInput has a JSON string of input.
+	WSShellInput int64 = 1
+	// WSShellResize has the JSON {"cols": 120, "rows": 40}.
+	WSShellResize int64 = 2
+)
+
+// ShellOption configures Shell.
+type ShellOption struct {
+	// Term is the terminal type, "xterm-256color" by default.
+	Term string
+	// Cols and Rows are the initial size of the terminal, 80x24 by default.
+	Cols, Rows int
+	// Modes are the terminal modes, echo on by default.
+	Modes ssh.TerminalModes
+	// Env is set in the shell if the server accepts it, see AcceptEnv of
+	// sshd_config.
+	Env map[string]string
+	// Command is run with the terminal instead of the login shell.
+	Command string
+}
+
+// Shell is an interactive session with a terminal. Its Read returns the
+// output and its Write sends input.
+type Shell struct {
+	ses   *ssh.Session
+	stdin io.WriteCloser
+	out   *io.PipeReader
+
+	done chan struct{}
+	err  error
+}
+
+// Shell starts the login shell of the user, or opt.Command, with a
+// terminal. opt may be nil.
+func (c *Client) Shell(opt *ShellOption) (*Shell, error) {
+	if opt == nil {
+		opt = &ShellOption{}
+	}
+	term, cols, rows, modes := opt.Term, opt.Cols, opt.Rows, opt.Modes
+	if term == "" {
+		term = "xterm-256color"
+	}
+	if cols == 0 || rows == 0 {
+		cols, rows = 80, 24
+	}
+	if modes == nil {
+		modes = ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+	}
+
+	ses, err := c.Conn.NewSession()
+	if err != nil {
+		return nil, &sessionError{err}
+	}
+	for k, v := range opt.Env {
+		if err = ses.Setenv(k, v); err != nil {
+			log.Glog.Debug("ssh shell env refused", zap.String("host", c.Conf.Host), zap.String("name", k))
+		}
+	}
+	if err = ses.RequestPty(term, rows, cols, modes); err != nil {
+		ses.Close()
+		return nil, err
+	}
+
+	stdin, err := ses.StdinPipe()
+	if err != nil {
+		ses.Close()
+		return nil, err
+	}
+	// the terminal merges stderr into stdout, unless the server has none
+	pr, pw := io.Pipe()
+	ses.Stdout, ses.Stderr = pw, pw
+
+	if opt.Command != "" {
+		err = ses.Start(opt.Command)
+	} else {
+		err = ses.Shell()
+	}
+	if err != nil {
+		ses.Close()
+		return nil, err
+	}
+
+	sh := &Shell{ses: ses, stdin: stdin, out: pr, done: make(chan struct{})}
+	go func() {
+		sh.err = ses.Wait()
+		pw.Close()
+		close(sh.done)
+	}()
+
+	return sh, nil
+}
+
+// Read reads the output of the shell, it returns io.EOF once the shell
+// exited.
+func (sh *Shell) Read(b []byte) (int, error) {
+	return sh.out.Read(b)
+}
+
+// Write sends input to the shell.
+func (sh *Shell) Write(b []byte) (int, error) {
+	return sh.stdin.Write(b)
+}
+
+// Resize changes the size of the terminal, cols and rows must be positive.
+func (sh *Shell) Resize(cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return fmt.Errorf("ssh: bad terminal size %dx%d", cols, rows)
+	}
+	return sh.ses.WindowChange(rows, cols)
+}
+
+// Signal sends sig to the shell.
+func (sh *Shell) Signal(sig ssh.Signal) error {
+	return sh.ses.Signal(sig)
+}
+
+// Done is closed once the shell exited.
+func (sh *Shell) Done() <-chan struct{} {
+	return sh.done
+}
+
+// Wait waits for the shell to exit, a non zero exit status is an
+// *ssh.ExitError.
+func (sh *Shell) Wait() error {
+	<-sh.done
+	return sh.err
+}
+
+// Close closes the session, ending the shell.
+func (sh *Shell) Close() error {
+	err := sh.ses.Close()
+	if errors.Is(err, io.EOF) {
+		// already closed by the server
+		err = nil
+	}
+	return err
+}
+
+// ServeWS bridges the shell to the websocket of wc, e.g. a browser
+// terminal, until either ends, then closes the other. The output is sent as
+// binary messages. wc must not be read or written by anything else, e.g.
+// Hub.Serve. It returns the error of the shell, or of the websocket.
+func (sh *Shell) ServeWS(wc *wsc.Client) error {
+	w := &wsWriter{w: wc.Conn}
+	write := func(op ws.OpCode, b []byte) error {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		return wsutil.WriteServerMessage(w.w, op, b)
+	}
+
+	out := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := sh.Read(buf)
+			if n > 0 {
+				if werr := write(ws.OpBinary, buf[:n]); werr != nil {
+					out <- werr
+					return
+				}
+			}
+			if err != nil {
+				out <- nil
+				return
+			}
+		}
+	}()
+	in := make(chan error, 1)
+	go func() {
+		in <- sh.serveInput(wc, w)
+	}()
+
+	select {
+	case err := <-out:
+		if err == nil {
+			err = sh.Wait()
+			write(ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, exitReason(err)))
+		}
+		sh.Close()
+		wc.Conn.Close()
+		<-in
+		return err
+	case err := <-in:
+		sh.Close()
+		// a pending write must not block
+		wc.Conn.Close()
+		<-out
+		return err
+	}
+}
+
+// wsWriter serializes the writes to a websocket: the messages of ServeWS and
+// the replies to the control frames read by serveInput.
+type wsWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *wsWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(b)
+}
+
+// serveInput passes the messages of wc to the shell, it returns nil when the
+// websocket is closed. Pings and close are replied through w, like
+// wsutil.ReadClientData.
+func (sh *Shell) serveInput(wc *wsc.Client, w *wsWriter) error {
+	control := wsutil.ControlFrameHandler(w, ws.StateServerSide)
+	rd := &wsutil.Reader{
+		Source:         wc.Conn,
+		State:          ws.StateServerSide,
+		CheckUTF8:      true,
+		OnIntermediate: control,
+	}
+
+	for {
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			return readError(err)
+		}
+		if hdr.OpCode.IsControl() {
+			if err = control(hdr, rd); err != nil {
+				return readError(err)
+			}
+			continue
+		}
+		data, err := io.ReadAll(rd)
+		if err != nil {
+			return readError(err)
+		}
+
+		if hdr.OpCode == ws.OpBinary {
+			if _, err = sh.Write(data); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var m wsc.Message
+		if err = json.Unmarshal(data, &m); err != nil {
+			log.Glog.Warn("ssh shell bad message", zap.String("id", wc.Id), zap.Error(err))
+			continue
+		}
+		switch m.Type {
+		case WSShellInput:
+			var s string
+			if err = json.Unmarshal(m.Raw, &s); err == nil {
+				_, err = io.WriteString(sh, s)
+			}
+		case WSShellResize:
+			var size struct {
+				Cols int `json:"cols"`
+				Rows int `json:"rows"`
+			}
+			if err = json.Unmarshal(m.Raw, &size); err == nil {
+				err = sh.Resize(size.Cols, size.Rows)
+			}
+		default:
+			err = fmt.Errorf("ssh: unknown shell message type %d", m.Type)
+		}
+		if err != nil {
+			log.Glog.Warn("ssh shell message", zap.String("id", wc.Id), zap.Int64("type", m.Type), zap.Error(err))
+		}
+	}
+}
+
+// readError returns err, nil if the websocket was closed.
+func readError(err error) error {
+	var closed wsutil.ClosedError
+	if errors.As(err, &closed) || errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// exitReason returns the reason of the close frame sent when the shell
+// exited with err.
+func exitReason(err error) string {
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return "exit status 0"
+	case errors.As(err, &exitErr):
+		if exitErr.Signal() != "" {
+			return "signal " + exitErr.Signal()
+		}
+		return fmt.Sprintf("exit status %d", exitErr.ExitStatus())
+	}
+
+	// the limit of a control frame
+	reason := err.Error()
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return reason
+}
@@ -0,0 +1,197 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	wsc "github.com/meilihao/golib/v2/ws"
+	"golang.org/x/crypto/ssh"
+)
+
+// readUntil reads r until a line has want.
+func readUntil(t *testing.T, r *bufio.Reader, want string) {
+	t.Helper()
+
+	for {
+		line, err := r.ReadString('\n')
+		if strings.Contains(line, want) {
+			return
+		}
+		if err != nil {
+			t.Fatalf("%q not found: %v", want, err)
+		}
+	}
+}
+
+func TestShell(t *testing.T) {
+	s := newTestServer(t, nil)
+	c, err := NewClient(s.clientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sh, err := c.Shell(&ShellOption{Cols: 100, Rows: 30, Env: map[string]string{"FOO": "bar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.Close()
+	out := bufio.NewReader(sh)
+
+	io.WriteString(sh, "echo $TERM $COLUMNS $LINES $FOO\n")
+	readUntil(t, out, "xterm-256color 100 30 bar")
+
+	if err = sh.Resize(0, 40); err == nil {
+		t.Error("expected an error for 0 columns")
+	}
+	if err = sh.Resize(120, 40); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the window change", func() bool { return s.windowSize() == [2]uint32{120, 40} })
+
+	io.WriteString(sh, "exit 3\n")
+	var exitErr *ssh.ExitError
+	if err = sh.Wait(); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+	if _, err = out.ReadByte(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+// wsFrames returns the binary payloads sent by the server on conn, and the
+// reason of its close frame.
+func wsFrames(conn net.Conn) (<-chan string, <-chan string) {
+	data, reason := make(chan string, 100), make(chan string, 1)
+	go func() {
+		defer close(data)
+		for {
+			f, err := ws.ReadFrame(conn)
+			if err != nil {
+				return
+			}
+			switch f.Header.OpCode {
+			case ws.OpBinary:
+				data <- string(f.Payload)
+			case ws.OpClose:
+				_, r := ws.ParseCloseFrameData(f.Payload)
+				reason <- r
+				return
+			}
+		}
+	}()
+	return data, reason
+}
+
+func TestShellServeWS(t *testing.T) {
+	s := newTestServer(t, nil)
+	c, err := NewClient(s.clientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sh, err := c.Shell(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, browser := net.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sh.ServeWS(&wsc.Client{Id: "term", Conn: server})
+	}()
+	data, reason := wsFrames(browser)
+
+	wsutil.WriteClientMessage(browser, ws.OpBinary, []byte("echo hi from $TERM\n"))
+	for d := range data {
+		if strings.Contains(d, "hi from xterm-256color") {
+			break
+		}
+	}
+
+	wsutil.WriteClientMessage(browser, ws.OpText, []byte(`{"type":2,"raw":{"cols":132,"rows":43}}`))
+	waitFor(t, "the window change", func() bool { return s.windowSize() == [2]uint32{132, 43} })
+
+	wsutil.WriteClientMessage(browser, ws.OpText, []byte(`{"type":1,"raw":"exit 4\n"}`))
+	if r := <-reason; r != "exit status 4" {
+		t.Errorf("unexpected close reason %q", r)
+	}
+	var exitErr *ssh.ExitError
+	if err = <-errc; !errors.As(err, &exitErr) || exitErr.ExitStatus() != 4 {
+		t.Errorf("expected exit status 4, got %v", err)
+	}
+}
+
+func TestShellServeWSClose(t *testing.T) {
+	c := newTestClient(t)
+	sh, err := c.Shell(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, browser := net.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sh.ServeWS(&wsc.Client{Id: "term", Conn: server})
+	}()
+	wsFrames(browser)
+
+	// the browser leaves
+	wsutil.WriteClientMessage(browser, ws.OpClose, ws.NewCloseFrameBody(ws.StatusGoingAway, ""))
+	if err = <-errc; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	<-sh.Done()
+}
+
+func TestShellServeWSPing(t *testing.T) {
+	c := newTestClient(t)
+	sh, err := c.Shell(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, browser := net.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sh.ServeWS(&wsc.Client{Id: "term", Conn: server})
+	}()
+
+	// the pongs are written while the output is
+	wsutil.WriteClientMessage(browser, ws.OpBinary, []byte("seq 1 20000\n"))
+	go func() {
+		for i := 0; i < 20; i++ {
+			ws.WriteFrame(browser, ws.MaskFrame(ws.NewPingFrame([]byte("ping"))))
+		}
+	}()
+
+	var pongs int
+	for pongs < 20 {
+		f, err := ws.ReadFrame(browser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f.Header.OpCode {
+		case ws.OpBinary:
+		case ws.OpPong:
+			if string(f.Payload) != "ping" {
+				t.Fatalf("unexpected pong %q", f.Payload)
+			}
+			pongs++
+		default:
+			t.Fatalf("unexpected frame %v", f.Header.OpCode)
+		}
+	}
+
+	wsFrames(browser)
+	wsutil.WriteClientMessage(browser, ws.OpClose, ws.NewCloseFrameBody(ws.StatusGoingAway, ""))
+	if err = <-errc; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,530 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meilihao/golib/v2/cmd"
+	"github.com/meilihao/golib/v2/log"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoSFTP = errors.New("ssh: sftp subsystem refused")
+)
+
+// TransferOption configures Upload and Download.
+type TransferOption struct {
+	// Progress is called with the bytes transferred so far and the total.
+	Progress func(done, total int64)
+	// Resume continues a previous transfer when the destination is shorter
+	// than the source, assuming it is a partial copy. Files already complete
+	// are skipped. Not supported by SCP.
+	Resume bool
+	// Preserve sets the permissions and modification time of the
+	// destination to the ones of the source.
+	Preserve bool
+}
+
+// progress counts the bytes of a transfer.
+type progress struct {
+	fn          func(done, total int64)
+	done, total int64
+}
+
+func newProgress(opt *TransferOption, total int64) *progress {
+	return &progress{fn: opt.Progress, total: total}
+}
+
+func (p *progress) add(n int64) {
+	if n == 0 {
+		return
+	}
+	p.done += n
+	if p.fn != nil {
+		p.fn(p.done, p.total)
+	}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.add(int64(n))
+	return n, err
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.p.add(int64(n))
+	return n, err
+}
+
+// SFTP returns the SFTP client of c, opened on first use and closed by Close.
+// It returns an error matching ErrNoSFTP if the server refuses the sftp
+// subsystem, then the callers fall back to SCP or a command.
+func (c *Client) SFTP() (*sftp.Client, error) {
+	c.sftpMu.Lock()
+	defer c.sftpMu.Unlock()
+
+	if c.sftp != nil {
+		return c.sftp, nil
+	}
+	if c.noSFTP != nil {
+		return nil, c.noSFTP
+	}
+
+	ses, err := c.Conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	if err = ses.RequestSubsystem("sftp"); err != nil {
+		ses.Close()
+		// don't ask again for a missing subsystem
+		c.noSFTP = fmt.Errorf("%w: %v", ErrNoSFTP, err)
+		log.Glog.Debug("ssh sftp unavailable, fallback to scp", zap.String("host", c.Conf.Host), zap.Error(err))
+		return nil, c.noSFTP
+	}
+	pw, err := ses.StdinPipe()
+	if err != nil {
+		ses.Close()
+		return nil, err
+	}
+	pr, err := ses.StdoutPipe()
+	if err != nil {
+		ses.Close()
+		return nil, err
+	}
+	sc, err := sftp.NewClientPipe(pr, pw)
+	if err != nil {
+		ses.Close()
+		return nil, err
+	}
+	c.sftp = sc
+	return sc, nil
+}
+
+// Upload copies the local file or directory, recursively, to remote, or a
+// file into remote if it is an existing directory. A directory is merged into
+// an existing one. Without SFTP on the server, it falls back to SCP.
+func (c *Client) Upload(local, remote string, opt *TransferOption) error {
+	if opt == nil {
+		opt = &TransferOption{}
+	}
+
+	fi, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	total, err := localSize(local, fi)
+	if err != nil {
+		return err
+	}
+	p := newProgress(opt, total)
+
+	sc, err := c.SFTP()
+	if errors.Is(err, ErrNoSFTP) {
+		return c.scpUpload(local, remote, fi, opt, p)
+	} else if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		if st, err := sc.Stat(remote); err == nil && st.IsDir() {
+			remote = path.Join(remote, filepath.Base(local))
+		}
+		return uploadFile(sc, local, remote, fi, opt, p)
+	}
+
+	var dirs []string
+	err = filepath.Walk(local, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(local, name)
+		if err != nil {
+			return err
+		}
+		dst := path.Join(remote, filepath.ToSlash(rel))
+
+		switch {
+		case fi.IsDir():
+			dirs = append(dirs, name)
+			return sc.MkdirAll(dst)
+		case fi.Mode().IsRegular():
+			return uploadFile(sc, name, dst, fi, opt, p)
+		}
+		return nil
+	})
+	if err != nil || !opt.Preserve {
+		return err
+	}
+
+	// after their files, which change their mtime, children first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fi, err := os.Stat(dirs[i])
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(local, dirs[i])
+		if err = preserveRemote(sc, path.Join(remote, filepath.ToSlash(rel)), fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uploadFile(sc *sftp.Client, local, remote string, fi os.FileInfo, opt *TransferOption, p *progress) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var offset int64
+	if opt.Resume {
+		if st, err := sc.Stat(remote); err == nil && st.Size() <= fi.Size() {
+			offset = st.Size()
+		}
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dst, err := sc.OpenFile(remote, flags)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if offset > 0 {
+		if _, err = src.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err = dst.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		p.add(offset)
+	}
+	if _, err = io.Copy(dst, &progressReader{r: src, p: p}); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	if opt.Preserve {
+		return preserveRemote(sc, remote, fi)
+	}
+	return nil
+}
+
+func preserveRemote(sc *sftp.Client, remote string, fi os.FileInfo) error {
+	if err := sc.Chmod(remote, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return sc.Chtimes(remote, fi.ModTime(), fi.ModTime())
+}
+
+// localSize returns the size of the regular files of the file or directory
+// name.
+func localSize(name string, fi os.FileInfo) (int64, error) {
+	if !fi.IsDir() {
+		return fi.Size(), nil
+	}
+
+	var total int64
+	err := filepath.Walk(name, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+		return err
+	})
+	return total, err
+}
+
+// Download copies the remote file or directory, recursively, to local, like
+// Upload. Without SFTP on the server, it falls back to SCP.
+func (c *Client) Download(remote, local string, opt *TransferOption) error {
+	if opt == nil {
+		opt = &TransferOption{}
+	}
+
+	sc, err := c.SFTP()
+	if errors.Is(err, ErrNoSFTP) {
+		return c.scpDownload(remote, local, opt)
+	} else if err != nil {
+		return err
+	}
+
+	fi, err := sc.Stat(remote)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		if st, err := os.Stat(local); err == nil && st.IsDir() {
+			local = filepath.Join(local, path.Base(remote))
+		}
+		return downloadFile(sc, remote, local, fi, opt, newProgress(opt, fi.Size()))
+	}
+
+	type entry struct {
+		remote, local string
+		fi            os.FileInfo
+	}
+	var files, dirs []entry
+	var total int64
+	w := sc.Walk(remote)
+	for w.Step() {
+		if err = w.Err(); err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(w.Path(), remote), "/")
+		e := entry{remote: w.Path(), local: filepath.Join(local, filepath.FromSlash(rel)), fi: w.Stat()}
+
+		switch {
+		case e.fi.IsDir():
+			if err = os.MkdirAll(e.local, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, e)
+		case e.fi.Mode().IsRegular():
+			files = append(files, e)
+			total += e.fi.Size()
+		}
+	}
+
+	p := newProgress(opt, total)
+	for _, e := range files {
+		if err = downloadFile(sc, e.remote, e.local, e.fi, opt, p); err != nil {
+			return err
+		}
+	}
+	if opt.Preserve {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if err = preserveLocal(dirs[i].local, dirs[i].fi); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func downloadFile(sc *sftp.Client, remote, local string, fi os.FileInfo, opt *TransferOption, p *progress) error {
+	src, err := sc.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var offset int64
+	if opt.Resume {
+		if st, err := os.Stat(local); err == nil && st.Size() <= fi.Size() {
+			offset = st.Size()
+		}
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dst, err := os.OpenFile(local, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if offset > 0 {
+		if _, err = src.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err = dst.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		p.add(offset)
+	}
+	if _, err = io.Copy(&progressWriter{w: dst, p: p}, src); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	if opt.Preserve {
+		return preserveLocal(local, fi)
+	}
+	return nil
+}
+
+func preserveLocal(local string, fi os.FileInfo) error {
+	if err := os.Chmod(local, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(local, fi.ModTime(), fi.ModTime())
+}
+
+// WriteFile writes data to the remote file name with perm, like
+// os.WriteFile.
+func (c *Client) WriteFile(name string, data []byte, perm os.FileMode) error {
+	sc, err := c.SFTP()
+	if errors.Is(err, ErrNoSFTP) {
+		fi := &fileInfo{name: path.Base(name), size: int64(len(data)), mode: perm, modTime: time.Now()}
+		return c.scpUploadReader(bytes.NewReader(data), name, fi.name, fi, &TransferOption{Preserve: true}, newProgress(&TransferOption{}, fi.size))
+	} else if err != nil {
+		return err
+	}
+
+	f, err := sc.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return sc.Chmod(name, perm)
+}
+
+// Stat returns the FileInfo of the remote file name, following symlinks.
+func (c *Client) Stat(name string) (os.FileInfo, error) {
+	sc, err := c.SFTP()
+	if err == nil {
+		return sc.Stat(name)
+	} else if !errors.Is(err, ErrNoSFTP) {
+		return nil, err
+	}
+
+	out, err := cmd.CmdCombinedOn(context.TODO(), NewRunner(c), &cmd.Option{IgnoreErr: true}, cmd.New("stat", "-L", "-c", "%s %f %Y", name))
+	if err != nil {
+		if cmd.ExitCode(err) == 1 {
+			return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+		}
+		return nil, err
+	}
+	return parseStat(name, string(out))
+}
+
+// parseStat parses the output of `stat -c "%s %f %Y"`: size, raw mode in
+// hex and mtime.
+func parseStat(name, out string) (os.FileInfo, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("ssh: unexpected stat output %q", out)
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	mtime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{
+		name:    path.Base(name),
+		size:    size,
+		mode:    fileMode(uint32(raw)),
+		modTime: time.Unix(mtime, 0),
+	}, nil
+}
+
+// fileMode converts a unix st_mode to an os.FileMode.
+func fileMode(raw uint32) os.FileMode {
+	mode := os.FileMode(raw & 0777)
+	switch raw & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0010000:
+		mode |= os.ModeNamedPipe
+	case 0140000:
+		mode |= os.ModeSocket
+	case 0020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		mode |= os.ModeDevice
+	}
+	if raw&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if raw&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if raw&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// MkdirAll creates the remote directory name and its parents.
+func (c *Client) MkdirAll(name string) error {
+	sc, err := c.SFTP()
+	if err == nil {
+		return sc.MkdirAll(name)
+	} else if !errors.Is(err, ErrNoSFTP) {
+		return err
+	}
+
+	_, err = cmd.CmdCombinedOn(context.TODO(), NewRunner(c), nil, cmd.New("mkdir", "-p", name))
+	return err
+}
+
+// fileInfo is an os.FileInfo of a remote file.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// readDir returns the FileInfo of the entries of the local directory name,
+// sorted by name.
+func readDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fis := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
@@ -0,0 +1,202 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// transferServers are a server with SFTP and one only supporting SCP.
+var transferServers = map[string][]func(*testServer){
+	"sftp": nil,
+	"scp":  {withoutSFTP},
+}
+
+func newTestClient(t *testing.T, opts ...func(*testServer)) *Client {
+	s := newTestServer(t, nil, opts...)
+	c, err := NewClient(s.clientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+func writeTestFile(t *testing.T, name string, data []byte, perm os.FileMode, mtime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(name, perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertFile(t *testing.T, name string, data []byte, perm os.FileMode, mtime time.Time) {
+	t.Helper()
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, _ := os.ReadFile(name); !bytes.Equal(got, data) {
+		t.Errorf("%s: unexpected content %q", name, got)
+	}
+	if fi.Mode().Perm() != perm {
+		t.Errorf("%s: expected mode %v, got %v", name, perm, fi.Mode().Perm())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("%s: expected mtime %v, got %v", name, mtime, fi.ModTime())
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+
+	for name, opts := range transferServers {
+		t.Run(name, func(t *testing.T) {
+			c := newTestClient(t, opts...)
+			dir := t.TempDir()
+
+			src := filepath.Join(dir, "src")
+			writeTestFile(t, filepath.Join(src, "a.iso"), data, 0640, mtime)
+			writeTestFile(t, filepath.Join(src, "conf", "b.conf"), []byte("b=1\n"), 0600, mtime)
+			if err := os.Chtimes(filepath.Join(src, "conf"), mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			var done, total int64
+			opt := &TransferOption{Preserve: true, Progress: func(d, t int64) { done, total = d, t }}
+
+			// a file
+			remote := filepath.Join(dir, "remote")
+			if err := c.MkdirAll(remote); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Upload(filepath.Join(src, "a.iso"), remote, opt); err != nil {
+				t.Fatal(err)
+			}
+			assertFile(t, filepath.Join(remote, "a.iso"), data, 0640, mtime)
+			if done != int64(len(data)) || total != int64(len(data)) {
+				t.Errorf("unexpected progress %d/%d", done, total)
+			}
+
+			// a directory
+			if err := c.Upload(src, filepath.Join(remote, "tree"), opt); err != nil {
+				t.Fatal(err)
+			}
+			assertFile(t, filepath.Join(remote, "tree", "conf", "b.conf"), []byte("b=1\n"), 0600, mtime)
+			if fi, err := os.Stat(filepath.Join(remote, "tree", "conf")); err != nil || !fi.ModTime().Equal(mtime) {
+				t.Errorf("expected the directory mtime to be preserved, got %v", err)
+			}
+
+			// and back
+			back := filepath.Join(dir, "back")
+			if err := c.Download(filepath.Join(remote, "tree"), back, opt); err != nil {
+				t.Fatal(err)
+			}
+			assertFile(t, filepath.Join(back, "a.iso"), data, 0640, mtime)
+			assertFile(t, filepath.Join(back, "conf", "b.conf"), []byte("b=1\n"), 0600, mtime)
+			if done != total || total != int64(len(data)+4) {
+				t.Errorf("unexpected progress %d/%d", done, total)
+			}
+
+			if err := c.Download(filepath.Join(remote, "a.iso"), dir, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(filepath.Join(dir, "a.iso")); !bytes.Equal(got, data) {
+				t.Error("unexpected content of the downloaded file")
+			}
+		})
+	}
+}
+
+func TestSFTPErrors(t *testing.T) {
+	c := newTestClient(t, withoutSFTP)
+	if _, err := c.SFTP(); !errors.Is(err, ErrNoSFTP) {
+		t.Errorf("expected ErrNoSFTP, got %v", err)
+	}
+
+	// an error other than a refused subsystem is not cached
+	c = newTestClient(t)
+	c.Conn.Close()
+	if _, err := c.SFTP(); err == nil || errors.Is(err, ErrNoSFTP) {
+		t.Errorf("expected a session error, got %v", err)
+	}
+	if c.noSFTP != nil {
+		t.Errorf("expected the error not to be cached, got %v", c.noSFTP)
+	}
+}
+
+func TestWriteFileStat(t *testing.T) {
+	for name, opts := range transferServers {
+		t.Run(name, func(t *testing.T) {
+			c := newTestClient(t, opts...)
+			dir := filepath.Join(t.TempDir(), "a", "b")
+
+			if err := c.MkdirAll(dir); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := c.Stat(dir)
+			if err != nil || !fi.IsDir() {
+				t.Fatalf("expected a directory, got %v", err)
+			}
+
+			name := filepath.Join(dir, "run.sh")
+			if err = c.WriteFile(name, []byte("#!/bin/sh\n"), 0750); err != nil {
+				t.Fatal(err)
+			}
+			if fi, err = c.Stat(name); err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() != 10 || fi.Mode() != 0750 || fi.Name() != "run.sh" {
+				t.Errorf("unexpected stat %v %v %v", fi.Size(), fi.Mode(), fi.Name())
+			}
+
+			if _, err = c.Stat(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+				t.Errorf("expected a not exist error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	c := newTestClient(t)
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("x"), 1000)
+
+	local, remote := filepath.Join(dir, "local"), filepath.Join(dir, "remote")
+	if err := os.WriteFile(local, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(remote, data[:300], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var first int64 = -1
+	err := c.Upload(local, remote, &TransferOption{Resume: true, Progress: func(done, total int64) {
+		if first == -1 {
+			first = done
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(remote); !bytes.Equal(got, data) {
+		t.Errorf("unexpected content of %d bytes", len(got))
+	}
+	if first != 300 {
+		t.Errorf("expected the transfer to resume at 300, got %d", first)
+	}
+}
@@ -2,9 +2,12 @@ package sys
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync/atomic"
@@ -18,6 +21,10 @@ import (
 
 var (
 	refreshStatus atomic.Int32
+
+	// overridden by tests
+	scsiDevicesDir = "/sys/bus/scsi/devices"
+	tapeByIdDir    = "/dev/tape/by-id"
 )
 
 const (
@@ -87,8 +94,18 @@ func RefreshFc() (s int32) {
 
 // 物理带库, 可能TypeTape在前, TypeMediumx在后
 func GetMediumxs() ([]*Mediumx, error) {
-	data, err := cmd.CmdCombinedBash(nil, "lsscsi -g")
+	return GetMediumxsOn(context.TODO(), nil)
+}
+
+// GetMediumxsOn is GetMediumxs on the host of e, e.g. an ssh.Runner: the
+// files are read with commands run by e. A nil e is the local host.
+func GetMediumxsOn(ctx context.Context, e cmd.Executor) ([]*Mediumx, error) {
+	data, err := cmd.CmdCombinedOn(ctx, e, nil, cmd.New("lsscsi", "-g"))
 	if err != nil {
+		// 127: not found by the shell of a remote host
+		if errors.Is(err, exec.ErrNotFound) || cmd.ExitCode(err) == 127 {
+			return nil, fmt.Errorf("lsscsi not found: %w", err)
+		}
 		return nil, err
 	}
 
@@ -122,10 +139,10 @@ func GetMediumxs() ([]*Mediumx, error) {
 				Device: v[num-2],
 				Sg:     v[num-1],
 			}
-			tmpMediumx.Vendor = file.FileValue(filepath.Join("/sys/bus/scsi/devices", tmpMediumx.Bus, "vendor"))
-			tmpMediumx.Model = file.FileValue(filepath.Join("/sys/bus/scsi/devices", tmpMediumx.Bus, "model")) // may has space
+			tmpMediumx.Vendor = fileValueOn(ctx, e, filepath.Join(scsiDevicesDir, tmpMediumx.Bus, "vendor"))
+			tmpMediumx.Model = fileValueOn(ctx, e, filepath.Join(scsiDevicesDir, tmpMediumx.Bus, "model")) // may has space
 
-			target, err := GetMediumxTarget(tmpMediumx.Sg)
+			target, err := GetMediumxTargetOn(ctx, e, tmpMediumx.Sg)
 			if err != nil {
 				return nil, err
 			}
@@ -162,14 +179,14 @@ func GetMediumxs() ([]*Mediumx, error) {
 				return nil, fmt.Errorf("found tape(%s) with no mediumx", v[0])
 			}
 
-			tp.Vendor = file.FileValue(filepath.Join("/sys/bus/scsi/devices", tp.Bus, "vendor"))
-			tp.Model = file.FileValue(filepath.Join("/sys/bus/scsi/devices", tp.Bus, "model"))
+			tp.Vendor = fileValueOn(ctx, e, filepath.Join(scsiDevicesDir, tp.Bus, "vendor"))
+			tp.Model = fileValueOn(ctx, e, filepath.Join(scsiDevicesDir, tp.Bus, "model"))
 
 			tmpMediumx.Tapes = append(tmpMediumx.Tapes, tp)
 		}
 	}
 
-	byIds, err := TapeByIdPaths()
+	byIds, err := TapeByIdPathsOn(ctx, e)
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +209,16 @@ func GetMediumxs() ([]*Mediumx, error) {
 }
 
 func TapeByIdPaths() (map[string]string, error) {
-	base := "/dev/tape/by-id"
+	return TapeByIdPathsOn(context.TODO(), nil)
+}
+
+// TapeByIdPathsOn is TapeByIdPaths on the host of e, nil being the local host.
+func TapeByIdPathsOn(ctx context.Context, e cmd.Executor) (map[string]string, error) {
+	if e != nil {
+		return tapeByIdPathsRemote(ctx, e)
+	}
+
+	base := tapeByIdDir
 	fs, err := ioutil.ReadDir(base)
 	if err != nil {
 		return nil, err
@@ -219,8 +245,21 @@ func TapeByIdPaths() (map[string]string, error) {
 	return m, nil
 }
 
+// GetMediumxTarget returns the iSCSI or FC target of the mediumx dev, from its
+// udev ID_PATH, nil if it has none. `udevadm info | grep` runs like bash with
+// pipefail: a device without ID_PATH returns nil, but udevadm failing, e.g.
+// for an unknown device, is returned as an error.
 func GetMediumxTarget(dev string) (*TargetFrom, error) {
-	data, _ := cmd.CmdCombinedBash(&cmd.Option{IgnoreErr: true}, fmt.Sprintf("udevadm info %s | grep 'E: ID_PATH='", dev))
+	return GetMediumxTargetOn(context.TODO(), nil, dev)
+}
+
+// GetMediumxTargetOn is GetMediumxTarget on the host of e, nil being the local
+// host. It needs the same pipefail behavior from e.
+func GetMediumxTargetOn(ctx context.Context, e cmd.Executor, dev string) (*TargetFrom, error) {
+	data, err := cmd.CmdCombinedOn(ctx, e, &cmd.Option{IgnoreErr: true}, mediumxTargetCommand(dev))
+	if err != nil && cmd.ExitCode(err) != 1 { // 1: grep found no ID_PATH
+		return nil, err
+	}
 	raw := strings.TrimSpace(string(data))
 	if raw == "" {
 		return nil, nil
@@ -253,3 +292,50 @@ func GetMediumxTarget(dev string) (*TargetFrom, error) {
 
 	return nil, nil
 }
+
+func mediumxTargetCommand(dev string) *cmd.Command {
+	return cmd.New("udevadm", "info", dev).Pipe(cmd.New("grep", "E: ID_PATH="))
+}
+
+// tapeByIdPathsRemote lists the symlinks of tapeByIdDir with find, the
+// output being "name\ttarget" lines.
+func tapeByIdPathsRemote(ctx context.Context, e cmd.Executor) (map[string]string, error) {
+	base := tapeByIdDir
+	data, err := cmd.CmdCombinedOn(ctx, e, nil, tapeByIdCommand(base))
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	for sc.Scan() {
+		name, target, ok := strings.Cut(sc.Text(), "\t")
+		if !ok {
+			continue
+		}
+
+		target = filepath.Base(target)
+		if strings.HasPrefix(target, "sg") || strings.HasPrefix(target, "nst") {
+			m[target] = filepath.Join(base, name)
+		}
+	}
+
+	return m, nil
+}
+
+func tapeByIdCommand(base string) *cmd.Command {
+	return cmd.New("find", base, "-maxdepth", "1", "-type", "l", "-printf", `%f\t%l\n`)
+}
+
+// fileValueOn is file.FileValue on the host of e, nil being the local host.
+func fileValueOn(ctx context.Context, e cmd.Executor, p string) string {
+	if e == nil {
+		return file.FileValue(p)
+	}
+
+	data, err := cmd.CmdCombinedOn(ctx, e, &cmd.Option{IgnoreErr: true}, cmd.New("cat", p))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
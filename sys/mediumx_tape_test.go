@@ -1,11 +1,15 @@
 package sys
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/meilihao/golib/v2/cmd"
+	"github.com/meilihao/golib/v2/cmd/cmdtest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,3 +45,100 @@ func TestGetMediumxs(t *testing.T) {
 		spew.Dump(ls)
 	}
 }
+
+func TestMediumxTargetCommand(t *testing.T) {
+	c := mediumxTargetCommand("/dev/sg 1")
+	cmdtest.AssertLint(t, c)
+	assert.Equal(t, `udevadm info '/dev/sg 1' | grep 'E: ID_PATH='`, c.String())
+}
+
+func TestGetMediumxsFake(t *testing.T) {
+	cmdtest.Install(t, cmdtest.NewFake(t).Golden("testdata"))
+
+	dir := t.TempDir()
+	scsiDevicesDir, tapeByIdDir = filepath.Join(dir, "scsi"), filepath.Join(dir, "by-id")
+	defer func() {
+		scsiDevicesDir, tapeByIdDir = "/sys/bus/scsi/devices", "/dev/tape/by-id"
+	}()
+
+	for bus, model := range map[string]string{"3:0:0:0": "ULT3580-TD5", "3:0:0:1": "3573-TL"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(scsiDevicesDir, bus), 0755))
+		assert.Nil(t, os.WriteFile(filepath.Join(scsiDevicesDir, bus, "vendor"), []byte("IBM     \n"), 0644))
+		assert.Nil(t, os.WriteFile(filepath.Join(scsiDevicesDir, bus, "model"), []byte(model+"\n"), 0644))
+	}
+	assert.Nil(t, os.MkdirAll(tapeByIdDir, 0755))
+	assert.Nil(t, os.Symlink("../../sg2", filepath.Join(tapeByIdDir, "scsi-3573-TL_00X2U78R4244_LL0")))
+	assert.Nil(t, os.Symlink("../../nst0", filepath.Join(tapeByIdDir, "scsi-35000e111c6b2f00f-nst")))
+
+	ls, err := GetMediumxs()
+	assert.Nil(t, err)
+	if !assert.Len(t, ls, 1) {
+		return
+	}
+
+	m := ls[0]
+	assert.Equal(t, "3:0:0:1", m.Bus)
+	assert.Equal(t, "3573-TL", m.Model)
+	assert.Equal(t, "/dev/sg2", m.Sg)
+	assert.Equal(t, filepath.Join(tapeByIdDir, "scsi-3573-TL_00X2U78R4244_LL0"), m.PathByid)
+	assert.Equal(t, &TargetFrom{Protocol: ProtocolIscsi, Target: "iqn.2005-10.org.freenas.ctl:tape"}, m.Target)
+
+	if assert.Len(t, m.Tapes, 1) {
+		assert.Equal(t, "/dev/st0", m.Tapes[0].Device)
+		assert.Equal(t, filepath.Join(tapeByIdDir, "scsi-35000e111c6b2f00f-nst"), m.Tapes[0].PathByid)
+	}
+}
+
+func TestGetMediumxTargetNoPath(t *testing.T) {
+	f := cmdtest.NewFake(t)
+	f.On(mediumxTargetCommand("/dev/sg9").String()).Exit(1)
+	cmdtest.Install(t, f)
+
+	target, err := GetMediumxTarget("/dev/sg9")
+	assert.Nil(t, err)
+	assert.Nil(t, target)
+}
+
+func TestGetMediumxTargetUnknownDevice(t *testing.T) {
+	f := cmdtest.NewFake(t)
+	f.On(mediumxTargetCommand("/dev/sg9").String()).Stderr("Unknown device \"/dev/sg9\": No such device").Exit(4)
+	cmdtest.Install(t, f)
+
+	target, err := GetMediumxTarget("/dev/sg9")
+	assert.Equal(t, 4, cmd.ExitCode(err))
+	assert.Nil(t, target)
+}
+
+func TestGetMediumxsOnNotFound(t *testing.T) {
+	f := cmdtest.NewFake(t)
+	f.OnArgv("lsscsi", "-g").Stderr("bash: line 1: lsscsi: command not found").Exit(127)
+
+	_, err := GetMediumxsOn(context.Background(), f)
+	assert.EqualError(t, err, "lsscsi not found: bash: line 1: lsscsi: command not found")
+}
+
+func TestGetMediumxsOn(t *testing.T) {
+	f := cmdtest.NewFake(t).Golden("testdata")
+	for bus, model := range map[string]string{"3:0:0:0": "ULT3580-TD5", "3:0:0:1": "3573-TL"} {
+		f.OnArgv("cat", "/sys/bus/scsi/devices/"+bus+"/vendor").Stdout("IBM     \n")
+		f.OnArgv("cat", "/sys/bus/scsi/devices/"+bus+"/model").Stdout(model + "\n")
+	}
+	f.On(tapeByIdCommand("/dev/tape/by-id").String()).
+		Stdout("scsi-3573-TL_00X2U78R4244_LL0\t../../sg2\nscsi-35000e111c6b2f00f-nst\t../../nst0\n")
+
+	ls, err := GetMediumxsOn(context.Background(), f)
+	assert.Nil(t, err)
+	if !assert.Len(t, ls, 1) {
+		return
+	}
+
+	m := ls[0]
+	assert.Equal(t, "IBM", m.Vendor)
+	assert.Equal(t, "3573-TL", m.Model)
+	assert.Equal(t, "/dev/tape/by-id/scsi-3573-TL_00X2U78R4244_LL0", m.PathByid)
+	assert.Equal(t, &TargetFrom{Protocol: ProtocolIscsi, Target: "iqn.2005-10.org.freenas.ctl:tape"}, m.Target)
+	if assert.Len(t, m.Tapes, 1) {
+		assert.Equal(t, "ULT3580-TD5", m.Tapes[0].Model)
+		assert.Equal(t, "/dev/tape/by-id/scsi-35000e111c6b2f00f-nst", m.Tapes[0].PathByid)
+	}
+}
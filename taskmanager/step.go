@@ -2,6 +2,7 @@ package taskmanager
 
 import (
 	"errors"
+	"sync/atomic"
 )
 
 const (
@@ -17,6 +18,9 @@ type TaskSteper interface {
 	Run() error
 	ClearRun() error
 	GetTaskStep() *taskStep
+	// SetProgress sets the percent of the step done, see Tasker.Progress.
+	SetProgress(percent int)
+	Progress() int
 }
 
 type taskStep struct {
@@ -26,7 +30,7 @@ type taskStep struct {
 	ratio      int
 	order      int
 	status     int
-	progress   int
+	progress   int32 // percent of the step done, accessed atomically
 	expiration int
 }
 
@@ -42,3 +46,20 @@ func newTaskStep(name string, ratio int) *taskStep {
 func (ts *taskStep) GetTaskStep() *taskStep {
 	return ts
 }
+
+// SetProgress sets the percent of the step done, clamped to [0, 100]. It may
+// be called from another goroutine than Run, e.g. as the OnProgress of a
+// cmd.Stream.
+func (ts *taskStep) SetProgress(percent int) {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	atomic.StoreInt32(&ts.progress, int32(percent))
+}
+
+// Progress returns the percent of the step done.
+func (ts *taskStep) Progress() int {
+	return int(atomic.LoadInt32(&ts.progress))
+}
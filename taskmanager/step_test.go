@@ -0,0 +1,13 @@
+package taskmanager
+
+import "testing"
+
+func TestTaskStepProgress(t *testing.T) {
+	ts := newTaskStep("convert", 50)
+	for in, expected := range map[int]int{-1: 0, 42: 42, 150: 100} {
+		ts.SetProgress(in)
+		if ts.Progress() != expected {
+			t.Errorf("SetProgress(%d): expected %d, got %d", in, expected, ts.Progress())
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package taskmanager
 
 import (
+	"sync"
 	"time"
 
 	"github.com/meilihao/golib/v2/log"
@@ -47,6 +48,10 @@ type Tasker interface {
 	GetTask() *task
 	ReloadCtx(oldCtx []byte) error // need InitTaskStep +  set redoSubStep
 	Cancel()
+	// Progress returns the percent of the task done: the ratio of the steps
+	// done, plus the part of the ratio of the running step done, see
+	// TaskSteper.SetProgress.
+	Progress() int
 }
 
 type task struct {
@@ -57,7 +62,6 @@ type task struct {
 	steps         []TaskSteper
 	clearSteps    []TaskSteper
 	clearErrs     []error
-	progress      int
 	exitFlag      bool // must set err too
 	err           error
 	canRedo       bool // task support to redo
@@ -66,6 +70,10 @@ type task struct {
 	redoSubStep   string // redo start point
 	typ           string
 	expiredAt     time.Time
+
+	mu       sync.Mutex // guards progress and running
+	progress int        // ratio of the steps done
+	running  int        // index of the running step, -1 if none
 }
 
 func newTask(id, name string, canRedo bool, expiredAt time.Time) *task {
@@ -76,6 +84,7 @@ func newTask(id, name string, canRedo bool, expiredAt time.Time) *task {
 		clearSteps: make([]TaskSteper, 0, 3),
 		clearErrs:  make([]error, 0),
 		canRedo:    canRedo,
+		running:    -1,
 		expiredAt:  expiredAt,
 	}
 	if expiredAt.IsZero() {
@@ -101,6 +110,33 @@ func (t *task) GetTask() *task {
 	return t
 }
 
+func (t *task) Progress() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := t.progress
+	if t.running >= 0 {
+		st := t.steps[t.running]
+		p += st.GetTaskStep().ratio * st.Progress() / 100
+	}
+	if p > TaskComplete {
+		p = TaskComplete
+	}
+	return p
+}
+
+// setRunning records the step being run, -1 once it is done, adding its
+// ratio to the progress if it succeeded.
+func (t *task) setRunning(idx int, done bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if done {
+		t.progress += t.steps[t.running].GetTaskStep().ratio
+	}
+	t.running = idx
+}
+
 func (t *task) Cancel() {
 	log.Glog.Info("start to task", zap.String("id", t.id))
 
@@ -213,19 +249,23 @@ func (t *task) RunTask() error {
 			return t.err
 		}
 
+		t.setRunning(idx, false)
 		t.doStep(idx, sf)
 		if t.err != nil {
+			t.setRunning(-1, false)
 			log.Glog.Error("Excute task step failed", zap.String("id", t.id), zap.String("step", sf.GetTaskStep().name), zap.Error(t.err))
 			t.doClearStep(idx)
 			return t.err
 		}
 
-		t.progress += int(sf.GetTaskStep().ratio)
+		t.setRunning(-1, true)
 	}
 
 	log.Glog.Info("run task finished", zap.String("id", t.id), zap.String("name", t.name))
 	t.status = StatusCompleted
+	t.mu.Lock()
 	t.progress = TaskComplete
+	t.mu.Unlock()
 
 	if t.err = t.UpdateTaskStatus(t.id, t.name, t.status); t.err != nil {
 		return t.err
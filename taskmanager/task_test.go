@@ -82,3 +82,50 @@ func TestTask(t *testing.T) {
 	task := new(DemoTask)
 	RunSyncTask(task, "1", "demo", nil)
 }
+
+type progressStep struct {
+	*taskStep
+	run func(s *progressStep) error
+}
+
+func (ps *progressStep) Init() {}
+
+func (ps *progressStep) Run() error { return ps.run(ps) }
+
+func (ps *progressStep) ClearRun() error { return nil }
+
+func TestTaskProgress(t *testing.T) {
+	tk := newTask("1", "progress", false, time.Time{})
+
+	var during []int
+	tk.addStep(&progressStep{
+		taskStep: newTaskStep("copy", 40),
+		run: func(s *progressStep) error {
+			s.SetProgress(50)
+			during = append(during, tk.Progress())
+			return nil
+		},
+	})
+	tk.addStep(&progressStep{
+		taskStep: newTaskStep("convert", 60),
+		run: func(s *progressStep) error {
+			s.SetProgress(50)
+			during = append(during, tk.Progress())
+			return nil
+		},
+	})
+
+	if p := tk.Progress(); p != 0 {
+		t.Errorf("expected 0 before running, got %d", p)
+	}
+	if err := tk.RunTask(); err != nil {
+		t.Fatal(err)
+	}
+	// 40*50% for the first step, then 40 + 60*50%
+	if fmt.Sprint(during) != "[20 70]" {
+		t.Errorf("expected [20 70] while running, got %v", during)
+	}
+	if p := tk.Progress(); p != TaskComplete {
+		t.Errorf("expected %d once completed, got %d", TaskComplete, p)
+	}
+}
@@ -72,10 +72,8 @@ type OsInfo struct {
 }
 
 func GetOsinfos() (ls []OsInfo, err error) {
-	c := "osinfo-query --fields=short-id,name,version,family,id os"
-
 	opt := &cmd.Option{}
-	out, err := cmd.CmdCombinedBashWithCtx(context.TODO(), opt, c)
+	out, err := cmd.CmdCombinedWithCtx(context.TODO(), opt, "osinfo-query", "--fields=short-id,name,version,family,id", "os")
 	if err != nil {
 		return
 	}
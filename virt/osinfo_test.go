@@ -6,6 +6,7 @@ import (
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/hashicorp/go-version"
+	"github.com/meilihao/golib/v2/cmd/cmdtest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,6 +21,18 @@ func TestGetOsinfos(t *testing.T) {
 	}
 }
 
+func TestGetOsinfosFake(t *testing.T) {
+	cmdtest.Install(t, cmdtest.NewFake(t).Golden("testdata"))
+
+	ls, err := GetOsinfos()
+	assert.Nil(t, err)
+	// winnt4.0 is filtered out by version
+	assert.Equal(t, []OsInfo{
+		{ShortId: "centos7.0", Name: "CentOS 7", Version: "7", Family: "linux", Id: "http://centos.org/centos/7.0"},
+		{ShortId: "win2k", Name: "Microsoft Windows 2000", Version: "5.0", Family: "winnt", Id: "http://microsoft.com/win/2k"},
+	}, ls)
+}
+
 func TestSemverCompare(t *testing.T) {
 	v, _ := version.NewVersion("5.2")
 	c, _ := version.NewConstraint(">= 5.0")
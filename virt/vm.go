@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/meilihao/golib/v2/cmd"
@@ -114,7 +115,7 @@ func (opt *GraphicsOption) Build() string {
 	}
 	ops = append(ops, "listen="+opt.Listen)
 	if opt.Port == -1 {
-		ops = append(ops, "port='-1'")
+		ops = append(ops, "port=-1")
 	} else {
 		ops = append(ops, fmt.Sprintf("port=%d", opt.Port))
 	}
@@ -345,38 +346,45 @@ func (opt *VmOption) Convert() error {
 	return nil
 }
 
+// BuildVirtIntall returns the virt-install command printing the xml of opt,
+// quoted for a shell, see VirtInstallCommand.
 func BuildVirtIntall(opt *VmOption) string {
-	ops := make([]string, 0, 15)
-	ops = append(ops, "virt-install --dry-run --print-xml")
-	ops = append(ops, "--name="+opt.Name)
+	return VirtInstallCommand(opt).String()
+}
+
+// VirtInstallCommand returns the virt-install command printing the xml of opt
+// without defining it.
+func VirtInstallCommand(opt *VmOption) *cmd.Command {
+	c := cmd.New("virt-install", "--dry-run", "--print-xml")
+	c.Arg("--name=" + opt.Name)
 	if opt.Desc != "" {
-		ops = append(ops, "--description="+opt.Desc)
+		c.Arg("--description=" + opt.Desc)
 	}
-	ops = append(ops, "--os-variant="+opt.OsVariant)
-	ops = append(ops, fmt.Sprintf(fmt.Sprintf("--memory memory=%d,maxmemory=%d", opt.Memory, opt.Memory)))
-	ops = append(ops, fmt.Sprintf("--vcpus %d", opt.Vcpu))
+	c.Arg("--os-variant=" + opt.OsVariant)
+	c.Arg("--memory", fmt.Sprintf("memory=%d,maxmemory=%d", opt.Memory, opt.Memory))
+	c.Arg("--vcpus", strconv.FormatUint(uint64(opt.Vcpu), 10))
 	if opt.CpuMode == "host-passthrough" {
-		ops = append(ops, "--cpu=host-passthrough")
+		c.Arg("--cpu=host-passthrough")
 	} else if opt.CpuMode == "host-model" {
-		ops = append(ops, "--cpu=host-model")
+		c.Arg("--cpu=host-model")
 	} else {
-		ops = append(ops, "--cpu=qemu64")
+		c.Arg("--cpu=qemu64")
 	}
-	ops = append(ops, "--arch="+opt.Arch)
+	c.Arg("--arch=" + opt.Arch)
 
-	ops = append(ops, "--machine="+opt.Machine)
+	c.Arg("--machine=" + opt.Machine)
 	if opt.Sound != nil {
-		ops = append(ops, "--soundhw "+opt.Sound.Model)
+		c.Arg("--soundhw", opt.Sound.Model)
 	}
 
-	ops = append(ops, "--clock offset="+opt.ClockOffset)
-	ops = append(ops, "--graphics "+opt.Graphics.Build())
-	ops = append(ops, "--video "+opt.Video.Build())
+	c.Arg("--clock", "offset="+opt.ClockOffset)
+	c.Arg("--graphics", opt.Graphics.Build())
+	c.Arg("--video", opt.Video.Build())
 
-	ops = append(ops, "--boot "+opt.Boot.Build())
+	c.Arg("--boot", opt.Boot.Build())
 
 	for _, v := range opt.Nics {
-		ops = append(ops, "--network "+v.Build())
+		c.Arg("--network", v.Build())
 	}
 
 	var inputBus string
@@ -390,26 +398,26 @@ func BuildVirtIntall(opt *VmOption) string {
 	if strings.Contains(opt.Arch, ArchX86) {
 		// 加tablet防止出现鼠标漂移
 		inputBus = BusUsb // 用virtio还是有较大漂移在xp上
-		ops = append(ops, "--input type=tablet,bus="+inputBus)
+		c.Arg("--input", "type=tablet,bus="+inputBus)
 		// input ps2 is default device, libvirtd will add it auto when deleted
-		ops = append(ops, "--input type=mouse")
-		ops = append(ops, "--input type=keyboard")
+		c.Arg("--input", "type=mouse")
+		c.Arg("--input", "type=keyboard")
 	} else {
-		ops = append(ops, "--input type=mouse,bus="+inputBus)
-		ops = append(ops, "--input type=keyboard,bus="+inputBus)
-		//	ops = append(ops, "--input type=tablet,bus="+inputBus)
+		c.Arg("--input", "type=mouse,bus="+inputBus)
+		c.Arg("--input", "type=keyboard,bus="+inputBus)
+		//	c.Arg("--input", "type=tablet,bus="+inputBus)
 	}
 
 	ideNo := NewDiskFromNumber(BusIde, 1)
 	virtioNo := NewDiskFromNumber(BusVirtio, 1)
 	scsiNo := NewDiskFromNumber(BusScsi, 1)
 	for _, v := range opt.Disks {
-		ops = append(ops, "--disk "+v.Build(ideNo, scsiNo, virtioNo))
+		c.Arg("--disk", v.Build(ideNo, scsiNo, virtioNo))
 	}
 
-	ops = append(ops, "--check disk_size=off")
+	c.Arg("--check", "disk_size=off")
 
-	return strings.Join(ops, " ")
+	return c
 }
 
 // virt-install will auto insert pci control
@@ -429,10 +437,14 @@ func VmDefinePreXml(opt *VmOption) (string, error) {
 		return "", err
 	}
 
-	s := BuildVirtIntall(opt)
+	return printXml(opt)
+}
 
+// printXml runs VirtInstallCommand, opt being validated, and returns the xml
+// it prints.
+func printXml(opt *VmOption) (string, error) {
 	copt := &cmd.Option{}
-	out, err := cmd.CmdCombinedBashWithCtx(context.TODO(), copt, s)
+	out, err := cmd.CmdCombinedCommandWithCtx(context.TODO(), copt, VirtInstallCommand(opt))
 	if err != nil {
 		return "", err
 	}
@@ -499,8 +511,8 @@ func VmReload(name string) error {
 	}
 
 	copt := &cmd.Option{}
-	_, err := cmd.CmdCombinedBashWithCtx(context.TODO(), copt,
-		fmt.Sprintf(" virsh define %s", p),
+	_, err := cmd.CmdCombinedCommandWithCtx(context.TODO(), copt,
+		cmd.New("virsh", "define", p),
 	)
 	if err != nil {
 		return err
@@ -588,19 +600,8 @@ func AddDisk(r *AddDiskReq) error {
 		r.Disk.TargetDev = idNo.Generate()
 	}
 
-	parts := []string{
-		"virt-xml",
-		r.Domain,
-	}
-	if r.IsHotunplug {
-		parts = append(parts, "--update")
-	}
-	parts = append(parts, "--add-device", "--disk", r.Disk.Build(nil, nil, nil))
-
 	opt := &cmd.Option{}
-	_, err = cmd.CmdCombinedBashWithCtx(context.TODO(), opt,
-		strings.Join(parts, " "),
-	)
+	_, err = cmd.CmdCombinedCommandWithCtx(context.TODO(), opt, addDiskCommand(r))
 	if err != nil {
 		return err
 	}
@@ -608,6 +609,12 @@ func AddDisk(r *AddDiskReq) error {
 	return nil
 }
 
+func addDiskCommand(r *AddDiskReq) *cmd.Command {
+	return cmd.New("virt-xml", r.Domain).
+		ArgIf(r.IsHotunplug, "--update").
+		Arg("--add-device", "--disk", r.Disk.Build(nil, nil, nil))
+}
+
 type RemoveDiskReq struct {
 	Domain    string `json:"domain" binding:"required"`
 	TargetDev string `json:"targetDev" binding:"required"`
@@ -829,10 +836,10 @@ func VmChangeCommon(r *VmChangeCommonReq) error {
 
 	var tmp [][]string
 	if r.Vcpu > 0 {
-		tmp = append(tmp, []string{fmt.Sprintf("--vcpus %d", r.Vcpu)})
+		tmp = append(tmp, []string{"--vcpus", strconv.FormatUint(uint64(r.Vcpu), 10)})
 	}
 	if r.Memory > 0 {
-		tmp = append(tmp, []string{fmt.Sprintf("--memory memory=%d,maxmemory=%d", r.Memory, r.Memory)})
+		tmp = append(tmp, []string{"--memory", fmt.Sprintf("memory=%d,maxmemory=%d", r.Memory, r.Memory)})
 	}
 
 	if len(tmp) == 0 {
@@ -840,7 +847,7 @@ func VmChangeCommon(r *VmChangeCommonReq) error {
 	}
 
 	for _, v := range tmp {
-		if err = VmXmlEdit(r.Domain, v); err != nil {
+		if err = VmXmlEditArgs(r.Domain, v...); err != nil {
 			return err
 		}
 	}
@@ -848,7 +855,11 @@ func VmChangeCommon(r *VmChangeCommonReq) error {
 	return nil
 }
 
-// virt-xml --edit only need one option
+// VmXmlEdit runs `virt-xml <domain> --edit` with ls, shell fragments joined
+// with spaces and run by bash, e.g. "--vcpus 4". virt-xml --edit only need one
+// option.
+//
+// Deprecated: use VmXmlEditArgs, which doesn't need a shell nor quoting.
 func VmXmlEdit(domain string, ls []string) error {
 	parts := []string{
 		"virt-xml",
@@ -868,3 +879,20 @@ func VmXmlEdit(domain string, ls []string) error {
 
 	return nil
 }
+
+// VmXmlEditArgs runs `virt-xml <domain> --edit` with args, passed as argv
+// without a shell: an option and its value are separate items, e.g. "--vcpus",
+// "4". virt-xml --edit only need one option.
+func VmXmlEditArgs(domain string, args ...string) error {
+	opt := &cmd.Option{}
+	_, err := cmd.CmdCombinedCommandWithCtx(context.TODO(), opt, xmlEditCommand(domain, args))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func xmlEditCommand(domain string, ls []string) *cmd.Command {
+	return cmd.New("virt-xml", domain, "--edit").Arg(ls...)
+}
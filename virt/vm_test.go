@@ -2,9 +2,12 @@ package virt
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/meilihao/golib/v2/cmd/cmdtest"
 	"github.com/stretchr/testify/assert"
+	"libvirt.org/go/libvirtxml"
 )
 
 func TestUndefineVm(t *testing.T) {
@@ -95,6 +98,65 @@ func TestVmDefinePreXml(t *testing.T) {
 	vm.Free()
 }
 
+func TestVmDefinePreXmlFake(t *testing.T) {
+	cmdtest.Install(t, cmdtest.NewFake(t).Golden("testdata"))
+
+	opt := &VmOption{
+		Name:      "vm1",
+		Desc:      "my vm",
+		OsVariant: "centos7.0",
+		OsFamily:  "linux",
+		Arch:      "x86_64",
+		Machine:   "q35",
+		Memory:    1024,
+		Vcpu:      2,
+		CpuMode:   "host-model",
+		Boot: &BootOption{
+			Firmware: "bios",
+		},
+		ClockOffset: "utc",
+		Graphics: &GraphicsOption{
+			Type:   "vnc",
+			Port:   -1,
+			Listen: "0.0.0.0",
+		},
+		Video: &VideoOption{
+			Model: "qxl",
+		},
+		Disks: []*DiskOption{
+			{
+				Device:    "disk",
+				Path:      "/var/lib/libvirt/images/vm1.qcow2",
+				Bus:       "virtio",
+				TargetDev: "vda",
+				Size:      4,
+				BootOrder: 1,
+			},
+		},
+		Nics: []*NicOption{
+			{
+				Type:   "bridge",
+				Source: "br0",
+				Mac:    "52:54:00:f6:b8:9e",
+				Model:  "virtio",
+			},
+		},
+		domainCaps: &DomainCaps{&libvirtxml.DomainCaps{
+			Devices: &libvirtxml.DomainCapsDevices{
+				Disk: &libvirtxml.DomainCapsDevice{
+					Supported: "yes",
+					Enums:     []libvirtxml.DomainCapsEnum{{Name: "bus", Values: []string{"sata", "usb", "virtio"}}},
+				},
+			},
+		}},
+	}
+
+	s, err := printXml(opt)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(s, `<domain type="kvm">`))
+	assert.Contains(t, s, "<description>my vm</description>")
+}
+
 func TestAddDisk(t *testing.T) {
 	r := &AddDiskReq{
 		Domain: "xxx",
@@ -153,3 +215,43 @@ func TestVmChangeCommon(t *testing.T) {
 	err := VmChangeCommon(r)
 	assert.Nil(t, err)
 }
+
+func TestAddDiskCommand(t *testing.T) {
+	c := addDiskCommand(&AddDiskReq{
+		Domain: "vm; reboot",
+		Disk: &DiskOption{
+			Device:    DiskDeviceDisk,
+			Bus:       BusVirtio,
+			Path:      "/data/my disk.qcow2",
+			TargetDev: "vdb",
+		},
+		IsHotunplug: true,
+	})
+	cmdtest.AssertLint(t, c)
+	assert.Equal(t, []string{"vm; reboot", "--update", "--add-device", "--disk", "/data/my disk.qcow2,device=disk,bus=virtio,target.dev=vdb"}, c.Args)
+}
+
+func TestXmlEditCommand(t *testing.T) {
+	c := xmlEditCommand("vm", []string{"--memory", "memory=4096,maxmemory=4096"})
+	cmdtest.AssertLint(t, c)
+	assert.Equal(t, "virt-xml vm --edit --memory memory=4096,maxmemory=4096", c.String())
+}
+
+func TestVmXmlEditFake(t *testing.T) {
+	f := cmdtest.NewFake(t)
+	f.OnArgv("virt-xml", "vm 1", "--edit", "--vcpus", "4").Stdout("Domain 'vm 1' defined successfully.")
+	f.OnRegexp(`--memory`).Stderr("ERROR    Invalid --memory").Exit(1)
+	cmdtest.Install(t, f)
+
+	assert.Nil(t, VmXmlEditArgs("vm 1", "--vcpus", "4"))
+	err := VmXmlEditArgs("vm 1", "--memory", "memory=0")
+	assert.Equal(t, "ERROR    Invalid --memory", err.Error())
+}
+
+func TestVmXmlEditShell(t *testing.T) {
+	f := cmdtest.NewFake(t)
+	f.OnArgv("bash", "-c", "virt-xml vm --edit --vcpus 4").Stdout("Domain 'vm' defined successfully.")
+	cmdtest.Install(t, f)
+
+	assert.Nil(t, VmXmlEdit("vm", []string{"--vcpus 4"}))
+}
@@ -0,0 +1,158 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrBackplaneClosed = errors.New("ws: backplane closed")
+)
+
+// The kinds of envelope.
+const (
+	kindBroadcast = "broadcast"
+	kindAccount   = "account"
+	kindClient    = "client"
+	kindTopic     = "topic"
+)
+
+// envelope is a message sent on a Hub, with its recipients.
+type envelope struct {
+	// Node is the NodeId of the Hub sending it on the Backplane
+	Node    string `json:"node"`
+	Kind    string `json:"kind"`
+	Account int64  `json:"account,omitempty"`
+	Id      string `json:"id,omitempty"`
+	Topic   string `json:"topic,omitempty"`
+	Data    []byte `json:"data"`
+}
+
+// Backplane delivers the messages of the hubs of a cluster to all of them,
+// at most once.
+type Backplane interface {
+	// Publish sends data to all the nodes, the sender included.
+	Publish(data []byte) error
+	// Messages returns the data published, it is closed by Close.
+	Messages() <-chan []byte
+	Close() error
+}
+
+// RedisBackplane is a Backplane over a Redis pub/sub channel.
+type RedisBackplane struct {
+	rdb     *redis.Client
+	channel string
+	ps      *redis.PubSub
+	msgs    chan []byte
+}
+
+// NewRedisBackplane subscribes to channel, "golib:ws" if empty, of rdb, e.g.
+// from db.InitRedis.
+func NewRedisBackplane(rdb *redis.Client, channel string) (*RedisBackplane, error) {
+	if channel == "" {
+		channel = "golib:ws"
+	}
+
+	ps := rdb.Subscribe(context.TODO(), channel)
+	// waits for the subscription, the messages published before are lost
+	if _, err := ps.Receive(context.TODO()); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	b := &RedisBackplane{
+		rdb:     rdb,
+		channel: channel,
+		ps:      ps,
+		msgs:    make(chan []byte, 256),
+	}
+	go func() {
+		// the channel of ps is closed by its Close, it reconnects otherwise
+		for m := range ps.Channel() {
+			b.msgs <- []byte(m.Payload)
+		}
+		close(b.msgs)
+	}()
+
+	return b, nil
+}
+
+func (b *RedisBackplane) Publish(data []byte) error {
+	return b.rdb.Publish(context.TODO(), b.channel, data).Err()
+}
+
+func (b *RedisBackplane) Messages() <-chan []byte {
+	return b.msgs
+}
+
+// Close unsubscribes, the Redis client is not closed.
+func (b *RedisBackplane) Close() error {
+	err := b.ps.Close()
+	if err != nil {
+		log.Glog.Warn("ws backplane close", zap.String("channel", b.channel), zap.Error(err))
+	}
+	return err
+}
+
+// LocalBus connects the LocalBackplanes of the hubs of a process, e.g. to
+// test a cluster.
+type LocalBus struct {
+	mu    sync.RWMutex
+	nodes map[*LocalBackplane]bool
+}
+
+// NewLocalBus returns an empty LocalBus.
+func NewLocalBus() *LocalBus {
+	return &LocalBus{nodes: map[*LocalBackplane]bool{}}
+}
+
+// Backplane returns a new Backplane on the bus.
+func (bus *LocalBus) Backplane() *LocalBackplane {
+	b := &LocalBackplane{bus: bus, msgs: make(chan []byte, 256)}
+
+	bus.mu.Lock()
+	bus.nodes[b] = true
+	bus.mu.Unlock()
+
+	return b
+}
+
+// LocalBackplane is a Backplane in memory.
+type LocalBackplane struct {
+	bus  *LocalBus
+	msgs chan []byte
+	once sync.Once
+}
+
+func (b *LocalBackplane) Publish(data []byte) error {
+	b.bus.mu.RLock()
+	defer b.bus.mu.RUnlock()
+
+	if !b.bus.nodes[b] {
+		return ErrBackplaneClosed
+	}
+	for node := range b.bus.nodes {
+		node.msgs <- data
+	}
+	return nil
+}
+
+func (b *LocalBackplane) Messages() <-chan []byte {
+	return b.msgs
+}
+
+func (b *LocalBackplane) Close() error {
+	b.once.Do(func() {
+		b.bus.mu.Lock()
+		delete(b.bus.nodes, b)
+		b.bus.mu.Unlock()
+
+		close(b.msgs)
+	})
+	return nil
+}
@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// receive returns the next message queued for c.
+func receive(t *testing.T, c *Client) string {
+	t.Helper()
+
+	select {
+	case msg := <-c.SendChan:
+		return string(msg)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for a message for %s", c.Id)
+	}
+	return ""
+}
+
+// testBackplane checks the delivery of the messages between two hubs on
+// backplanes a and b.
+func testBackplane(t *testing.T, a, b Backplane) {
+	ha := NewHub(&HubOption{Backplane: a, NodeId: "a"})
+	defer ha.Close()
+	hb := NewHub(&HubOption{Backplane: b, NodeId: "b"})
+	defer hb.Close()
+
+	ca := &Client{Id: "ca", Account: 1}
+	cb := &Client{Id: "cb", Account: 2}
+	ha.Register(ca)
+	hb.Register(cb)
+	hb.Subscribe(cb, "vm/1")
+
+	// delivered once on each node, the sender deduplicating its own
+	ha.Broadcast([]byte("all"))
+	if got := receive(t, ca); got != "all" {
+		t.Errorf("unexpected message %q", got)
+	}
+	if got := receive(t, cb); got != "all" {
+		t.Errorf("unexpected message %q", got)
+	}
+
+	if err := ha.SendToAccount(2, &Message{Type: 1, Raw: []byte(`"account"`)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, cb); got != `{"type":1,"raw":"account"}` {
+		t.Errorf("unexpected message %s", got)
+	}
+	if err := hb.SendToClient(1, "ca", &Message{Type: 2, Raw: []byte(`"client"`)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, ca); got != `{"type":2,"raw":"client"}` {
+		t.Errorf("unexpected message %s", got)
+	}
+	if err := ha.Publish("vm/1", &Message{Type: 3, Raw: []byte(`"running"`)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, cb); got != `{"type":3,"raw":"running"}` {
+		t.Errorf("unexpected message %s", got)
+	}
+
+	// a marker, sent after, is the next message: nothing was duplicated
+	ha.Broadcast([]byte("end"))
+	for _, c := range []*Client{ca, cb} {
+		if got := receive(t, c); got != "end" {
+			t.Errorf("unexpected message %q for %s", got, c.Id)
+		}
+	}
+}
+
+func TestLocalBackplane(t *testing.T) {
+	bus := NewLocalBus()
+	testBackplane(t, bus.Backplane(), bus.Backplane())
+
+	b := bus.Backplane()
+	b.Close()
+	if err := b.Close(); err != nil {
+		t.Errorf("expected a second Close to succeed, got %v", err)
+	}
+	if err := b.Publish([]byte("late")); !errors.Is(err, ErrBackplaneClosed) {
+		t.Errorf("expected ErrBackplaneClosed, got %v", err)
+	}
+}
+
+func TestRedisBackplane(t *testing.T) {
+	s := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rdb.Close()
+
+	a, err := NewRedisBackplane(rdb, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRedisBackplane(rdb, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackplane(t, a, b)
+}
+
+func TestHubNoBackplane(t *testing.T) {
+	h := NewHub(nil)
+	defer h.Close()
+
+	if err := h.SendToClient(1, "a", &Message{}); !errors.Is(err, ErrNoClient) {
+		t.Errorf("expected ErrNoClient, got %v", err)
+	}
+
+	// the client may be on another node
+	bus := NewLocalBus()
+	h = NewHub(&HubOption{Backplane: bus.Backplane()})
+	defer h.Close()
+	if err := h.SendToClient(1, "a", &Message{}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
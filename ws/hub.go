@@ -0,0 +1,392 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/meilihao/golib/v2/log"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrHubClosed = errors.New("ws: hub closed")
+	ErrNoClient  = errors.New("ws: no client")
+)
+
+// HubOption configures a Hub.
+type HubOption struct {
+	// QueueSize is the number of messages queued for a client, 64 by
+	// default. A client with a full queue is too slow and is evicted.
+	QueueSize int
+	// WriteTimeout bounds the write of a message, 10s by default.
+	WriteTimeout time.Duration
+	// MaxFrameSize is the size limit of the frames read, 1MB by default.
+	MaxFrameSize int64
+	// Backplane delivers the messages sent on the Hub to the hubs of the
+	// other nodes, e.g. a RedisBackplane. It is closed by Close.
+	Backplane Backplane
+	// NodeId identifies the Hub on the Backplane, random by default.
+	NodeId string
+	// OnMessage handles the text and binary messages of the clients, in the
+	// reading goroutine of each client. They are dropped if nil.
+	OnMessage func(c *Client, op ws.OpCode, data []byte)
+}
+
+// Hub tracks the websocket clients, by account and id, and fans out the
+// messages to them, to all, an account, a client or the subscribers of a
+// topic.
+type Hub struct {
+	opt HubOption
+
+	mu      sync.RWMutex
+	clients map[int64]map[string]*Client
+	topics  map[string]map[*Client]bool
+	closed  bool
+
+	// received is closed once the Backplane is closed
+	received chan struct{}
+}
+
+// NewHub returns a Hub, opt may be nil.
+func NewHub(opt *HubOption) *Hub {
+	h := &Hub{
+		clients: make(map[int64]map[string]*Client, 20),
+		topics:  map[string]map[*Client]bool{},
+	}
+	if opt != nil {
+		h.opt = *opt
+	}
+	if h.opt.QueueSize <= 0 {
+		h.opt.QueueSize = 64
+	}
+	if h.opt.WriteTimeout <= 0 {
+		h.opt.WriteTimeout = 10 * time.Second
+	}
+	if h.opt.MaxFrameSize <= 0 {
+		h.opt.MaxFrameSize = 1 << 20
+	}
+	if h.opt.Backplane != nil {
+		if h.opt.NodeId == "" {
+			b := make([]byte, 8)
+			rand.Read(b)
+			h.opt.NodeId = hex.EncodeToString(b)
+		}
+		h.received = make(chan struct{})
+		go h.receive()
+	}
+
+	return h
+}
+
+// Register adds c, replacing and closing a client with the same account and
+// id.
+func (h *Hub) Register(c *Client) error {
+	if c.SendChan == nil {
+		c.SendChan = make(chan []byte, h.opt.QueueSize)
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return ErrHubClosed
+	}
+	ls := h.clients[c.Account]
+	if ls == nil {
+		ls = make(map[string]*Client, 10)
+		h.clients[c.Account] = ls
+	}
+	old := ls[c.Id]
+	if old != nil && old != c {
+		h.unsubscribeAll(old)
+	}
+	ls[c.Id] = c
+	h.mu.Unlock()
+
+	log.Glog.Info("ws register", zap.Int64("account", c.Account), zap.String("id", c.Id))
+	if old != nil && old != c {
+		old.Close()
+	}
+
+	return nil
+}
+
+// Unregister removes and closes c, it can be called many times.
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	if ls := h.clients[c.Account]; ls[c.Id] == c {
+		delete(ls, c.Id)
+		if len(ls) == 0 {
+			delete(h.clients, c.Account)
+		}
+		h.unsubscribeAll(c)
+		log.Glog.Info("ws unregister", zap.Int64("account", c.Account), zap.String("id", c.Id))
+	}
+	h.mu.Unlock()
+
+	if err := c.Close(); err != nil {
+		log.Glog.Info("client close", zap.Int64("account", c.Account), zap.String("id", c.Id), zap.Error(err))
+	}
+}
+
+// Serve registers c and serves it, writing its queue and reading its
+// messages, until either ends. Then it unregisters c. It returns nil if c or
+// the peer closed the connection.
+func (h *Hub) Serve(c *Client) error {
+	if err := h.Register(c); err != nil {
+		c.Close()
+		return err
+	}
+
+	werr := make(chan error, 1)
+	go func() {
+		err := c.writeLoop(h.opt.WriteTimeout)
+		if c.isClosed() {
+			// by Unregister or Close, e.g. the eviction of a blocked write
+			err = ErrClientClosed
+		}
+		// ends the read
+		h.Unregister(c)
+		werr <- err
+	}()
+
+	err := c.read(h.opt.MaxFrameSize, h.opt.OnMessage)
+	if c.isClosed() {
+		// by Unregister or Close, the read fails on the closed conn
+		err = nil
+	}
+	h.Unregister(c)
+	if wErr := <-werr; !errors.Is(wErr, ErrClientClosed) {
+		err = wErr
+	}
+
+	var closed wsutil.ClosedError
+	if errors.As(err, &closed) || errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err != nil {
+		log.Glog.Warn("ws client", zap.Int64("account", c.Account), zap.String("id", c.Id), zap.Error(err))
+	}
+
+	return err
+}
+
+// Broadcast queues msg for all the clients, without blocking. The clients
+// with a full queue are evicted.
+func (h *Hub) Broadcast(msg []byte) {
+	h.dispatch(&envelope{Kind: kindBroadcast, Data: msg})
+}
+
+// SendToAccount queues m for the clients of account. Without a Backplane, it
+// returns ErrNoClient if account has no client.
+func (h *Hub) SendToAccount(account int64, m *Message) error {
+	msg, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return h.dispatch(&envelope{Kind: kindAccount, Account: account, Data: msg})
+}
+
+// SendToClient queues m for the client id of account. Without a Backplane,
+// it returns ErrNoClient if there is no such client.
+func (h *Hub) SendToClient(account int64, id string, m *Message) error {
+	msg, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return h.dispatch(&envelope{Kind: kindClient, Account: account, Id: id, Data: msg})
+}
+
+// Subscribe subscribes c to topic, e.g. "task/42", until Unsubscribe or its
+// unregistration. c must be registered.
+func (h *Hub) Subscribe(c *Client, topic string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.clients[c.Account][c.Id] != c {
+		return ErrNoClient
+	}
+	subs := h.topics[topic]
+	if subs == nil {
+		subs = map[*Client]bool{}
+		h.topics[topic] = subs
+	}
+	subs[c] = true
+	if c.topics == nil {
+		c.topics = map[string]bool{}
+	}
+	c.topics[topic] = true
+
+	return nil
+}
+
+// Unsubscribe unsubscribes c from topic.
+func (h *Hub) Unsubscribe(c *Client, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.unsubscribe(c, topic)
+}
+
+// unsubscribeAll unsubscribes c from its topics, with h.mu locked.
+func (h *Hub) unsubscribeAll(c *Client) {
+	for topic := range c.topics {
+		h.unsubscribe(c, topic)
+	}
+}
+
+// unsubscribe is Unsubscribe with h.mu locked.
+func (h *Hub) unsubscribe(c *Client, topic string) {
+	if subs := h.topics[topic]; subs != nil {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	delete(c.topics, topic)
+}
+
+// Publish queues m for the subscribers of topic, if any.
+func (h *Hub) Publish(topic string, m *Message) error {
+	msg, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return h.dispatch(&envelope{Kind: kindTopic, Topic: topic, Data: msg})
+}
+
+// dispatch delivers e to the local clients and forwards it to the other
+// nodes, if any.
+func (h *Hub) dispatch(e *envelope) error {
+	err := h.deliver(e)
+	if h.opt.Backplane == nil {
+		return err
+	}
+
+	e.Node = h.opt.NodeId
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err = h.opt.Backplane.Publish(data); err != nil {
+		log.Glog.Error("ws backplane publish", zap.String("kind", e.Kind), zap.Error(err))
+	}
+	return err
+}
+
+// deliver queues the message of e for its local clients.
+func (h *Hub) deliver(e *envelope) error {
+	var clients []*Client
+
+	h.mu.RLock()
+	switch e.Kind {
+	case kindBroadcast:
+		for _, ls := range h.clients {
+			for _, c := range ls {
+				clients = append(clients, c)
+			}
+		}
+	case kindAccount:
+		for _, c := range h.clients[e.Account] {
+			clients = append(clients, c)
+		}
+	case kindClient:
+		if c := h.clients[e.Account][e.Id]; c != nil {
+			clients = append(clients, c)
+		}
+	case kindTopic:
+		for c := range h.topics[e.Topic] {
+			clients = append(clients, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(clients) == 0 && (e.Kind == kindAccount || e.Kind == kindClient) {
+		return ErrNoClient
+	}
+	return h.send(clients, e.Data)
+}
+
+// receive delivers the messages of the other nodes, until the Backplane is
+// closed.
+func (h *Hub) receive() {
+	defer close(h.received)
+
+	for data := range h.opt.Backplane.Messages() {
+		e := &envelope{}
+		if err := json.Unmarshal(data, e); err != nil {
+			log.Glog.Warn("ws backplane bad message", zap.Error(err))
+			continue
+		}
+		if e.Node == h.opt.NodeId {
+			// already delivered by dispatch
+			continue
+		}
+		h.deliver(e)
+	}
+}
+
+// send queues msg for clients, evicting the slow ones. It returns the last
+// error.
+func (h *Hub) send(clients []*Client, msg []byte) (err error) {
+	for _, c := range clients {
+		if cerr := c.send(msg); cerr != nil {
+			err = cerr
+			if errors.Is(cerr, ErrQueueFull) {
+				log.Glog.Warn("ws slow client evicted", zap.Int64("account", c.Account), zap.String("id", c.Id))
+				h.Unregister(c)
+			}
+		}
+	}
+	return err
+}
+
+// Len returns the number of clients.
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	n := 0
+	for _, ls := range h.clients {
+		n += len(ls)
+	}
+	return n
+}
+
+// Close closes the clients and the Backplane, the Hub can't be used after.
+// It can be called many times.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closed = true
+	clients := h.clients
+	h.clients = map[int64]map[string]*Client{}
+	h.topics = map[string]map[*Client]bool{}
+	h.mu.Unlock()
+
+	for _, ls := range clients {
+		for _, c := range ls {
+			c.Close()
+		}
+	}
+
+	var err error
+	if h.opt.Backplane != nil {
+		err = h.opt.Backplane.Close()
+		<-h.received
+	}
+	log.Glog.Info("ws hub closed")
+
+	return err
+}
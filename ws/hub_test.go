@@ -0,0 +1,362 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// newTestServer serves the websockets of h, the account and id of a client
+// are the query parameters of its URL.
+func newTestServer(t *testing.T, h *Hub) string {
+	t.Helper()
+
+	var ids int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			return
+		}
+		var account int64
+		fmt.Sscan(r.URL.Query().Get("account"), &account)
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			id = fmt.Sprint(atomic.AddInt64(&ids, 1))
+		}
+		h.Serve(&Client{Id: id, Account: account, Conn: conn})
+	}))
+	t.Cleanup(s.Close)
+
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// dial connects to url with the query q.
+func dial(t *testing.T, url, q string) net.Conn {
+	t.Helper()
+
+	conn, _, _, err := ws.Dial(context.Background(), url+"?"+q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readText reads a text message sent by the server to conn.
+func readText(t *testing.T, conn net.Conn) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := wsutil.ReadServerText(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// waitFor waits up to 5s for cond.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func TestHubBroadcast(t *testing.T) {
+	h := NewHub(nil)
+	defer h.Close()
+	url := newTestServer(t, h)
+
+	conns := []net.Conn{dial(t, url, "account=1"), dial(t, url, "account=1"), dial(t, url, "account=2")}
+	waitFor(t, "the clients", func() bool { return h.Len() == 3 })
+
+	h.Broadcast([]byte("hello"))
+	h.Broadcast([]byte("world"))
+	for _, conn := range conns {
+		if got := readText(t, conn); got != "hello" {
+			t.Errorf("expected hello, got %q", got)
+		}
+		if got := readText(t, conn); got != "world" {
+			t.Errorf("expected world, got %q", got)
+		}
+	}
+
+	conns[0].Close()
+	waitFor(t, "the client to be unregistered", func() bool { return h.Len() == 2 })
+}
+
+func TestHubOnMessage(t *testing.T) {
+	received := make(chan string, 1)
+	h := NewHub(&HubOption{OnMessage: func(c *Client, op ws.OpCode, data []byte) {
+		received <- c.Id + ":" + string(data)
+	}})
+	defer h.Close()
+	url := newTestServer(t, h)
+
+	conn := dial(t, url, "id=a")
+	if err := wsutil.WriteClientText(conn, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != "a:ping" {
+			t.Errorf("unexpected message %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the message")
+	}
+
+	// the message is not echoed, the ping is answered
+	if err := wsutil.WriteClientMessage(conn, ws.OpPing, []byte("p")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	f, err := ws.ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.OpCode != ws.OpPong {
+		t.Errorf("expected a pong, got %v %q", f.Header.OpCode, f.Payload)
+	}
+}
+
+func TestHubSlowClient(t *testing.T) {
+	h := NewHub(&HubOption{QueueSize: 2})
+	defer h.Close()
+
+	// no writer: the queue fills up
+	slow := &Client{Id: "slow", Account: 1}
+	fast := &Client{Id: "fast", Account: 1, SendChan: make(chan []byte, 10)}
+	for _, c := range []*Client{slow, fast} {
+		if err := h.Register(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			h.Broadcast([]byte(fmt.Sprint(i)))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Broadcast blocked")
+	}
+
+	if h.Len() != 1 {
+		t.Errorf("expected the slow client to be evicted, got %d clients", h.Len())
+	}
+	if len(fast.SendChan) != 3 {
+		t.Errorf("expected 3 messages for the fast client, got %d", len(fast.SendChan))
+	}
+	n := 0
+	for range slow.SendChan {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("expected the 2 queued messages of the closed client, got %d", n)
+	}
+	if err := slow.send([]byte("x")); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("expected ErrClientClosed, got %v", err)
+	}
+}
+
+func TestHubSlowConn(t *testing.T) {
+	h := NewHub(&HubOption{QueueSize: 1, WriteTimeout: time.Minute})
+	defer h.Close()
+
+	// nothing reads the other end of the pipe
+	server, client := net.Pipe()
+	defer client.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- h.Serve(&Client{Id: "slow", Conn: server})
+	}()
+	waitFor(t, "the client", func() bool { return h.Len() == 1 })
+
+	for i := 0; h.Len() > 0; i++ {
+		if i == 100 {
+			t.Fatal("expected the client to be evicted")
+		}
+		h.Broadcast([]byte("blocked"))
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return")
+	}
+}
+
+func TestHubReplace(t *testing.T) {
+	h := NewHub(nil)
+	defer h.Close()
+
+	old := &Client{Id: "a", Account: 1}
+	c := &Client{Id: "a", Account: 1}
+	h.Register(old)
+	h.Register(c)
+	if _, ok := <-old.SendChan; ok {
+		t.Error("expected the replaced client to be closed")
+	}
+
+	// the replaced client doesn't remove the new one
+	h.Unregister(old)
+	if h.Len() != 1 {
+		t.Errorf("expected 1 client, got %d", h.Len())
+	}
+	h.Unregister(c)
+	h.Unregister(c)
+	if h.Len() != 0 {
+		t.Errorf("expected no client, got %d", h.Len())
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := NewHub(nil)
+	url := newTestServer(t, h)
+
+	conn := dial(t, url, "account=1")
+	waitFor(t, "the client", func() bool { return h.Len() == 1 })
+
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("expected a second Close to succeed, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := wsutil.ReadServerText(conn); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+	if err := h.Register(&Client{Id: "late"}); !errors.Is(err, ErrHubClosed) {
+		t.Errorf("expected ErrHubClosed, got %v", err)
+	}
+	h.Broadcast([]byte("nobody"))
+}
+
+func TestHubConcurrent(t *testing.T) {
+	h := NewHub(&HubOption{QueueSize: 4})
+	defer h.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c := &Client{Id: fmt.Sprint(j % 10), Account: int64(i)}
+				h.Register(c)
+				h.Subscribe(c, fmt.Sprint("topic/", j%2))
+				if j%3 == 0 {
+					h.Unregister(c)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h.Broadcast([]byte("m"))
+				h.Publish(fmt.Sprint("topic/", j%2), &Message{Type: 1})
+				h.Len()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestHubSendTo(t *testing.T) {
+	h := NewHub(nil)
+	defer h.Close()
+	url := newTestServer(t, h)
+
+	a1, a2, b := dial(t, url, "account=1&id=a1"), dial(t, url, "account=1&id=a2"), dial(t, url, "account=2&id=b")
+	waitFor(t, "the clients", func() bool { return h.Len() == 3 })
+
+	if err := h.SendToAccount(1, &Message{Type: 1, Raw: []byte(`"account"`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SendToClient(2, "b", &Message{Type: 2, Raw: []byte(`"client"`)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []net.Conn{a1, a2} {
+		if got := readText(t, conn); got != `{"type":1,"raw":"account"}` {
+			t.Errorf("unexpected message %s", got)
+		}
+	}
+	if got := readText(t, b); got != `{"type":2,"raw":"client"}` {
+		t.Errorf("unexpected message %s", got)
+	}
+
+	if err := h.SendToAccount(3, &Message{}); !errors.Is(err, ErrNoClient) {
+		t.Errorf("expected ErrNoClient, got %v", err)
+	}
+	if err := h.SendToClient(1, "b", &Message{}); !errors.Is(err, ErrNoClient) {
+		t.Errorf("expected ErrNoClient, got %v", err)
+	}
+}
+
+func TestHubTopics(t *testing.T) {
+	h := NewHub(nil)
+	defer h.Close()
+
+	a := &Client{Id: "a", Account: 1}
+	b := &Client{Id: "b", Account: 2}
+	h.Register(a)
+	h.Register(b)
+
+	if err := h.Subscribe(a, "task/1"); err != nil {
+		t.Fatal(err)
+	}
+	h.Subscribe(b, "task/1")
+	h.Subscribe(b, "vm/2")
+	if err := h.Subscribe(&Client{Id: "c"}, "task/1"); !errors.Is(err, ErrNoClient) {
+		t.Errorf("expected ErrNoClient, got %v", err)
+	}
+
+	h.Publish("task/1", &Message{Type: 1, Raw: []byte(`50`)})
+	h.Publish("vm/2", &Message{Type: 2, Raw: []byte(`"running"`)})
+	h.Publish("vm/3", &Message{Type: 2, Raw: []byte(`"stopped"`)})
+	if len(a.SendChan) != 1 || string(<-a.SendChan) != `{"type":1,"raw":50}` {
+		t.Error("expected the task message for a")
+	}
+	if len(b.SendChan) != 2 {
+		t.Errorf("expected 2 messages for b, got %d", len(b.SendChan))
+	}
+
+	h.Unsubscribe(b, "vm/2")
+	h.Publish("vm/2", &Message{Type: 2})
+	if len(b.SendChan) != 2 {
+		t.Error("expected no message after Unsubscribe")
+	}
+
+	// unregistered and replaced clients are unsubscribed
+	h.Unregister(a)
+	h.Register(&Client{Id: "b", Account: 2})
+	h.mu.RLock()
+	n := len(h.topics)
+	h.mu.RUnlock()
+	if n != 0 {
+		t.Errorf("expected no topic, got %d", n)
+	}
+}
@@ -3,6 +3,7 @@
 package ws
 
 import (
+	"errors"
 	"io"
 	"net"
 	"sync"
@@ -19,174 +20,143 @@ import (
 var (
 	json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-	// WSManager define a ws server manager
-	Manager = &WSManager{
-		Broadcast:  make(chan []byte, 10),
-		Register:   make(chan *Client, 10),
-		Unregister: make(chan *Client, 10),
-		Clients:    make(map[int64]map[string]*Client, 20),
-	}
+	ErrClientClosed = errors.New("ws: client closed")
+	ErrQueueFull    = errors.New("ws: client queue full")
 )
 
-// WSManager is a websocket manager
-type WSManager struct {
-	sync.RWMutex
-	Clients    map[int64]map[string]*Client
-	Broadcast  chan []byte
-	Register   chan *Client
-	Unregister chan *Client
-}
-
 type Message struct {
 	Type int64               `json:"type"`
 	Raw  jsoniter.RawMessage `json:"raw"`
 }
 
-func (manager *WSManager) Run() {
-	log.Glog.Info("ws manager run")
-
-	for {
-		select {
-		case client := <-manager.Register:
-			log.Glog.Info("ws register", zap.Int64("account", client.Account), zap.String("id", client.Id))
-
-			manager.Lock()
-
-			ls := Manager.Clients[client.Account]
-			if len(ls) == 0 {
-				ls = make(map[string]*Client, 10)
-			}
-
-			ls[client.Id] = client
-			Manager.Clients[client.Account] = ls
-
-			manager.Unlock()
-		case client := <-manager.Unregister:
-			log.Glog.Info("ws unregister", zap.Int64("account", client.Account), zap.String("id", client.Id))
-
-			manager.Lock()
-
-			if ls, ok := manager.Clients[client.Account]; ok {
-				delete(ls, client.Id)
-				if len(ls) == 0 {
-					delete(manager.Clients, client.Account)
-				}
-
-				if err := client.Close(); err != nil {
-					log.Glog.Info("client close", zap.Int64("account", client.Account), zap.String("id", client.Id))
-				}
-			}
-
-			manager.Unlock()
-		case m := <-Manager.Broadcast:
-			manager.Lock()
-
-			for _, clients := range Manager.Clients {
-				for _, client := range clients {
-					select {
-					case client.SendChan <- m:
-					}
-				}
-			}
-
-			manager.Unlock()
-		}
-	}
-}
-
 // Client 单个 websocket 信息
 type Client struct {
-	Id       string
-	Account  int64
-	Conn     net.Conn
+	Id      string
+	Account int64
+	Conn    net.Conn
+	// SendChan queues the messages written by Hub.Serve, it is made by
+	// Hub.Register if nil and closed by Close.
 	SendChan chan []byte
-	Reader   *wsutil.Reader
-	Writer   *wsutil.Writer
+
+	mu     sync.Mutex
+	closed bool
+	// topics are the topics subscribed, guarded by the mutex of the Hub
+	topics map[string]bool
+	// wmu serializes the writes of frames to Conn
+	wmu sync.Mutex
 }
 
+// Close closes SendChan and Conn, it can be called many times.
 func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.SendChan != nil {
+		close(c.SendChan)
+	}
 	if c.Conn != nil {
 		return c.Conn.Close()
 	}
-	close(c.SendChan)
 
 	return nil
 }
 
-// 读信息，从 websocket 连接直接读取数据
-// 数据message和close message是单独发送的, close message允许携带reason
-// 逻辑from wsutil.ReadClientData(conn)
-func (c *Client) Read() {
-	defer func() {
-		log.Glog.Info("client reader exit", zap.String("id", c.Id))
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		Manager.Unregister <- c
-	}()
+	return c.closed
+}
 
-	for {
-		hdr, err := c.Reader.NextFrame()
-		if err != nil {
-			log.Glog.Error("client read header error", zap.String("id", c.Id), zap.Error(err))
+// send queues msg without blocking.
+func (c *Client) send(msg []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-			return
-		}
+	if c.closed {
+		return ErrClientClosed
+	}
+	select {
+	case c.SendChan <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
 
-		payload := make([]byte, hdr.Length) // len=0 when hdr.OpCode == ws.OpClose
-		if _, err = io.ReadFull(c.Reader, payload); err != nil {
-			log.Glog.Error("client read msg error", zap.String("id", c.Id), zap.Error(err))
+// write writes msg as a single frame, within timeout if not 0.
+func (c *Client) write(op ws.OpCode, msg []byte, timeout time.Duration) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
 
-			return
-		}
+	if timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	return wsutil.WriteServerMessage(c.Conn, op, msg)
+}
 
-		log.Glog.Debug("playload", zap.String("id", c.Id), zap.Any("op", hdr.OpCode), zap.Int64("len", hdr.Length), zap.String("data", string(payload)))
+// lockedWriter writes the replies to the control frames between the
+// messages written by Client.write.
+type lockedWriter struct {
+	c *Client
+}
 
-		if hdr.OpCode == ws.OpClose {
-			code, reason := ws.ParseCloseFrameData(payload)
-			log.Glog.Info("client close reason", zap.String("id", c.Id), zap.Any("code", code), zap.String("reason", reason))
+func (w lockedWriter) Write(b []byte) (int, error) {
+	w.c.wmu.Lock()
+	defer w.c.wmu.Unlock()
 
-			c.Conn.Write(ws.CompiledClose)
-			return
-		}
-
-		// todo
-		c.SendChan <- payload
-	}
+	return w.c.Conn.Write(b)
 }
 
-// 写信息，从 SendChan 中读取数据写入 websocket 连接
-func (c *Client) Write() {
-	defer func() {
-		log.Glog.Info("client Write exit", zap.String("id", c.Id))
-
-		Manager.Unregister <- c
-	}()
+// 读信息，从 websocket 连接直接读取数据, 直到连接关闭
+// ping 和 close 由 ControlFrameHandler 回复, 逻辑from wsutil.ReadClientData(conn)
+func (c *Client) read(maxFrameSize int64, onMessage func(c *Client, op ws.OpCode, data []byte)) error {
+	control := wsutil.ControlFrameHandler(lockedWriter{c}, ws.StateServerSide)
+	rd := &wsutil.Reader{
+		Source:         c.Conn,
+		State:          ws.StateServerSide,
+		CheckUTF8:      true,
+		MaxFrameSize:   maxFrameSize,
+		OnIntermediate: control,
+	}
 
 	for {
-		select {
-		case msg, ok := <-c.SendChan:
-			if !ok {
-				c.Conn.Write(ws.CompiledClose)
-				return
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			return err
+		}
+		if hdr.OpCode.IsControl() {
+			if err = control(hdr, rd); err != nil {
+				return err
 			}
-			log.Glog.Debug("send", zap.String("id", c.Id), zap.String("data", string(msg)))
+			continue
+		}
 
-			if err := wsutil.WriteServerMessage(c.Conn, ws.OpText, msg); err != nil {
-				log.Glog.Error("client write error", zap.String("id", c.Id), zap.Error(err))
+		payload, err := io.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		log.Glog.Debug("playload", zap.String("id", c.Id), zap.Any("op", hdr.OpCode), zap.Int("len", len(payload)))
 
-				return
-			}
+		if onMessage != nil {
+			onMessage(c, hdr.OpCode, payload)
 		}
 	}
 }
 
-func (c *Client) WriteTest() {
-	// 测试单个 client 发送数据 by wsutil.WriteServerMessage
-	c.SendChan <- []byte("Send message ----" + time.Now().Format("2006-01-02 15:04:05"))
+// 写信息，从 SendChan 中读取数据写入 websocket 连接, 直到 SendChan 关闭
+func (c *Client) writeLoop(timeout time.Duration) error {
+	for msg := range c.SendChan {
+		log.Glog.Debug("send", zap.String("id", c.Id), zap.Int("len", len(msg)))
 
-	// write by wsutil.NewWriter
-	c.Writer.Reset(c.Conn, ws.StateServerSide, ws.OpText)
-	c.Writer.Write([]byte("test2"))
-	if err := c.Writer.Flush(); err != nil {
-		log.Glog.Error("client write flush error", zap.String("id", c.Id), zap.Error(err))
+		if err := c.write(ws.OpText, msg, timeout); err != nil {
+			return err
+		}
 	}
+
+	return ErrClientClosed
 }